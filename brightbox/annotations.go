@@ -18,6 +18,7 @@ const (
 	// serviceAnnotationLoadBalancerBufferSize is the annotation used
 	// on the server to specify the way balancing is done.
 	// One of "least-connections", "round-robin" or "source-address"
+	// Services with ClientIP session affinity always use "source-address".
 	serviceAnnotationLoadBalancerPolicy = "service.beta.kubernetes.io/brightbox-load-balancer-policy"
 
 	// ServiceAnnotationLoadBalancerListenerProtocol is the annotation used
@@ -39,6 +40,26 @@ const (
 	// and specify the type of information that should be contained within it
 	serviceAnnotationLoadBalancerListenerProxyProtocol = "service.beta.kubernetes.io/brightbox-load-balancer-listener-proxy-protocol"
 
+	// ServiceAnnotationLoadBalancerListenerPortProtocols is the
+	// annotation used on the service to set the listener protocol of
+	// individual ports, overriding `listener-protocol`. The entry is a
+	// comma separated list of `port=protocol` pairs, where the port is
+	// a port name or number, e.g. `80=http,postgres=tcp`.
+	// HTTP listeners pass WebSocket upgrades through to the backend.
+	serviceAnnotationLoadBalancerListenerPortProtocols = "service.beta.kubernetes.io/brightbox-load-balancer-listener-port-protocols"
+
+	// ServiceAnnotationLoadBalancerListenerPortIdleTimeouts is the
+	// annotation used on the service to set the idle connection timeout
+	// of individual ports, overriding `listener-idle-timeout`, e.g.
+	// `ws=3600000`.
+	serviceAnnotationLoadBalancerListenerPortIdleTimeouts = "service.beta.kubernetes.io/brightbox-load-balancer-listener-port-idle-timeouts"
+
+	// ServiceAnnotationLoadBalancerListenerPortProxyProtocols is the
+	// annotation used on the service to set the PROXY protocol of
+	// individual ports, overriding `listener-proxy-protocol`, e.g.
+	// `5432=v2`.
+	serviceAnnotationLoadBalancerListenerPortProxyProtocols = "service.beta.kubernetes.io/brightbox-load-balancer-listener-port-proxy-protocols"
+
 	// ServiceAnnotationLoadBalancerSSLPorts is the annotation used on the service
 	// to specify a comma-separated list of ports that will use SSL/HTTPS
 	// listeners rather than plain 'http' listeners. Defaults to '443'.
@@ -53,13 +74,70 @@ const (
 	// of the service, or via a CNAME onto the ingress address hostname
 	serviceAnnotationLoadBalancerSslDomains = "service.beta.kubernetes.io/brightbox-load-balancer-ssl-domains"
 
+	// ServiceAnnotationLoadBalancerReverseDNS is the annotation used on
+	// the service to set the reverse DNS of its Cloud IPs. The name has
+	// to resolve to the Cloud IPs it is set on, and is added to the
+	// domains on the Let's Encrypt certificate.
+	serviceAnnotationLoadBalancerReverseDNS = "service.beta.kubernetes.io/brightbox-load-balancer-reverse-dns"
+
+	// ServiceAnnotationLoadBalancerSkipDomainResolution is the annotation
+	// used on the service to trust that the `ssl-domains` and Cloud IP
+	// names resolve to the load balancer without looking them up. For
+	// split horizon DNS the controller cannot see past. Set to "true"
+	// to skip the check.
+	serviceAnnotationLoadBalancerSkipDomainResolution = "service.beta.kubernetes.io/brightbox-load-balancer-skip-domain-resolution"
+
+	// ServiceAnnotationLoadBalancerSSLCertificateSecret is the annotation
+	// used on the service to name a `kubernetes.io/tls` Secret in the
+	// service's namespace. The certificate and key in the Secret are
+	// uploaded to the load balancer and used by the https listeners
+	// instead of a Let's Encrypt certificate. Cannot be used with
+	// `ssl-domains`.
+	serviceAnnotationLoadBalancerSSLCertificateSecret = "service.beta.kubernetes.io/brightbox-load-balancer-ssl-certificate-secret"
+
 	// ServiceAnnotationLoadBalancerCloudipAllocations is the
-	// annotation used to specify the ID of the CloudIP that should
+	// annotation used to specify the IDs of the CloudIPs that should
 	// be mapped to the load balancer. It replaces the deprecated
-	// `spec.loadBalancerIP` entry and should be in the form
-	// `cip-xxxxx`. Only one cloudip can be specified.
+	// `spec.loadBalancerIP` entry and should be a comma separated list
+	// in the form `cip-xxxxx,cip-yyyyy`. The list is the complete set:
+	// any other Cloud IP is unmapped from the load balancer. Services in
+	// Cloud IP mode can only list one.
 	serviceAnnotationLoadBalancerCloudipAllocations = "service.beta.kubernetes.io/brightbox-load-balancer-cloudip-allocations"
 
+	// ServiceAnnotationLoadBalancerCloudipRetain is the annotation used
+	// on the service to keep the Cloud IPs the controller allocated when
+	// the service is deleted. Set to "true" and they are unmapped and
+	// renamed as released instead of destroyed, and a service with the
+	// same load balancer name picks them up again. Set it cluster wide
+	// with defaultAnnotations in the cloud config.
+	serviceAnnotationLoadBalancerCloudipRetain = "service.beta.kubernetes.io/brightbox-load-balancer-cloudip-retain"
+
+	// ServiceAnnotationLoadBalancerName holds the name given to the
+	// Brightbox resources built for the service. The controller records
+	// it when the load balancer is first built and uses it from then on,
+	// so the resources survive a change of --cluster-name. Set it before
+	// the load balancer is built to choose the name.
+	serviceAnnotationLoadBalancerName = "service.beta.kubernetes.io/brightbox-load-balancer-name"
+
+	// ServiceAnnotationLoadBalancerMode is the annotation used on the
+	// service to choose how it is given an address. One of
+	// "load-balancer" (default) or "cloud-ip", which maps a Cloud IP
+	// straight to a node with port translators instead of building a
+	// load balancer. The Cloud IP load balancer class always uses
+	// "cloud-ip".
+	serviceAnnotationLoadBalancerMode = "service.beta.kubernetes.io/brightbox-load-balancer-mode"
+
+	// ServiceAnnotationLoadBalancerCloudIPTarget is the annotation used
+	// on services in Cloud IP mode to choose what the Cloud IP is mapped
+	// to. One of "node" (default), a single ready node chosen by the
+	// controller, or "server-group", the service's server group.
+	serviceAnnotationLoadBalancerCloudIPTarget = "service.beta.kubernetes.io/brightbox-load-balancer-cloud-ip-target"
+
+	// ServiceAnnotationLoadBalancerStatusMode is the annotation used
+	// on the service to choose what is published in the load balancer
+	// ingress status. One of "hostname" (default), "ip" or "both".
+	serviceAnnotationLoadBalancerStatusMode = "service.beta.kubernetes.io/brightbox-load-balancer-status-mode"
+
 	// ServiceAnnotationLoadBalancerHCHealthyThreshold is the
 	// annotation used on the service to specify the number of successive
 	// successful health checks required for a backend to be considered
@@ -0,0 +1,388 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/k8ssdk/v2"
+	"k8s.io/klog/v2"
+)
+
+const defaultAPICacheTTL = 30 * time.Second
+
+// Resources held by the API cache, as labelled in its metrics
+const (
+	cacheResourceServer   = "server"
+	cacheResourceServers  = "servers"
+	cacheResourceCloudIPs = "cloud_ips"
+)
+
+// apiCache holds recent server and Cloud IP lookups for a short time,
+// so the node controller syncing every node and the load balancer
+// steps listing the Cloud IPs share API calls. The server list, used
+// for bulk server lookups, is held the same way. Any change made through
+// the API drops everything, as a change to one resource can show up in
+// another: mapping a Cloud IP changes the server it is mapped to. A
+// nil cache holds nothing.
+type apiCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+	// Bumped on every invalidation, so a lookup started before a change
+	// doesn't store what it read
+	generation        uint64
+	servers           map[string]cachedServer
+	serverList        []brightbox.Server
+	serverListExpires time.Time
+	cloudIPs          []brightbox.CloudIP
+	cloudIPsExpires   time.Time
+}
+
+type cachedServer struct {
+	server  *brightbox.Server
+	expires time.Time
+}
+
+func newAPICache(ttl time.Duration) *apiCache {
+	return &apiCache{
+		ttl:     ttl,
+		now:     time.Now,
+		servers: map[string]cachedServer{},
+	}
+}
+
+// server returns a copy of the cached server, if there is one
+func (a *apiCache) server(identifier string) (*brightbox.Server, uint64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.servers[identifier]
+	if !ok || !a.now().Before(entry.expires) {
+		apiCacheLookups.WithLabelValues(cacheResourceServer, "miss").Inc()
+		return nil, a.generation, false
+	}
+	apiCacheLookups.WithLabelValues(cacheResourceServer, "hit").Inc()
+	result := *entry.server
+	return &result, a.generation, true
+}
+
+// storeServer caches a server read at the generation given, dropping
+// any entries that have expired
+func (a *apiCache) storeServer(generation uint64, srv *brightbox.Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if generation != a.generation {
+		return
+	}
+	now := a.now()
+	for id, entry := range a.servers {
+		if !now.Before(entry.expires) {
+			delete(a.servers, id)
+		}
+	}
+	stored := *srv
+	a.servers[srv.ID] = cachedServer{server: &stored, expires: now.Add(a.ttl)}
+}
+
+// serverListing returns a copy of the cached server list, if there is
+// one
+func (a *apiCache) serverListing() ([]brightbox.Server, uint64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.serverList == nil || !a.now().Before(a.serverListExpires) {
+		apiCacheLookups.WithLabelValues(cacheResourceServers, "miss").Inc()
+		return nil, a.generation, false
+	}
+	apiCacheLookups.WithLabelValues(cacheResourceServers, "hit").Inc()
+	return slices.Clone(a.serverList), a.generation, true
+}
+
+// storeServerList caches a server list read at the generation given
+func (a *apiCache) storeServerList(generation uint64, list []brightbox.Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if generation != a.generation {
+		return
+	}
+	a.serverList = slices.Clip(slices.Clone(list))
+	if a.serverList == nil {
+		a.serverList = []brightbox.Server{}
+	}
+	a.serverListExpires = a.now().Add(a.ttl)
+}
+
+// cloudIPList returns a copy of the cached Cloud IP list, if there is one
+func (a *apiCache) cloudIPList() ([]brightbox.CloudIP, uint64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cloudIPs == nil || !a.now().Before(a.cloudIPsExpires) {
+		apiCacheLookups.WithLabelValues(cacheResourceCloudIPs, "miss").Inc()
+		return nil, a.generation, false
+	}
+	apiCacheLookups.WithLabelValues(cacheResourceCloudIPs, "hit").Inc()
+	return slices.Clone(a.cloudIPs), a.generation, true
+}
+
+// storeCloudIPList caches a Cloud IP list read at the generation given
+func (a *apiCache) storeCloudIPList(generation uint64, list []brightbox.CloudIP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if generation != a.generation {
+		return
+	}
+	a.cloudIPs = slices.Clip(slices.Clone(list))
+	if a.cloudIPs == nil {
+		a.cloudIPs = []brightbox.CloudIP{}
+	}
+	a.cloudIPsExpires = a.now().Add(a.ttl)
+}
+
+// invalidate drops everything in the cache
+func (a *apiCache) invalidate() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.generation++
+	clear(a.servers)
+	a.serverList = nil
+	a.cloudIPs = nil
+}
+
+// cachingClient serves server and Cloud IP lookups from the cache and
+// drops the cache whenever a call changes something. Every other call
+// goes straight through to the wrapped client. Calls that change
+// resources need to be listed here, or the cache will miss the change
+// until it expires.
+type cachingClient struct {
+	k8ssdk.CloudAccess
+	cache *apiCache
+}
+
+// cacheCloud replaces the API client in the cloud with one that serves
+// lookups from the cache
+func cacheCloud(cloud *k8ssdk.Cloud, cache *apiCache) (*k8ssdk.Cloud, error) {
+	client, err := cloud.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := client.(*cachingClient); ok {
+		return cloud, nil
+	}
+	return wrapAPIClient(&cachingClient{CloudAccess: client, cache: cache}), nil
+}
+
+// invalidating makes a call that changes resources, then drops the
+// cache. The cache is dropped even if the call fails, as the change may
+// have been made regardless.
+func invalidating[T any](c *cachingClient, call func() (T, error)) (T, error) {
+	defer c.cache.invalidate()
+	return call()
+}
+
+func (c *cachingClient) Server(ctx context.Context, identifier string) (*brightbox.Server, error) {
+	srv, generation, ok := c.cache.server(identifier)
+	if ok {
+		klog.V(4).Infof("Server %q served from cache", identifier)
+		return srv, nil
+	}
+	srv, err := c.CloudAccess.Server(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.storeServer(generation, srv)
+	return srv, nil
+}
+
+func (c *cachingClient) Servers(ctx context.Context) ([]brightbox.Server, error) {
+	lister, ok := c.CloudAccess.(serverLister)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support listing servers")
+	}
+	list, generation, ok := c.cache.serverListing()
+	if ok {
+		klog.V(4).Info("Servers served from cache")
+		return list, nil
+	}
+	list, err := lister.Servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.storeServerList(generation, list)
+	return list, nil
+}
+
+func (c *cachingClient) CloudIPs(ctx context.Context) ([]brightbox.CloudIP, error) {
+	list, generation, ok := c.cache.cloudIPList()
+	if ok {
+		klog.V(4).Info("Cloud IPs served from cache")
+		return list, nil
+	}
+	list, err := c.CloudAccess.CloudIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.storeCloudIPList(generation, list)
+	return list, nil
+}
+
+func (c *cachingClient) CreateServer(ctx context.Context, options brightbox.ServerOptions) (*brightbox.Server, error) {
+	return invalidating(c, func() (*brightbox.Server, error) {
+		return c.CloudAccess.CreateServer(ctx, options)
+	})
+}
+
+func (c *cachingClient) CreateLoadBalancer(ctx context.Context, options brightbox.LoadBalancerOptions) (*brightbox.LoadBalancer, error) {
+	return invalidating(c, func() (*brightbox.LoadBalancer, error) {
+		return c.CloudAccess.CreateLoadBalancer(ctx, options)
+	})
+}
+
+func (c *cachingClient) UpdateLoadBalancer(ctx context.Context, options brightbox.LoadBalancerOptions) (*brightbox.LoadBalancer, error) {
+	return invalidating(c, func() (*brightbox.LoadBalancer, error) {
+		return c.CloudAccess.UpdateLoadBalancer(ctx, options)
+	})
+}
+
+func (c *cachingClient) MapCloudIP(ctx context.Context, identifier string, attachment brightbox.CloudIPAttachment) (*brightbox.CloudIP, error) {
+	return invalidating(c, func() (*brightbox.CloudIP, error) {
+		return c.CloudAccess.MapCloudIP(ctx, identifier, attachment)
+	})
+}
+
+func (c *cachingClient) UnMapCloudIP(ctx context.Context, identifier string) (*brightbox.CloudIP, error) {
+	return invalidating(c, func() (*brightbox.CloudIP, error) {
+		return c.CloudAccess.UnMapCloudIP(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) CreateCloudIP(ctx context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	return invalidating(c, func() (*brightbox.CloudIP, error) {
+		return c.CloudAccess.CreateCloudIP(ctx, options)
+	})
+}
+
+func (c *cachingClient) AddServersToServerGroup(ctx context.Context, identifier string, members brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	return invalidating(c, func() (*brightbox.ServerGroup, error) {
+		return c.CloudAccess.AddServersToServerGroup(ctx, identifier, members)
+	})
+}
+
+func (c *cachingClient) RemoveServersFromServerGroup(ctx context.Context, identifier string, members brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	return invalidating(c, func() (*brightbox.ServerGroup, error) {
+		return c.CloudAccess.RemoveServersFromServerGroup(ctx, identifier, members)
+	})
+}
+
+func (c *cachingClient) CreateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	return invalidating(c, func() (*brightbox.ServerGroup, error) {
+		return c.CloudAccess.CreateServerGroup(ctx, options)
+	})
+}
+
+func (c *cachingClient) CreateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	return invalidating(c, func() (*brightbox.FirewallPolicy, error) {
+		return c.CloudAccess.CreateFirewallPolicy(ctx, options)
+	})
+}
+
+func (c *cachingClient) CreateFirewallRule(ctx context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	return invalidating(c, func() (*brightbox.FirewallRule, error) {
+		return c.CloudAccess.CreateFirewallRule(ctx, options)
+	})
+}
+
+func (c *cachingClient) UpdateFirewallRule(ctx context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	return invalidating(c, func() (*brightbox.FirewallRule, error) {
+		return c.CloudAccess.UpdateFirewallRule(ctx, options)
+	})
+}
+
+func (c *cachingClient) DestroyServer(ctx context.Context, identifier string) (*brightbox.Server, error) {
+	return invalidating(c, func() (*brightbox.Server, error) {
+		return c.CloudAccess.DestroyServer(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) DestroyServerGroup(ctx context.Context, identifier string) (*brightbox.ServerGroup, error) {
+	return invalidating(c, func() (*brightbox.ServerGroup, error) {
+		return c.CloudAccess.DestroyServerGroup(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) DestroyFirewallPolicy(ctx context.Context, identifier string) (*brightbox.FirewallPolicy, error) {
+	return invalidating(c, func() (*brightbox.FirewallPolicy, error) {
+		return c.CloudAccess.DestroyFirewallPolicy(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) DestroyLoadBalancer(ctx context.Context, identifier string) (*brightbox.LoadBalancer, error) {
+	return invalidating(c, func() (*brightbox.LoadBalancer, error) {
+		return c.CloudAccess.DestroyLoadBalancer(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) DestroyCloudIP(ctx context.Context, identifier string) (*brightbox.CloudIP, error) {
+	return invalidating(c, func() (*brightbox.CloudIP, error) {
+		return c.CloudAccess.DestroyCloudIP(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) DestroyFirewallRule(ctx context.Context, identifier string) (*brightbox.FirewallRule, error) {
+	destroyer, ok := c.CloudAccess.(firewallRuleDestroyer)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support destroying firewall rules")
+	}
+	return invalidating(c, func() (*brightbox.FirewallRule, error) {
+		return destroyer.DestroyFirewallRule(ctx, identifier)
+	})
+}
+
+func (c *cachingClient) UpdateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	updater, ok := c.CloudAccess.(serverGroupUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating server groups")
+	}
+	return invalidating(c, func() (*brightbox.ServerGroup, error) {
+		return updater.UpdateServerGroup(ctx, options)
+	})
+}
+
+func (c *cachingClient) UpdateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	updater, ok := c.CloudAccess.(firewallPolicyUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating firewall policies")
+	}
+	return invalidating(c, func() (*brightbox.FirewallPolicy, error) {
+		return updater.UpdateFirewallPolicy(ctx, options)
+	})
+}
+
+func (c *cachingClient) UpdateCloudIP(ctx context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	updater, ok := c.CloudAccess.(cloudIPUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating Cloud IPs")
+	}
+	return invalidating(c, func() (*brightbox.CloudIP, error) {
+		return updater.UpdateCloudIP(ctx, options)
+	})
+}
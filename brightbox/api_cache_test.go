@@ -0,0 +1,191 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/serverstatus"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/brightbox/k8ssdk/v2/mocks"
+	"github.com/go-test/deep"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// fakeCachedCloud records the lookups that reach the API
+type fakeCachedCloud struct {
+	mocks.CloudAccess
+	calls    []string
+	fail     bool
+	listFail bool
+}
+
+func (f *fakeCachedCloud) Server(_ context.Context, identifier string) (*brightbox.Server, error) {
+	f.calls = append(f.calls, "server "+identifier)
+	if f.fail {
+		return nil, errors.New("API unavailable")
+	}
+	return &brightbox.Server{ID: identifier, Name: "worker", Status: serverstatus.Active}, nil
+}
+
+func (f *fakeCachedCloud) Servers(context.Context) ([]brightbox.Server, error) {
+	f.calls = append(f.calls, "servers")
+	if f.listFail {
+		return nil, errors.New("API unavailable")
+	}
+	return []brightbox.Server{
+		{ID: "srv-list1", Status: serverstatus.Active},
+		{ID: "srv-list2", Status: serverstatus.Inactive},
+	}, nil
+}
+
+func (f *fakeCachedCloud) CloudIPs(context.Context) ([]brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "cloud ips")
+	return []brightbox.CloudIP{{ID: "cip-testy"}}, nil
+}
+
+func (f *fakeCachedCloud) UnMapCloudIP(_ context.Context, identifier string) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "unmap "+identifier)
+	return nil, errors.New("Cloud IP busy")
+}
+
+func (f *fakeCachedCloud) UpdateCloudIP(_ context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "update "+options.ID)
+	return &brightbox.CloudIP{ID: options.ID}, nil
+}
+
+func TestCachingClient(t *testing.T) {
+	registerMetrics()
+	fake := &fakeCachedCloud{}
+	cache := newAPICache(time.Minute)
+	now := time.Unix(1760000000, 0)
+	cache.now = func() time.Time { return now }
+	cached, err := cacheCloud(k8ssdk.MakeTestClient(fake, nil), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &cloud{Cloud: cached, cache: cache}
+	ctx := context.TODO()
+	hits := apiCacheLookups.WithLabelValues(cacheResourceServer, "hit")
+	misses := apiCacheLookups.WithLabelValues(cacheResourceServer, "miss")
+	hitsBefore, _ := testutil.GetCounterMetricValue(hits)
+	missesBefore, _ := testutil.GetCounterMetricValue(misses)
+
+	lookup := func() {
+		t.Helper()
+		if _, err := client.GetServer(ctx, "srv-testy", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetCloudIPs(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookup()
+	// Served from the cache
+	srv, err := client.GetServer(ctx, "srv-testy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Name = "changed"
+	lookup()
+	// Expired
+	now = now.Add(time.Minute)
+	lookup()
+	// A change drops the cache, even when it fails
+	if _, err := client.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: "cip-testy"}); err != nil {
+		t.Fatal(err)
+	}
+	lookup()
+	api, err := client.CloudClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.UnMapCloudIP(ctx, "cip-testy"); err == nil {
+		t.Error("expected the unmap to fail")
+	}
+	lookup()
+	expected := []string{
+		"server srv-testy", "cloud ips",
+		"server srv-testy", "cloud ips",
+		"update cip-testy",
+		"server srv-testy", "cloud ips",
+		"unmap cip-testy",
+		"server srv-testy", "cloud ips",
+	}
+	if diff := deep.Equal(fake.calls, expected); diff != nil {
+		t.Error(diff)
+	}
+	// Callers get their own copy
+	srv, err = client.GetServer(ctx, "srv-testy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(srv.Name, "worker"); diff != nil {
+		t.Error(diff)
+	}
+	hitsAfter, _ := testutil.GetCounterMetricValue(hits)
+	missesAfter, _ := testutil.GetCounterMetricValue(misses)
+	if diff := deep.Equal([]float64{hitsAfter - hitsBefore, missesAfter - missesBefore}, []float64{3, 4}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Wrapping twice is a no-op
+	again, err := cacheCloud(cached, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != cached {
+		t.Errorf("expected the caching client to be reused")
+	}
+}
+
+func TestCachingClientErrors(t *testing.T) {
+	fake := &fakeCachedCloud{fail: true}
+	cache := newAPICache(time.Minute)
+	cached, err := cacheCloud(k8ssdk.MakeTestClient(fake, nil), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &cloud{Cloud: cached}
+	for range 2 {
+		if _, err := client.GetServer(context.TODO(), "srv-testy", nil); err == nil {
+			t.Error("expected an error")
+		}
+	}
+	if diff := deep.Equal(fake.calls, []string{"server srv-testy", "server srv-testy"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAPICacheStaleRead(t *testing.T) {
+	cache := newAPICache(time.Minute)
+	_, generation, ok := cache.server("srv-testy")
+	if ok {
+		t.Fatal("expected an empty cache")
+	}
+	// A change lands while the server is being read
+	cache.invalidate()
+	cache.storeServer(generation, &brightbox.Server{ID: "srv-testy"})
+	if _, _, ok := cache.server("srv-testy"); ok {
+		t.Error("expected the stale read to be dropped")
+	}
+	// A nil cache holds nothing
+	var none *apiCache
+	none.invalidate()
+}
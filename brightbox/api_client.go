@@ -0,0 +1,315 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/k8ssdk/v2"
+	"k8s.io/klog/v2"
+)
+
+// instrumentedClient wraps the Brightbox API client and records
+// metrics for every call made through it, which its guard rate limits
+// and retries. It implements
+// k8ssdk.CloudAccess and the extension interfaces in cloud_access.go,
+// passing extension calls through when the wrapped client supports
+// them.
+type instrumentedClient struct {
+	client k8ssdk.CloudAccess
+	guard  *apiGuard
+}
+
+// instrumentCloud replaces the API client in the cloud with one that
+// records metrics and makes its calls through the guard
+func instrumentCloud(cloud *k8ssdk.Cloud, guard *apiGuard) (*k8ssdk.Cloud, error) {
+	client, err := cloud.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := client.(*instrumentedClient); ok {
+		return cloud, nil
+	}
+	return wrapAPIClient(&instrumentedClient{client: client, guard: guard}), nil
+}
+
+// instrument makes an API call through the guard, timing and counting
+// each attempt by operation and result
+func instrument[T any](ctx context.Context, guard *apiGuard, operation string, call func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		if err := guard.allow(ctx); err != nil {
+			var none T
+			return none, err
+		}
+		start := time.Now()
+		result, err := call()
+		apiRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		apiRequests.WithLabelValues(operation, apiResultCode(err)).Inc()
+		guard.record(err)
+		if err == nil || !guard.retry(ctx, operation, attempt, err) {
+			return result, err
+		}
+		klog.V(4).Infof("Retrying %s after: %v", operation, err)
+	}
+}
+
+// apiResultCode returns "ok" for a successful call, the HTTP status code
+// for an API error and "error" for anything else, such as a network
+// failure.
+func apiResultCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var apiErr *brightbox.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != 0 {
+		return strconv.Itoa(apiErr.StatusCode)
+	}
+	return "error"
+}
+
+func (c *instrumentedClient) Server(ctx context.Context, identifier string) (*brightbox.Server, error) {
+	return instrument(ctx, c.guard, "Server", func() (*brightbox.Server, error) {
+		return c.client.Server(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) CreateServer(ctx context.Context, options brightbox.ServerOptions) (*brightbox.Server, error) {
+	return instrument(ctx, c.guard, "CreateServer", func() (*brightbox.Server, error) {
+		return c.client.CreateServer(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) LoadBalancers(ctx context.Context) ([]brightbox.LoadBalancer, error) {
+	return instrument(ctx, c.guard, "LoadBalancers", func() ([]brightbox.LoadBalancer, error) {
+		return c.client.LoadBalancers(ctx)
+	})
+}
+
+func (c *instrumentedClient) LoadBalancer(ctx context.Context, identifier string) (*brightbox.LoadBalancer, error) {
+	return instrument(ctx, c.guard, "LoadBalancer", func() (*brightbox.LoadBalancer, error) {
+		return c.client.LoadBalancer(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) CreateLoadBalancer(ctx context.Context, options brightbox.LoadBalancerOptions) (*brightbox.LoadBalancer, error) {
+	return instrument(ctx, c.guard, "CreateLoadBalancer", func() (*brightbox.LoadBalancer, error) {
+		return c.client.CreateLoadBalancer(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) UpdateLoadBalancer(ctx context.Context, options brightbox.LoadBalancerOptions) (*brightbox.LoadBalancer, error) {
+	return instrument(ctx, c.guard, "UpdateLoadBalancer", func() (*brightbox.LoadBalancer, error) {
+		return c.client.UpdateLoadBalancer(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) CloudIPs(ctx context.Context) ([]brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "CloudIPs", func() ([]brightbox.CloudIP, error) {
+		return c.client.CloudIPs(ctx)
+	})
+}
+
+func (c *instrumentedClient) CloudIP(ctx context.Context, identifier string) (*brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "CloudIP", func() (*brightbox.CloudIP, error) {
+		return c.client.CloudIP(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) MapCloudIP(ctx context.Context, identifier string, attachment brightbox.CloudIPAttachment) (*brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "MapCloudIP", func() (*brightbox.CloudIP, error) {
+		return c.client.MapCloudIP(ctx, identifier, attachment)
+	})
+}
+
+func (c *instrumentedClient) UnMapCloudIP(ctx context.Context, identifier string) (*brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "UnMapCloudIP", func() (*brightbox.CloudIP, error) {
+		return c.client.UnMapCloudIP(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) CreateCloudIP(ctx context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "CreateCloudIP", func() (*brightbox.CloudIP, error) {
+		return c.client.CreateCloudIP(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) AddServersToServerGroup(ctx context.Context, identifier string, members brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "AddServersToServerGroup", func() (*brightbox.ServerGroup, error) {
+		return c.client.AddServersToServerGroup(ctx, identifier, members)
+	})
+}
+
+func (c *instrumentedClient) RemoveServersFromServerGroup(ctx context.Context, identifier string, members brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "RemoveServersFromServerGroup", func() (*brightbox.ServerGroup, error) {
+		return c.client.RemoveServersFromServerGroup(ctx, identifier, members)
+	})
+}
+
+func (c *instrumentedClient) ServerGroups(ctx context.Context) ([]brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "ServerGroups", func() ([]brightbox.ServerGroup, error) {
+		return c.client.ServerGroups(ctx)
+	})
+}
+
+func (c *instrumentedClient) ServerGroup(ctx context.Context, identifier string) (*brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "ServerGroup", func() (*brightbox.ServerGroup, error) {
+		return c.client.ServerGroup(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) CreateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "CreateServerGroup", func() (*brightbox.ServerGroup, error) {
+		return c.client.CreateServerGroup(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) CreateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	return instrument(ctx, c.guard, "CreateFirewallPolicy", func() (*brightbox.FirewallPolicy, error) {
+		return c.client.CreateFirewallPolicy(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) CreateFirewallRule(ctx context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	return instrument(ctx, c.guard, "CreateFirewallRule", func() (*brightbox.FirewallRule, error) {
+		return c.client.CreateFirewallRule(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) UpdateFirewallRule(ctx context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	return instrument(ctx, c.guard, "UpdateFirewallRule", func() (*brightbox.FirewallRule, error) {
+		return c.client.UpdateFirewallRule(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) FirewallPolicies(ctx context.Context) ([]brightbox.FirewallPolicy, error) {
+	return instrument(ctx, c.guard, "FirewallPolicies", func() ([]brightbox.FirewallPolicy, error) {
+		return c.client.FirewallPolicies(ctx)
+	})
+}
+
+func (c *instrumentedClient) DestroyServer(ctx context.Context, identifier string) (*brightbox.Server, error) {
+	return instrument(ctx, c.guard, "DestroyServer", func() (*brightbox.Server, error) {
+		return c.client.DestroyServer(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) DestroyServerGroup(ctx context.Context, identifier string) (*brightbox.ServerGroup, error) {
+	return instrument(ctx, c.guard, "DestroyServerGroup", func() (*brightbox.ServerGroup, error) {
+		return c.client.DestroyServerGroup(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) DestroyFirewallPolicy(ctx context.Context, identifier string) (*brightbox.FirewallPolicy, error) {
+	return instrument(ctx, c.guard, "DestroyFirewallPolicy", func() (*brightbox.FirewallPolicy, error) {
+		return c.client.DestroyFirewallPolicy(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) DestroyLoadBalancer(ctx context.Context, identifier string) (*brightbox.LoadBalancer, error) {
+	return instrument(ctx, c.guard, "DestroyLoadBalancer", func() (*brightbox.LoadBalancer, error) {
+		return c.client.DestroyLoadBalancer(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) DestroyCloudIP(ctx context.Context, identifier string) (*brightbox.CloudIP, error) {
+	return instrument(ctx, c.guard, "DestroyCloudIP", func() (*brightbox.CloudIP, error) {
+		return c.client.DestroyCloudIP(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) Images(ctx context.Context) ([]brightbox.Image, error) {
+	return instrument(ctx, c.guard, "Images", func() ([]brightbox.Image, error) {
+		return c.client.Images(ctx)
+	})
+}
+
+func (c *instrumentedClient) ConfigMaps(ctx context.Context) ([]brightbox.ConfigMap, error) {
+	return instrument(ctx, c.guard, "ConfigMaps", func() ([]brightbox.ConfigMap, error) {
+		return c.client.ConfigMaps(ctx)
+	})
+}
+
+func (c *instrumentedClient) ConfigMap(ctx context.Context, identifier string) (*brightbox.ConfigMap, error) {
+	return instrument(ctx, c.guard, "ConfigMap", func() (*brightbox.ConfigMap, error) {
+		return c.client.ConfigMap(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) ServerTypes(ctx context.Context) ([]brightbox.ServerType, error) {
+	return instrument(ctx, c.guard, "ServerTypes", func() ([]brightbox.ServerType, error) {
+		return c.client.ServerTypes(ctx)
+	})
+}
+
+func (c *instrumentedClient) ServerType(ctx context.Context, identifier string) (*brightbox.ServerType, error) {
+	return instrument(ctx, c.guard, "ServerType", func() (*brightbox.ServerType, error) {
+		return c.client.ServerType(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) DestroyFirewallRule(ctx context.Context, identifier string) (*brightbox.FirewallRule, error) {
+	destroyer, ok := c.client.(firewallRuleDestroyer)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support destroying firewall rules")
+	}
+	return instrument(ctx, c.guard, "DestroyFirewallRule", func() (*brightbox.FirewallRule, error) {
+		return destroyer.DestroyFirewallRule(ctx, identifier)
+	})
+}
+
+func (c *instrumentedClient) UpdateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	updater, ok := c.client.(serverGroupUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating server groups")
+	}
+	return instrument(ctx, c.guard, "UpdateServerGroup", func() (*brightbox.ServerGroup, error) {
+		return updater.UpdateServerGroup(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) UpdateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	updater, ok := c.client.(firewallPolicyUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating firewall policies")
+	}
+	return instrument(ctx, c.guard, "UpdateFirewallPolicy", func() (*brightbox.FirewallPolicy, error) {
+		return updater.UpdateFirewallPolicy(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) UpdateCloudIP(ctx context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	updater, ok := c.client.(cloudIPUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating Cloud IPs")
+	}
+	return instrument(ctx, c.guard, "UpdateCloudIP", func() (*brightbox.CloudIP, error) {
+		return updater.UpdateCloudIP(ctx, options)
+	})
+}
+
+func (c *instrumentedClient) Servers(ctx context.Context) ([]brightbox.Server, error) {
+	lister, ok := c.client.(serverLister)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support listing servers")
+	}
+	return instrument(ctx, c.guard, "Servers", func() ([]brightbox.Server, error) {
+		return lister.Servers(ctx)
+	})
+}
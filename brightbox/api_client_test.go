@@ -0,0 +1,145 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestAPIResultCode(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"success": {
+			expected: "ok",
+		},
+		"rate-limited": {
+			err:      &brightbox.APIError{StatusCode: 429},
+			expected: "429",
+		},
+		"wrapped": {
+			err:      fmt.Errorf("Failed: %w", &brightbox.APIError{StatusCode: 404}),
+			expected: "404",
+		},
+		"network": {
+			err:      errors.New("connection refused"),
+			expected: "error",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(apiResultCode(tc.err), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	registerMetrics()
+	fake := &fakeFirewallCloud{}
+	client, err := instrumentCloud(k8ssdk.MakeTestClient(fake, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instrumented := &cloud{Cloud: client}
+	created := apiRequests.WithLabelValues("CreateFirewallRule", "ok")
+	destroyed := apiRequests.WithLabelValues("DestroyFirewallRule", "ok")
+	createdBefore, _ := testutil.GetCounterMetricValue(created)
+	destroyedBefore, _ := testutil.GetCounterMetricValue(destroyed)
+	source := "10.0.0.0/8"
+	description := "test"
+	if _, err := instrumented.CreateFirewallRule(context.TODO(), brightbox.FirewallRuleOptions{Source: &source, Description: &description}); err != nil {
+		t.Fatal(err)
+	}
+	if err := instrumented.destroyFirewallRule(context.TODO(), "fwr-testy"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(fake.calls, []string{"create 10.0.0.0/8", "destroy fwr-testy"}); diff != nil {
+		t.Error(diff)
+	}
+	createdAfter, _ := testutil.GetCounterMetricValue(created)
+	destroyedAfter, _ := testutil.GetCounterMetricValue(destroyed)
+	if diff := deep.Equal([]float64{createdAfter - createdBefore, destroyedAfter - destroyedBefore}, []float64{1, 1}); diff != nil {
+		t.Error(diff)
+	}
+	// Wrapping twice is a no-op
+	again, err := instrumentCloud(client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != client {
+		t.Errorf("expected the instrumented client to be reused")
+	}
+}
+
+func TestResourceTracker(t *testing.T) {
+	registerMetrics()
+	tracker := newResourceTracker()
+	tracker.set("uid-1", serviceResources{loadBalancers: 1, cloudIPs: 2, serverGroups: 1, firewallPolicies: 1})
+	tracker.set("uid-2", serviceResources{loadBalancers: 1, cloudIPs: 1, serverGroups: 1, firewallPolicies: 1})
+	tracker.set("uid-2", serviceResources{loadBalancers: 1, cloudIPs: 1, serverGroups: 1, firewallPolicies: 1})
+	gauge := func(resource string) float64 {
+		value, err := testutil.GetGaugeMetricValue(managedResources.WithLabelValues(resource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	if diff := deep.Equal(gauge(resourceCloudIPs), float64(3)); diff != nil {
+		t.Error(diff)
+	}
+	tracker.acmeStatus("uid-1", true)
+	tracker.acmeStatus("uid-1", true)
+	pending, _ := testutil.GetGaugeMetricValue(acmePendingLoadBalancers)
+	if diff := deep.Equal(pending, float64(1)); diff != nil {
+		t.Error(diff)
+	}
+	issued := func() uint64 {
+		vec, err := testutil.GetHistogramVecFromGatherer(legacyregistry.DefaultGatherer, "brightbox_acme_issue_duration_seconds", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vec.GetAggregatedSampleCount()
+	}
+	issuedBefore := issued()
+	tracker.acmeStatus("uid-1", false)
+	if diff := deep.Equal(issued()-issuedBefore, uint64(1)); diff != nil {
+		t.Error(diff)
+	}
+	tracker.remove("uid-1")
+	if diff := deep.Equal(gauge(resourceLoadBalancers), float64(1)); diff != nil {
+		t.Error(diff)
+	}
+	tracker.remove("uid-2")
+	if diff := deep.Equal(gauge(resourceCloudIPs), float64(0)); diff != nil {
+		t.Error(diff)
+	}
+	// A nil tracker records nothing
+	var none *resourceTracker
+	none.set("uid-3", serviceResources{loadBalancers: 1})
+	none.remove("uid-3")
+	none.acmeStatus("uid-3", true)
+}
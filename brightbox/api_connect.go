@@ -0,0 +1,188 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/hashicorp/go-cleanhttp"
+	"golang.org/x/oauth2"
+	"k8s.io/klog/v2"
+)
+
+// connectAPI connects to the Brightbox API with the credentials, over
+// an HTTP client that passes the Retry-After header of throttled calls
+// to the guard. When the API turns the credentials down the cloud config
+// file, if there is one, is read again so rotated credentials are picked
+// up without a restart.
+func connectAPI(ctx context.Context, guard *apiGuard, creds apiCredentials, configFile string) (*k8ssdk.Cloud, error) {
+	klog.V(4).Infof("connectAPI")
+	transport := &retryAfterTransport{base: cleanhttp.DefaultTransport(), guard: guard}
+	credentials, err := newRotatingCredentials(creds, configFile, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, err
+	}
+	transport.credentials = credentials
+	client, err := brightbox.Connect(context.Background(), credentials)
+	if err != nil {
+		return nil, err
+	}
+	// Check the credentials work before the controllers start
+	if creds.account == "" {
+		if _, err := client.Accounts(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return wrapAPIClient(client), nil
+}
+
+// rotatingCredentials hands out tokens for the current credentials, and
+// switches to new ones when reloaded. It is the client's brightbox.Oauth2
+// config and its token source.
+type rotatingCredentials struct {
+	configFile string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	creds  apiCredentials
+	config brightbox.Oauth2
+	source oauth2.TokenSource
+}
+
+func newRotatingCredentials(creds apiCredentials, configFile string, httpClient *http.Client) (*rotatingCredentials, error) {
+	result := &rotatingCredentials{configFile: configFile, httpClient: httpClient}
+	if err := result.use(creds); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// use switches to the credentials. The caller holds the lock, or is the
+// constructor.
+func (r *rotatingCredentials) use(creds apiCredentials) error {
+	config, err := oauthConfig(creds)
+	if err != nil {
+		return err
+	}
+	// The token source keeps this context for refreshing its token
+	_, source, err := config.Client(context.WithValue(context.Background(), oauth2.HTTPClient, r.httpClient))
+	if err != nil {
+		return err
+	}
+	r.creds, r.config, r.source = creds, config, source
+	return nil
+}
+
+// Client returns an HTTP client that signs requests with the current
+// credentials
+func (r *rotatingCredentials) Client(_ context.Context) (*http.Client, oauth2.TokenSource, error) {
+	return &http.Client{Transport: &oauth2.Transport{Source: r, Base: r.httpClient.Transport}}, r, nil
+}
+
+func (r *rotatingCredentials) APIURL() (*url.URL, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config.APIURL()
+}
+
+func (r *rotatingCredentials) Token() (*oauth2.Token, error) {
+	r.mu.Lock()
+	source := r.source
+	r.mu.Unlock()
+	return source.Token()
+}
+
+// reload reads the cloud config file again and switches to the
+// credentials in it if they have changed, reporting whether they did.
+// Values in the environment still win, so only credentials kept in the
+// file can be rotated.
+func (r *rotatingCredentials) reload() bool {
+	if r == nil || r.configFile == "" {
+		return false
+	}
+	file, err := os.Open(r.configFile)
+	if err != nil {
+		klog.Errorf("Failed to read API credentials: %v", err)
+		return false
+	}
+	defer file.Close()
+	cfg, err := readCloudConfig(file)
+	if err != nil {
+		klog.Errorf("Failed to read API credentials: %v", err)
+		return false
+	}
+	creds := cfg.apiCredentials()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if creds == r.creds {
+		return false
+	}
+	if err := r.use(creds); err != nil {
+		klog.Errorf("Failed to use the API credentials in %s: %v", r.configFile, err)
+		return false
+	}
+	klog.Infof("Switched to the API credentials in %s", r.configFile)
+	return true
+}
+
+// retryAfterTransport tells the guard how long the API asked to be
+// left alone when it turns a call away, and reloads the credentials
+// when the API turns them down
+type retryAfterTransport struct {
+	base        http.RoundTripper
+	guard       *apiGuard
+	credentials *rotatingCredentials
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && !t.credentials.reload() {
+		klog.Errorf("Brightbox API rejected the controller's credentials for %s. Credentials in the environment are only read at startup, so restart the controller after rotating them", req.URL.Path)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), t.guard.now()); ok {
+			t.guard.retryAfter(delay)
+		}
+	}
+	return resp, nil
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number
+// of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,171 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		"seconds":  {value: " 7", expected: 7 * time.Second, ok: true},
+		"date":     {value: "Sat, 17 Oct 2026 12:00:30 GMT", expected: 30 * time.Second, ok: true},
+		"past":     {value: "Sat, 17 Oct 2026 11:00:00 GMT", ok: true},
+		"negative": {value: "-5", ok: true},
+		"missing":  {},
+		"garbage":  {value: "soon"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			if diff := deep.Equal([]any{delay, ok}, []any{tc.expected, tc.ok}); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestConnectAPIRetryAfter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token/":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"token","token_type":"bearer","expires_in":3600}`))
+		case "/1.0/servers/srv-testy":
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			if calls == 1 {
+				w.Header().Set("Retry-After", "3")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error_name":"rate_limited"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"srv-testy","status":"active"}`))
+		default:
+			t.Errorf("Unexpected request for %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	creds := apiCredentials{apiURL: ts.URL, clientID: "cli-testy", clientSecret: "secret", account: "acc-testy"}
+
+	guard, delays := testAPIGuard()
+	api, err := connectAPI(context.TODO(), guard, creds, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := instrumentCloud(api, guard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := client.GetServer(context.TODO(), "srv-testy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal([]any{srv.ID, calls}, []any{"srv-testy", 2}); diff != nil {
+		t.Error(diff)
+	}
+	if len(*delays) != 1 || (*delays)[0] < 2*time.Second || (*delays)[0] > 3*time.Second {
+		t.Errorf("expected a wait of about 3s, got %v", *delays)
+	}
+}
+
+func TestOauthConfig(t *testing.T) {
+	if _, err := oauthConfig(apiCredentials{}); err == nil {
+		t.Error("expected user credentials without an account to fail")
+	}
+	if _, err := oauthConfig(apiCredentials{clientID: "cli-testy", userName: "itsy@bitzy.com"}); err == nil {
+		t.Error("expected user credentials with an API client to fail")
+	}
+}
+
+func TestConnectAPIRotatedCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token/":
+			_, secret, _ := r.BasicAuth()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"token-` + secret + `","token_type":"bearer","expires_in":3600}`))
+		case "/1.0/servers/srv-testy":
+			w.Header().Set("Content-Type", "application/json")
+			if r.Header.Get("Authorization") != "Bearer token-new" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error_name":"invalid_token"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"srv-testy","status":"active"}`))
+		default:
+			t.Errorf("Unexpected request for %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	k8ssdk.ResetAuthEnvironment()
+	t.Cleanup(k8ssdk.ResetAuthEnvironment)
+	configFile := filepath.Join(t.TempDir(), "cloud-config.yaml")
+	writeConfig := func(secret string) {
+		t.Helper()
+		config := fmt.Sprintf("apiURL: %s\nclientID: cli-testy\nclientSecret: %s\naccount: acc-testy\n", ts.URL, secret)
+		if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("old")
+	file, err := os.Open(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cfg, err := readCloudConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := connectAPI(context.TODO(), nil, cfg.apiCredentials(), cloudConfigFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.GetServer(context.TODO(), "srv-testy", nil); err == nil {
+		t.Fatal("expected the old credentials to be turned down")
+	}
+
+	// The rotated Secret reaches the mounted file
+	writeConfig("new")
+	if _, err := api.GetServer(context.TODO(), "srv-testy", nil); err == nil {
+		t.Fatal("expected the call made with the old credentials to fail")
+	}
+	srv, err := api.GetServer(context.TODO(), "srv-testy", nil)
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(srv.ID, "srv-testy"); diff != nil {
+		t.Error(diff)
+	}
+}
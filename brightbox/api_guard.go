@@ -0,0 +1,305 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultAPIRateLimit        = 10
+	defaultAPIBurst            = 20
+	defaultAPIRetries          = 3
+	defaultAPIRetryDelay       = 500 * time.Millisecond
+	defaultAPIMaxRetryDelay    = 10 * time.Second
+	defaultAPIBreakerThreshold = 10
+	defaultAPIBreakerCooldown  = 30 * time.Second
+)
+
+// Operations starting with these change resources. They are only
+// retried when the API turned them away with a 429, as any other
+// failure may have come after the change was made.
+var mutatingOperations = []string{"Create", "Update", "Destroy", "Map", "UnMap", "Add", "Remove"}
+
+// apiClientConfig is the apiClient section of the cloud config. It
+// sets how hard the controller leans on the Brightbox API. Every field
+// has a default, so the section can be left out.
+type apiClientConfig struct {
+	// RateLimit is the average number of API calls allowed each
+	// second. Defaults to 10.
+	RateLimit float64 `json:"rateLimit,omitempty"`
+
+	// Burst is the number of calls allowed at once above the rate
+	// limit. Defaults to 20.
+	Burst int `json:"burst,omitempty"`
+
+	// Retries is the number of times a call that failed with a 429, a
+	// 5xx or a network error is tried again. Defaults to 3. Set it to 0
+	// to leave every retry to the controller's sync loop.
+	Retries *int `json:"retries,omitempty"`
+
+	// RetryDelay is the wait before the first retry, which doubles
+	// with each retry up to MaxRetryDelay. Defaults to 500ms and 10s.
+	RetryDelay    metav1.Duration `json:"retryDelay,omitempty"`
+	MaxRetryDelay metav1.Duration `json:"maxRetryDelay,omitempty"`
+
+	// BreakerThreshold is the number of failures in a row that stops
+	// all calls for BreakerCooldown, after which a single call is let
+	// through to test the API. Defaults to 10 and 30s.
+	BreakerThreshold int             `json:"breakerThreshold,omitempty"`
+	BreakerCooldown  metav1.Duration `json:"breakerCooldown,omitempty"`
+}
+
+func (cfg *apiClientConfig) validate() error {
+	if cfg.RateLimit < 0 {
+		return fmt.Errorf("rateLimit cannot be negative")
+	}
+	if cfg.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+	if cfg.Retries != nil && *cfg.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+	if cfg.RetryDelay.Duration < 0 || cfg.MaxRetryDelay.Duration < 0 {
+		return fmt.Errorf("retry delays cannot be negative")
+	}
+	if cfg.BreakerThreshold < 0 {
+		return fmt.Errorf("breakerThreshold cannot be negative")
+	}
+	if cfg.BreakerCooldown.Duration < 0 {
+		return fmt.Errorf("breakerCooldown cannot be negative")
+	}
+	return nil
+}
+
+// apiGuard rate limits calls to the Brightbox API, retries those that
+// fail for a passing reason and stops calling altogether while the API
+// keeps failing, so a burst of services reconciling at once backs off
+// together rather than piling onto an API that is already struggling.
+// A nil guard lets every call straight through.
+type apiGuard struct {
+	limiter          *rate.Limiter
+	retries          int
+	retryDelay       time.Duration
+	maxRetryDelay    time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	now              func() time.Time
+	sleep            func(context.Context, time.Duration) error
+
+	mu         sync.Mutex
+	failures   int
+	openUntil  time.Time
+	probing    bool
+	retryUntil time.Time
+}
+
+// newAPIGuard builds a guard from the apiClient section of the cloud
+// config. Safe to call with a nil config.
+func newAPIGuard(cfg *apiClientConfig) *apiGuard {
+	if cfg == nil {
+		cfg = &apiClientConfig{}
+	}
+	result := &apiGuard{
+		limiter:          rate.NewLimiter(rate.Limit(defaultAPIRateLimit), defaultAPIBurst),
+		retries:          defaultAPIRetries,
+		retryDelay:       defaultAPIRetryDelay,
+		maxRetryDelay:    defaultAPIMaxRetryDelay,
+		breakerThreshold: defaultAPIBreakerThreshold,
+		breakerCooldown:  defaultAPIBreakerCooldown,
+		now:              time.Now,
+		sleep:            sleepContext,
+	}
+	if cfg.RateLimit > 0 {
+		result.limiter.SetLimit(rate.Limit(cfg.RateLimit))
+	}
+	if cfg.Burst > 0 {
+		result.limiter.SetBurst(cfg.Burst)
+	}
+	if cfg.Retries != nil {
+		result.retries = *cfg.Retries
+	}
+	if cfg.RetryDelay.Duration > 0 {
+		result.retryDelay = cfg.RetryDelay.Duration
+	}
+	if cfg.MaxRetryDelay.Duration > 0 {
+		result.maxRetryDelay = cfg.MaxRetryDelay.Duration
+	}
+	if cfg.BreakerThreshold > 0 {
+		result.breakerThreshold = cfg.BreakerThreshold
+	}
+	if cfg.BreakerCooldown.Duration > 0 {
+		result.breakerCooldown = cfg.BreakerCooldown.Duration
+	}
+	return result
+}
+
+// allow waits for the rate limiter, failing straight away if the
+// breaker is open. Once the cooldown is over a single call is let
+// through to see if the API has recovered.
+func (g *apiGuard) allow(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	if err := g.checkBreaker(); err != nil {
+		return err
+	}
+	if err := g.limiter.Wait(ctx); err != nil {
+		g.releaseProbe()
+		return err
+	}
+	return nil
+}
+
+// releaseProbe lets another call test the API when the probe never
+// got an answer from it
+func (g *apiGuard) releaseProbe() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+}
+
+func (g *apiGuard) checkBreaker() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures < g.breakerThreshold {
+		return nil
+	}
+	if g.probing || g.now().Before(g.openUntil) {
+		return fmt.Errorf("Brightbox API calls stopped after %d failures in a row, retrying after %s", g.failures, g.openUntil.Format(time.RFC3339))
+	}
+	g.probing = true
+	return nil
+}
+
+// record counts a failure towards the breaker, or closes it on a
+// response that shows the API is working. A call cut short by its
+// context says nothing about the API, so only frees the probe.
+func (g *apiGuard) record(err error) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+	if isContextError(err) {
+		return
+	}
+	if !isTransientAPIError(err) {
+		g.failures = 0
+		apiCircuitBreakerOpen.Set(0)
+		return
+	}
+	g.failures++
+	if g.failures >= g.breakerThreshold {
+		g.openUntil = g.now().Add(g.breakerCooldown)
+		apiCircuitBreakerOpen.Set(1)
+	}
+}
+
+// retryAfter notes the wait the API asked for when it turned a call
+// away
+func (g *apiGuard) retryAfter(delay time.Duration) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until := g.now().Add(delay); until.After(g.retryUntil) {
+		g.retryUntil = until
+	}
+}
+
+// retry reports whether a failed call should be tried again, having
+// waited for a jittered backoff, and stops if the context ends first.
+// A longer wait asked for by the API is honoured, unless it is beyond
+// MaxRetryDelay, in which case the retry is left to the sync loop.
+func (g *apiGuard) retry(ctx context.Context, operation string, attempt int, err error) bool {
+	if g == nil || attempt >= g.retries || !isTransientAPIError(err) {
+		return false
+	}
+	if isMutatingOperation(operation) && apiResultCode(err) != "429" {
+		return false
+	}
+	delay := min(g.retryDelay<<attempt, g.maxRetryDelay)
+	// Full jitter over the top half, so services that failed together
+	// don't retry together
+	delay = delay/2 + rand.N(delay/2+1)
+	if wait := g.retryWait(); wait > g.maxRetryDelay {
+		klog.V(4).Infof("Not retrying %s, the API asked for a wait of %s", operation, wait)
+		return false
+	} else if wait > delay {
+		delay = wait
+	}
+	apiRetries.WithLabelValues(operation).Inc()
+	return g.sleep(ctx, delay) == nil
+}
+
+// retryWait returns what is left of the wait the API asked for
+func (g *apiGuard) retryWait() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.retryUntil.Sub(g.now())
+}
+
+// isTransientAPIError reports whether an error may go away on its own:
+// rate limiting, a server error or a network failure
+func isTransientAPIError(err error) bool {
+	if err == nil || isContextError(err) {
+		return false
+	}
+	var apiErr *brightbox.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode == 0 {
+		return true
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func isMutatingOperation(operation string) bool {
+	for _, prefix := range mutatingOperations {
+		if strings.HasPrefix(operation, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepContext waits for the duration or until the context ends
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
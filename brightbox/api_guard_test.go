@@ -0,0 +1,295 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/go-test/deep"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsTransientAPIError(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"success":      {},
+		"rate-limited": {err: &brightbox.APIError{StatusCode: 429}, expected: true},
+		"server error": {err: fmt.Errorf("Failed: %w", &brightbox.APIError{StatusCode: 502}), expected: true},
+		"not found":    {err: &brightbox.APIError{StatusCode: 404}},
+		"network":      {err: errors.New("connection refused"), expected: true},
+		"cancelled":    {err: fmt.Errorf("Failed: %w", context.Canceled)},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(isTransientAPIError(tc.err), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNewAPIGuard(t *testing.T) {
+	retries := 0
+	guard := newAPIGuard(&apiClientConfig{
+		RateLimit:        2.5,
+		Burst:            5,
+		Retries:          &retries,
+		RetryDelay:       metav1.Duration{Duration: time.Second},
+		BreakerThreshold: 4,
+	})
+	if diff := deep.Equal(
+		[]any{float64(guard.limiter.Limit()), guard.limiter.Burst(), guard.retries, guard.retryDelay, guard.maxRetryDelay, guard.breakerThreshold, guard.breakerCooldown},
+		[]any{2.5, 5, 0, time.Second, defaultAPIMaxRetryDelay, 4, defaultAPIBreakerCooldown},
+	); diff != nil {
+		t.Error(diff)
+	}
+	defaults := newAPIGuard(nil)
+	if diff := deep.Equal([]int{defaults.retries, defaults.limiter.Burst()}, []int{defaultAPIRetries, defaultAPIBurst}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// testAPIGuard returns a guard that records its retry delays instead of
+// sleeping
+func testAPIGuard() (*apiGuard, *[]time.Duration) {
+	guard := newAPIGuard(nil)
+	var delays []time.Duration
+	guard.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return guard, &delays
+}
+
+// failingCall returns each error in turn, then succeeds
+func failingCall(errs ...error) (func() (string, error), *int) {
+	calls := 0
+	return func() (string, error) {
+		calls++
+		if calls <= len(errs) {
+			return "", errs[calls-1]
+		}
+		return "done", nil
+	}, &calls
+}
+
+func TestAPIGuardRetries(t *testing.T) {
+	unavailable := &brightbox.APIError{StatusCode: 503}
+	limited := &brightbox.APIError{StatusCode: 429}
+	testCases := map[string]struct {
+		operation string
+		errs      []error
+		calls     int
+		status    string
+	}{
+		"recovers": {
+			operation: "CloudIPs",
+			errs:      []error{unavailable, limited},
+			calls:     3,
+		},
+		"gives up": {
+			operation: "Server",
+			errs:      []error{unavailable, unavailable, unavailable, unavailable, unavailable},
+			calls:     4,
+			status:    "503",
+		},
+		"client error": {
+			operation: "Server",
+			errs:      []error{&brightbox.APIError{StatusCode: 404}},
+			calls:     1,
+			status:    "404",
+		},
+		"change rate-limited": {
+			operation: "CreateCloudIP",
+			errs:      []error{limited},
+			calls:     2,
+		},
+		"change failed": {
+			operation: "CreateCloudIP",
+			errs:      []error{unavailable},
+			calls:     1,
+			status:    "503",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			guard, delays := testAPIGuard()
+			call, calls := failingCall(tc.errs...)
+			result, err := instrument(context.TODO(), guard, tc.operation, call)
+			if tc.status != "" {
+				if err == nil || apiResultCode(err) != tc.status {
+					t.Errorf("expected a %s, got %v", tc.status, err)
+				}
+			} else if err != nil || result != "done" {
+				t.Errorf("expected success, got %q (%v)", result, err)
+			}
+			if diff := deep.Equal(*calls, tc.calls); diff != nil {
+				t.Error(diff)
+			}
+			for i, delay := range *delays {
+				ceiling := min(defaultAPIRetryDelay<<i, defaultAPIMaxRetryDelay)
+				if delay < ceiling/2 || delay > ceiling {
+					t.Errorf("retry %d waited %s, outside %s to %s", i, delay, ceiling/2, ceiling)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIGuardCancelled(t *testing.T) {
+	guard := newAPIGuard(nil)
+	ctx, cancel := context.WithCancel(context.TODO())
+	call, calls := failingCall(&brightbox.APIError{StatusCode: 503}, &brightbox.APIError{StatusCode: 503})
+	guard.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return sleepContext(ctx, d)
+	}
+	if _, err := instrument(ctx, guard, "Server", call); err == nil {
+		t.Error("expected an error")
+	}
+	if diff := deep.Equal(*calls, 1); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAPIGuardBreaker(t *testing.T) {
+	guard, _ := testAPIGuard()
+	guard.retries = 0
+	guard.breakerThreshold = 2
+	now := time.Unix(1760000000, 0)
+	guard.now = func() time.Time { return now }
+	unavailable := &brightbox.APIError{StatusCode: 503}
+
+	// Client errors show the API is up
+	for _, err := range []error{unavailable, &brightbox.APIError{StatusCode: 404}, unavailable} {
+		guard.record(err)
+	}
+	if err := guard.allow(context.TODO()); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	guard.record(unavailable)
+	call, calls := failingCall()
+	_, err := instrument(context.TODO(), guard, "Server", call)
+	if err == nil || !strings.HasPrefix(err.Error(), "Brightbox API calls stopped after 2 failures in a row") {
+		t.Errorf("expected the breaker to be open, got %v", err)
+	}
+	if *calls != 0 {
+		t.Errorf("expected no calls while open, got %d", *calls)
+	}
+
+	// A single call is let through after the cooldown
+	now = now.Add(defaultAPIBreakerCooldown)
+	if err := guard.allow(context.TODO()); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if err := guard.allow(context.TODO()); err == nil {
+		t.Error("expected a second call to wait for the first")
+	}
+	// which fails, opening the breaker again
+	guard.record(unavailable)
+	if err := guard.allow(context.TODO()); err == nil {
+		t.Error("expected the breaker to open again")
+	}
+	now = now.Add(defaultAPIBreakerCooldown)
+	if _, err := instrument(context.TODO(), guard, "Server", call); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if err := guard.allow(context.TODO()); err != nil {
+		t.Errorf("expected the breaker to close, got %v", err)
+	}
+}
+
+func TestAPIGuardProbeCancelled(t *testing.T) {
+	guard, _ := testAPIGuard()
+	guard.retries = 0
+	guard.breakerThreshold = 1
+	now := time.Unix(1760000000, 0)
+	guard.now = func() time.Time { return now }
+	guard.record(&brightbox.APIError{StatusCode: 503})
+	now = now.Add(defaultAPIBreakerCooldown)
+
+	// The probe gives up waiting for the rate limiter
+	cancelled, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if err := guard.allow(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+	// The probe's call is cancelled
+	ctx, cancel := context.WithCancel(context.TODO())
+	_, err := instrument(ctx, guard, "Server", func() (string, error) {
+		cancel()
+		return "", fmt.Errorf("Get: %w", ctx.Err())
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the call to be cancelled, got %v", err)
+	}
+	// Neither holds up the next probe
+	call, calls := failingCall()
+	if _, err := instrument(context.TODO(), guard, "Server", call); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(*calls, 1); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAPIGuardRetryAfter(t *testing.T) {
+	limited := &brightbox.APIError{StatusCode: 429}
+	testCases := map[string]struct {
+		wait   time.Duration
+		calls  int
+		delays []time.Duration
+	}{
+		"longer than the backoff": {
+			wait:   5 * time.Second,
+			calls:  2,
+			delays: []time.Duration{5 * time.Second},
+		},
+		"beyond the longest retry delay": {
+			wait:  time.Minute,
+			calls: 1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			guard, delays := testAPIGuard()
+			now := time.Unix(1760000000, 0)
+			guard.now = func() time.Time { return now }
+			calls := 0
+			_, err := instrument(context.TODO(), guard, "Server", func() (string, error) {
+				calls++
+				if calls == 1 {
+					guard.retryAfter(tc.wait)
+					return "", limited
+				}
+				return "done", nil
+			})
+			if tc.calls == 1 && err == nil {
+				t.Error("expected the call to be left to the sync loop")
+			}
+			if diff := deep.Equal([]any{calls, *delays}, []any{tc.calls, tc.delays}); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
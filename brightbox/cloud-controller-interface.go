@@ -15,15 +15,38 @@
 package brightbox
 
 import (
+	"context"
 	"io"
+	"os"
 
 	"github.com/brightbox/k8ssdk/v2"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/keymutex"
 )
 
+// clientName identifies the controller to the Kubernetes API
+const clientName = "brightbox-cloud-controller-manager"
+
 type cloud struct {
 	*k8ssdk.Cloud
+	config          cloudConfig
+	kubeClient      kubernetes.Interface
+	informerFactory informers.SharedInformerFactory
+	endpointSlices  discoverylisters.EndpointSliceLister
+	recorder        record.EventRecorder
+	cache           *apiCache
+	resolver        domainResolver
+	dns             dnsProvider
+	resources       *resourceTracker
+	resync          *serviceResyncController
+	classQueue      workqueue.TypedInterface[string]
+	serviceLocks    keymutex.KeyMutex
 }
 
 // Initialize provides the cloud with a kubernetes client builder and
@@ -31,6 +54,29 @@ type cloud struct {
 // cloud provider.
 func (c *cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
 	klog.V(4).Infof("Initialise called with %+v", clientBuilder)
+	client, err := clientBuilder.Client(clientName)
+	if err != nil {
+		klog.Errorf("Failed to create Kubernetes client: %v", err)
+		return
+	}
+	c.kubeClient = client
+	c.startEventRecorder(client, stop)
+	c.informerFactory = informers.NewSharedInformerFactory(client, 0)
+	c.startServiceResyncController(stop)
+	c.pollCertificateSecrets(stop)
+	c.watchEndpointSlices()
+	c.watchNodeReadiness()
+	c.startGarbageCollector(stop)
+	c.startLoadBalancerClassController(stop)
+	c.informerFactory.Start(stop)
+}
+
+// ClusterName returns the cluster name the class and resync controllers
+// pass to the load balancer calls. It has to match the --cluster-name
+// the service controller passes, or the two would name and own load
+// balancers differently.
+func (c *cloud) ClusterName() string {
+	return c.config.clusterName()
 }
 
 // LoadBalancer returns a balancer interface. Also returns true if the
@@ -73,6 +119,11 @@ func (c *cloud) Clusters() (cloudprovider.Clusters, bool) {
 
 // Routes returns a routes interface along with whether the interface
 // is supported.
+// Brightbox Cloud has no API to route a network prefix to a server. The
+// only routing primitive is a Cloud IP in "route" mode, which carries a
+// single public address rather than a pod CIDR. Pod networking has to
+// be provided by the CNI, and the controller should be run with
+// --configure-cloud-routes=false.
 func (c *cloud) Routes() (cloudprovider.Routes, bool) {
 	klog.V(4).Info("Routes called")
 	return nil, false
@@ -95,21 +146,57 @@ func init() {
 	cloudprovider.RegisterCloudProvider(k8ssdk.ProviderName, newCloudConnection)
 }
 
+// cloudConfigFile returns the path of the cloud config, which the cloud
+// provider framework opens and passes on, or "" if there isn't one
+func cloudConfigFile(config io.Reader) string {
+	if file, ok := config.(*os.File); ok {
+		return file.Name()
+	}
+	return ""
+}
+
 // Read a config and generate a cloud structure
 // Open a cloud connection early in this version to validate environment
 // settings.
 // TODO: Look at whether open on demand works better
 func newCloudConnection(config io.Reader) (cloudprovider.Interface, error) {
 	klog.V(4).Infof("newCloudConnection called with %+v", config)
-	if config != nil {
-		klog.Warning("supplied config is not read by this version. Using environment")
+	cfg, err := readCloudConfig(config)
+	if err != nil {
+		return nil, err
 	}
-	newCloud := &cloud{
-		&k8ssdk.Cloud{},
+	registerMetrics()
+	guard := newAPIGuard(cfg.APIClient)
+	api, err := connectAPI(context.Background(), guard, cfg.apiCredentials(), cloudConfigFile(config))
+	if err != nil {
+		return nil, err
 	}
-	_, err := newCloud.CloudClient()
+	client, err := instrumentCloud(api, guard)
 	if err != nil {
 		return nil, err
 	}
+	var cache *apiCache
+	if ttl := cfg.apiCacheTTL(); ttl > 0 {
+		cache = newAPICache(ttl)
+		client, err = cacheCloud(client, cache)
+		if err != nil {
+			return nil, err
+		}
+	}
+	newCloud := &cloud{
+		Cloud:        client,
+		config:       *cfg,
+		cache:        cache,
+		resolver:     newDomainResolver(cfg.dnsServers()),
+		resources:    newResourceTracker(),
+		serviceLocks: keymutex.NewHashed(0),
+	}
+	if cfg.DNSUpdates != nil {
+		provider, err := cfg.DNSUpdates.provider()
+		if err != nil {
+			return nil, err
+		}
+		newCloud.dns = provider
+	}
 	return newCloud, nil
 }
@@ -20,12 +20,13 @@ import (
 	"testing"
 
 	"github.com/brightbox/k8ssdk/v2"
+	restclient "k8s.io/client-go/rest"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/controller-manager/pkg/clientbuilder"
 )
 
 const (
-	config_const = "dummy"
+	config_const = "regionCIDRs: [\"10.0.0.0/8\"]"
 	provider     = "brightbox"
 )
 
@@ -144,7 +145,9 @@ func TestInterfaceAdaption(t *testing.T) {
 	} else if cloud == nil {
 		t.Fatalf("Failed to initialise %s provider", provider)
 	}
-	cloud.Initialize(clientbuilder.SimpleControllerClientBuilder{}, make(chan struct{}))
+	stop := make(chan struct{})
+	defer close(stop)
+	cloud.Initialize(clientbuilder.SimpleControllerClientBuilder{ClientConfig: &restclient.Config{Host: ts.URL}}, stop)
 	for _, example := range interfaceTests {
 		t.Run(example.name, example.fn(cloud))
 	}
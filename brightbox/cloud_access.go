@@ -0,0 +1,122 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"k8s.io/klog/v2"
+)
+
+// The k8ssdk CloudAccess interface covers only part of the Brightbox
+// API. The interfaces below describe the additional calls the
+// controller needs. The gobrightbox client implements all of them, so
+// they are obtained by type assertion on the client returned by
+// CloudClient.
+
+// firewallRuleDestroyer removes individual firewall rules
+type firewallRuleDestroyer interface {
+	DestroyFirewallRule(context.Context, string) (*brightbox.FirewallRule, error)
+}
+
+func (c *cloud) destroyFirewallRule(ctx context.Context, id string) error {
+	klog.V(4).Infof("destroyFirewallRule (%q)", id)
+	client, err := c.CloudClient()
+	if err != nil {
+		return err
+	}
+	destroyer, ok := client.(firewallRuleDestroyer)
+	if !ok {
+		return fmt.Errorf("Brightbox API client does not support destroying firewall rules")
+	}
+	_, err = destroyer.DestroyFirewallRule(ctx, id)
+	return err
+}
+
+// serverGroupUpdater changes the name or description of a server group
+type serverGroupUpdater interface {
+	UpdateServerGroup(context.Context, brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error)
+}
+
+func (c *cloud) updateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	klog.V(4).Infof("updateServerGroup (%q)", options.ID)
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	updater, ok := client.(serverGroupUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating server groups")
+	}
+	return updater.UpdateServerGroup(ctx, options)
+}
+
+// firewallPolicyUpdater changes the name or description of a firewall
+// policy
+type firewallPolicyUpdater interface {
+	UpdateFirewallPolicy(context.Context, brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error)
+}
+
+func (c *cloud) updateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	klog.V(4).Infof("updateFirewallPolicy (%q)", options.ID)
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	updater, ok := client.(firewallPolicyUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating firewall policies")
+	}
+	return updater.UpdateFirewallPolicy(ctx, options)
+}
+
+// cloudIPUpdater changes the name, reverse DNS or port translators of a
+// Cloud IP
+type cloudIPUpdater interface {
+	UpdateCloudIP(context.Context, brightbox.CloudIPOptions) (*brightbox.CloudIP, error)
+}
+
+func (c *cloud) updateCloudIP(ctx context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	klog.V(4).Infof("updateCloudIP (%q)", options.ID)
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	updater, ok := client.(cloudIPUpdater)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support updating Cloud IPs")
+	}
+	return updater.UpdateCloudIP(ctx, options)
+}
+
+// serverLister lists every server in the account
+type serverLister interface {
+	Servers(context.Context) ([]brightbox.Server, error)
+}
+
+func (c *cloud) listServers(ctx context.Context) ([]brightbox.Server, error) {
+	klog.V(4).Info("listServers")
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := client.(serverLister)
+	if !ok {
+		return nil, fmt.Errorf("Brightbox API client does not support listing servers")
+	}
+	return lister.Servers(ctx)
+}
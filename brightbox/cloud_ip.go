@@ -18,9 +18,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/cloudipstatus"
+	"github.com/brightbox/k8ssdk/v2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
@@ -33,39 +38,278 @@ const (
 	loadbalancerActiveSteps     = 5
 )
 
-// Try to remove CloudIPs matching `name` from the list of cloudIPs
-func (c *cloud) ensureCloudIPsDeleted(ctx context.Context, currentID string, name string) error {
-	klog.V(4).Infof("ensureCloudIPsDeleted (%q)", name)
+// Try to remove the Cloud IPs in the ledger, other than those in use
+func (c *cloud) ensureCloudIPsDeleted(ctx context.Context, keep []string, owned *ownedResources) error {
+	klog.V(4).Infof("ensureCloudIPsDeleted (%q)", owned.group.Name)
+	unwanted := func(id string) bool { return !slices.Contains(keep, id) }
+	if !slices.ContainsFunc(owned.ledger.cloudIPs, unwanted) {
+		return nil
+	}
 	backoff := wait.Backoff{
 		Duration: loadbalancerActiveInitDelay,
 		Factor:   loadbalancerActiveFactor,
 		Steps:    loadbalancerActiveSteps,
 	}
 
-	return wait.ExponentialBackoff(backoff, func() (bool, error) {
+	attempt := 0
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		// A retry needs to see the Cloud IPs as they are now
+		if attempt > 0 {
+			c.cache.invalidate()
+		}
+		attempt++
 		cloudIPList, err := c.GetCloudIPs(ctx)
 		if err != nil {
 			klog.V(4).Info("Error retrieving list of CloudIPs")
 			return false, err
 		}
-		if err := c.DestroyCloudIPs(ctx, cloudIPList, currentID, name); err != nil {
-			klog.V(4).Info(err)
-			return false, nil
+		remaining := make([]string, 0, len(owned.ledger.cloudIPs))
+		for _, id := range owned.ledger.cloudIPs {
+			if slices.Contains(keep, id) {
+				remaining = append(remaining, id)
+				continue
+			}
+			cip := findMatchingCloudIP(cloudIPList, func(cip *brightbox.CloudIP) bool { return cip.ID == id })
+			if cip == nil {
+				continue
+			}
+			if err := c.ensureCloudIPUnmappedFromNode(ctx, cip); err != nil {
+				klog.V(4).Infof("Error unmapping CloudIP %q: %v", id, err)
+				remaining = append(remaining, id)
+				continue
+			}
+			if err := c.DestroyCloudIP(ctx, id); err != nil {
+				klog.V(4).Infof("Error destroying CloudIP %q: %v", id, err)
+				remaining = append(remaining, id)
+			}
 		}
-		return true, nil
+		owned.ledger.cloudIPs = remaining
+		return !slices.ContainsFunc(remaining, unwanted), nil
 	},
 	)
+	if saveErr := c.saveLedger(ctx, owned); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// releasedCloudIPPrefix marks a retained Cloud IP whose service has
+// gone, so the next service with the same name can reclaim it
+const releasedCloudIPPrefix = "released:"
+
+// releasedCloudIPName is shortened like any other name once the prefix
+// is added. The hash covers the whole name, so reclaiming a Cloud IP
+// finds the same name again.
+func releasedCloudIPName(name string) string {
+	return truncateLoadBalancerName(releasedCloudIPPrefix + name)
+}
+
+// retainsCloudIPs reports whether the service, or the cluster default,
+// asks for its Cloud IPs to be kept when the service is deleted
+func (c *cloud) retainsCloudIPs(apiservice *v1.Service) bool {
+	value := c.config.withDefaultAnnotations(apiservice).Annotations[serviceAnnotationLoadBalancerCloudipRetain]
+	retain, _ := strconv.ParseBool(value)
+	return retain
+}
+
+// ensureCloudIPsReleased unmaps the Cloud IPs in the ledger and renames
+// them as released, rather than destroying them, and drops them from the
+// ledger
+func (c *cloud) ensureCloudIPsReleased(ctx context.Context, owned *ownedResources) error {
+	klog.V(4).Infof("ensureCloudIPsReleased (%q)", owned.group.Name)
+	cloudIPList, err := c.GetCloudIPs(ctx)
+	if err != nil {
+		return err
+	}
+	released := releasedCloudIPName(owned.group.Name)
+	remaining := make([]string, 0, len(owned.ledger.cloudIPs))
+	for _, id := range owned.ledger.cloudIPs {
+		cip := findMatchingCloudIP(cloudIPList, func(cip *brightbox.CloudIP) bool { return cip.ID == id })
+		if cip == nil {
+			continue
+		}
+		if err = c.ensureCloudIPUnmappedFromNode(ctx, cip); err == nil {
+			_, err = c.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: id, Name: &released})
+		}
+		if err != nil {
+			klog.V(4).Infof("Error releasing CloudIP %q: %v", id, err)
+			remaining = append(remaining, id)
+		}
+	}
+	owned.ledger.cloudIPs = remaining
+	if saveErr := c.saveLedger(ctx, owned); saveErr != nil {
+		return saveErr
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("Unable to release CloudIPs %v", remaining)
+	}
+	return nil
 }
 
-func (c *cloud) ensureAllocatedCloudIP(ctx context.Context, name string, apiservice *v1.Service) (*brightbox.CloudIP, error) {
+// ensureMappedCloudIP maps the Cloud IP to the load balancer, unless it
+// is already mapped somewhere. A Cloud IP the service owns that is still
+// mapped to a node from Cloud IP mode is moved across.
+func (c *cloud) ensureMappedCloudIP(ctx context.Context, owned *ownedResources, apiservice *v1.Service, lb *brightbox.LoadBalancer, cip *brightbox.CloudIP) error {
+	if owned.ledger.ownsCloudIP(cip.ID) && mappedCloudIPDestination(cip) != "" {
+		if err := c.ensureCloudIPUnmappedFromNode(ctx, cip); err != nil {
+			return err
+		}
+		if err := c.ensurePortTranslatorsCleared(ctx, cip); err != nil {
+			return err
+		}
+		cip.Status = cloudipstatus.Unmapped
+	}
+	if cip.Status == cloudipstatus.Mapped {
+		if cip.LoadBalancer == nil || cip.LoadBalancer.ID != lb.ID {
+			c.recordWarning(apiservice, eventCloudIPMappingFailed, fmt.Errorf("Cloud IP %s is mapped elsewhere. Unmap it to use it with load balancer %s", cip.ID, lb.ID))
+		}
+		return nil
+	}
+	if err := c.EnsureMappedCloudIP(ctx, lb, cip); err != nil {
+		return err
+	}
+	c.recordEvent(apiservice, eventMappedCloudIP, "Mapped Cloud IP %s to load balancer %s", cip.ID, lb.ID)
+	return nil
+}
+
+// ensureOtherCloudIPsDeposed unmaps any Cloud IP from the load balancer
+// that is not in the wanted list
+func (c *cloud) ensureOtherCloudIPsDeposed(ctx context.Context, cloudIPList []brightbox.CloudIP, wanted []string) error {
+	klog.V(4).Infof("ensureOtherCloudIPsDeposed (%v)", wanted)
+	for _, cip := range cloudIPList {
+		if slices.Contains(wanted, cip.ID) {
+			continue
+		}
+		client, err := c.CloudClient()
+		if err != nil {
+			return err
+		}
+		if _, err := client.UnMapCloudIP(ctx, cip.ID); err != nil {
+			klog.V(4).Infof("Error unmapping CloudIP %q", cip.ID)
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudIPAllocations returns the Cloud IPs listed in the allocation
+// annotation, in order and without duplicates
+func cloudIPAllocations(apiservice *v1.Service) []string {
+	value, ok := apiservice.Annotations[serviceAnnotationLoadBalancerCloudipAllocations]
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" && !slices.Contains(result, entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ensureAllocatedCloudIPs returns every Cloud IP listed in the
+// allocation annotation, or the single Cloud IP chosen by
+// ensureAllocatedCloudIP if there is no annotation
+func (c *cloud) ensureAllocatedCloudIPs(ctx context.Context, owned *ownedResources, apiservice *v1.Service) ([]*brightbox.CloudIP, error) {
+	allocations := cloudIPAllocations(apiservice)
+	if len(allocations) == 0 {
+		cip, err := c.ensureAllocatedCloudIP(ctx, owned, apiservice)
+		if err != nil {
+			return nil, err
+		}
+		return []*brightbox.CloudIP{cip}, nil
+	}
+	klog.V(4).Infof("ensureAllocatedCloudIPs (%v)", allocations)
+	result := make([]*brightbox.CloudIP, 0, len(allocations))
+	for _, cipID := range allocations {
+		cip, err := c.GetCloudIP(ctx, cipID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, cip)
+	}
+	return result, nil
+}
+
+func (c *cloud) ensureAllocatedCloudIP(ctx context.Context, owned *ownedResources, apiservice *v1.Service) (*brightbox.CloudIP, error) {
 	klog.V(4).Info("ensureAllocatedCloudIP")
-	if cipID, ok := apiservice.Annotations[serviceAnnotationLoadBalancerCloudipAllocations]; ok {
-		return c.GetCloudIP(ctx, cipID)
+	if allocations := cloudIPAllocations(apiservice); len(allocations) > 0 {
+		return c.GetCloudIP(ctx, allocations[0])
 	}
 	if ip := apiservice.Spec.LoadBalancerIP; ip != "" {
 		return lookupCloudIPByIP(ctx, c, ip)
 	}
-	return lookupCloudIPByName(ctx, c, name)
+	return lookupOwnedCloudIP(ctx, c, owned, apiservice)
+}
+
+// errorIfNotComplete returns an error until the load balancer is built
+// with every wanted Cloud IP mapped to it
+func errorIfNotComplete(lb *brightbox.LoadBalancer, wanted []string, name string) error {
+	for _, cipID := range wanted {
+		if err := k8ssdk.ErrorIfNotComplete(lb, cipID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cloudIPIDs(cloudIPs []*brightbox.CloudIP) []string {
+	result := make([]string, 0, len(cloudIPs))
+	for _, cip := range cloudIPs {
+		result = append(result, cip.ID)
+	}
+	return result
+}
+
+// ensureCloudIPReverseDNS sets the reverse DNS name asked for by the
+// service on the Cloud IPs the name resolves to, so the PTR record
+// confirms forwards. It errors if the name resolves to none of them.
+// If the service trusts its domains, every Cloud IP gets the name.
+// Removing the annotation leaves the reverse DNS as it is.
+func (c *cloud) ensureCloudIPReverseDNS(ctx context.Context, apiservice *v1.Service, cloudIPs []*brightbox.CloudIP) ([]*brightbox.CloudIP, error) {
+	name := canonicalName(apiservice.Annotations[serviceAnnotationLoadBalancerReverseDNS])
+	if name == "" {
+		return cloudIPs, nil
+	}
+	klog.V(4).Infof("ensureCloudIPReverseDNS (%q, %v)", name, cloudIPIDs(cloudIPs))
+	resolver := c.domainResolver(apiservice)
+	var addresses []net.IP
+	if resolver != nil {
+		var err error
+		addresses, err = resolver.LookupIP(ctx, "ip", name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve reverse DNS name %q: %v", name, err)
+		}
+	}
+	result := slices.Clone(cloudIPs)
+	confirmed := false
+	for i, cip := range cloudIPs {
+		if resolver != nil {
+			ipList, err := toIPList(cip)
+			if err != nil {
+				return nil, err
+			}
+			if !anyAddressMatch(ipList, addresses) {
+				continue
+			}
+		}
+		confirmed = true
+		if canonicalName(cip.ReverseDNS) == name {
+			continue
+		}
+		updated, err := c.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: cip.ID, ReverseDNS: &name})
+		if err != nil {
+			return nil, err
+		}
+		c.recordEvent(apiservice, eventSetReverseDNS, "Set the reverse DNS of Cloud IP %s to %s", cip.ID, name)
+		result[i] = updated
+	}
+	if !confirmed {
+		return nil, fmt.Errorf("Reverse DNS name %q does not resolve to Cloud IP %s", name, strings.Join(cloudIPIDs(cloudIPs), ","))
+	}
+	return result, nil
 }
 
 func lookupCloudIPByIP(ctx context.Context, c *cloud, ip string) (*brightbox.CloudIP, error) {
@@ -89,18 +333,45 @@ func lookupCloudIPByIP(ctx context.Context, c *cloud, ip string) (*brightbox.Clo
 	return cip, nil
 }
 
-func lookupCloudIPByName(ctx context.Context, c *cloud, name string) (*brightbox.CloudIP, error) {
+// lookupOwnedCloudIP returns a Cloud IP from the ledger, or one already
+// mapped to the service's load balancer. Failing that it reclaims a
+// Cloud IP released under the same name, or allocates a new one, and
+// records it in the ledger.
+func lookupOwnedCloudIP(ctx context.Context, c *cloud, owned *ownedResources, apiservice *v1.Service) (*brightbox.CloudIP, error) {
 	cloudIPList, err := c.GetCloudIPs(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	cip := findMatchingCloudIP(cloudIPList, func(cip *brightbox.CloudIP) bool {
-		return cip.Name == name || (cip.LoadBalancer != nil && cip.LoadBalancer.Name == name)
+		return owned.ledger.ownsCloudIP(cip.ID) ||
+			(owned.ledger.loadBalancer != "" && cip.LoadBalancer != nil && cip.LoadBalancer.ID == owned.ledger.loadBalancer)
 	})
 
-	if cip == nil {
-		return c.AllocateCloudIP(ctx, name)
+	if cip != nil {
+		return cip, nil
+	}
+
+	released := releasedCloudIPName(owned.group.Name)
+	cip = findMatchingCloudIP(cloudIPList, func(cip *brightbox.CloudIP) bool {
+		return cip.Name == released && mappedCloudIPDestination(cip) == "" && cip.LoadBalancer == nil
+	})
+	if cip != nil {
+		cip, err = c.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: cip.ID, Name: &owned.group.Name})
+		if err != nil {
+			return nil, err
+		}
+		c.recordEvent(apiservice, eventReclaimedCloudIP, "Reclaimed retained Cloud IP %s (%s)", cip.ID, cip.PublicIPv4)
+	} else {
+		cip, err = c.AllocateCloudIP(ctx, owned.group.Name)
+		if err != nil {
+			return nil, err
+		}
+		c.recordEvent(apiservice, eventAllocatedCloudIP, "Allocated Cloud IP %s (%s)", cip.ID, cip.PublicIPv4)
+	}
+	owned.ledger.cloudIPs = append(owned.ledger.cloudIPs, cip.ID)
+	if err := c.saveLedger(ctx, owned); err != nil {
+		return nil, err
 	}
 	return cip, nil
 }
@@ -0,0 +1,404 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/cloudipstatus"
+	"github.com/brightbox/gobrightbox/v2/enums/transportprotocol"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Values of the Cloud IP target annotation
+const (
+	cloudIPTargetNode        = "node"
+	cloudIPTargetServerGroup = "server-group"
+)
+
+var validCloudIPTargets = []string{cloudIPTargetNode, cloudIPTargetServerGroup}
+
+// Traffic arrives at a Cloud IP straight from the client, so the node
+// ports of the Cloud IP's target are opened to everyone unless the
+// service sets loadBalancerSourceRanges
+var publicSourceCIDRs = []string{"0.0.0.0/0", "::/0"}
+
+// vipIPMode tells kube-proxy the Cloud IP delivers traffic to the node
+// unchanged, apart from the port
+var vipIPMode = v1.LoadBalancerIPModeVIP
+
+// ensureCloudIPService gives the service a Cloud IP mapped straight to
+// one of the nodes instead of a load balancer. Port translators on the
+// Cloud IP forward each service port to its node port, and the node
+// sees the client's own address.
+func (c *cloud) ensureCloudIPService(ctx context.Context, name string, clusterName string, apiservice *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	klog.V(4).Infof("ensureCloudIPService (%q)", name)
+	start := time.Now()
+	owned, err := c.ensureOwnedResources(ctx, name, clusterName, apiservice)
+	observeStep(stepOwnership, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventOwnershipConflict, err)
+	}
+	if err := c.recordLoadBalancerName(ctx, apiservice, name); err != nil {
+		return nil, err
+	}
+	if err := c.ensureLoadBalancerReplaced(ctx, owned, apiservice); err != nil {
+		return nil, c.recordWarning(apiservice, eventLoadBalancerDeletionFailed, err)
+	}
+	start = time.Now()
+	cip, err := c.ensureAllocatedCloudIP(ctx, owned, apiservice)
+	observeStep(stepCloudIP, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventCloudIPAllocationFailed, err)
+	}
+	start = time.Now()
+	reversed, err := c.ensureCloudIPReverseDNS(ctx, apiservice, []*brightbox.CloudIP{cip})
+	observeStep(stepReverseDNS, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventReverseDNSFailed, err)
+	}
+	cip = reversed[0]
+	nodes = preferReadyNodes(nodes)
+	target, err := selectCloudIPTarget(owned, apiservice, cip, nodes)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventCloudIPMappingFailed, err)
+	}
+	if err := c.ensureFirewallOpenForService(ctx, owned, apiservice, nodes, target); err != nil {
+		return nil, c.recordWarning(apiservice, eventFirewallUpdateFailed, err)
+	}
+	start = time.Now()
+	cip, err = c.ensureCloudIPMappedToNode(ctx, apiservice, cip, target)
+	observeStep(stepMapCloudIP, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventCloudIPMappingFailed, err)
+	}
+	start = time.Now()
+	err = c.ensureCloudIPsDeleted(ctx, []string{cip.ID}, owned)
+	observeStep(stepReleaseCloudIPs, start)
+	if err != nil {
+		return nil, err
+	}
+	c.resources.set(apiservice.UID, serviceResources{
+		cloudIPs:         1,
+		serverGroups:     1,
+		firewallPolicies: 1,
+	})
+	return toCloudIPStatus(cip, c.getStatusMode(apiservice)), nil
+}
+
+// ensureLoadBalancerReplaced removes the load balancer built for the
+// service before it switched to Cloud IP mode. Its Cloud IPs stay
+// mapped to it until it has gone, so this errors until then.
+func (c *cloud) ensureLoadBalancerReplaced(ctx context.Context, owned *ownedResources, apiservice *v1.Service) error {
+	if owned.ledger.loadBalancer == "" {
+		return nil
+	}
+	klog.V(4).Infof("ensureLoadBalancerReplaced (%q)", owned.ledger.loadBalancer)
+	lb, err := c.ensureLoadBalancerDestroyed(ctx, owned)
+	if err != nil {
+		return err
+	}
+	if lb != nil {
+		c.recordEvent(apiservice, eventDeletedLoadBalancer, "Deleted load balancer %s", lb.ID)
+	}
+	if err := c.ensureLoadBalancerErased(ctx, owned); err != nil {
+		return err
+	}
+	owned.ledger.loadBalancer = ""
+	return c.saveLedger(ctx, owned)
+}
+
+// selectCloudIPTarget returns the service's server group, or the
+// server of one of the nodes, for the Cloud IP to be mapped to, staying
+// where it is if that node is still in the list
+func selectCloudIPTarget(owned *ownedResources, apiservice *v1.Service, cip *brightbox.CloudIP, nodes []*v1.Node) (string, error) {
+	if cip.LoadBalancer != nil || cip.DatabaseServer != nil ||
+		(cip.ServerGroup != nil && cip.ServerGroup.ID != owned.group.ID) {
+		return "", fmt.Errorf("Cloud IP %s is mapped elsewhere. Unmap it to use it with service %s/%s", cip.ID, apiservice.Namespace, apiservice.Name)
+	}
+	if apiservice.Annotations[serviceAnnotationLoadBalancerCloudIPTarget] == cloudIPTargetServerGroup {
+		return owned.group.ID, nil
+	}
+	target := selectCloudIPServer(mappedCloudIPDestination(cip), nodes)
+	if target == "" {
+		return "", fmt.Errorf("No nodes with a provider ID to map Cloud IP %s to", cip.ID)
+	}
+	return target, nil
+}
+
+// ensureCloudIPMappedToNode maps the Cloud IP to the target server or
+// server group. Its port translators are set to match the service.
+func (c *cloud) ensureCloudIPMappedToNode(ctx context.Context, apiservice *v1.Service, cip *brightbox.CloudIP, target string) (*brightbox.CloudIP, error) {
+	current := mappedCloudIPDestination(cip)
+	translators := buildPortTranslators(apiservice)
+	if !portTranslatorsEqual(cip.PortTranslators, translators) {
+		updated, err := c.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: cip.ID, PortTranslators: translators})
+		if err != nil {
+			return nil, err
+		}
+		cip = updated
+	}
+	if target == current {
+		return cip, nil
+	}
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	if current != "" {
+		if _, err := client.UnMapCloudIP(ctx, cip.ID); err != nil {
+			return nil, err
+		}
+	}
+	cip, err = client.MapCloudIP(ctx, cip.ID, brightbox.CloudIPAttachment{Destination: target})
+	if err != nil {
+		return nil, err
+	}
+	c.recordEvent(apiservice, eventMappedCloudIP, "Mapped Cloud IP %s to %s", cip.ID, target)
+	return cip, nil
+}
+
+// mappedCloudIPDestination returns the server or server group the
+// Cloud IP is mapped to, if any
+func mappedCloudIPDestination(cip *brightbox.CloudIP) string {
+	switch {
+	case cip.Status != cloudipstatus.Mapped:
+		return ""
+	case cip.Server != nil:
+		return cip.Server.ID
+	case cip.ServerGroup != nil:
+		return cip.ServerGroup.ID
+	}
+	return ""
+}
+
+// ensureCloudIPUnmappedFromNode unmaps a Cloud IP mapped straight to a
+// server or server group. Only services in Cloud IP mode map Cloud IPs
+// to those.
+func (c *cloud) ensureCloudIPUnmappedFromNode(ctx context.Context, cip *brightbox.CloudIP) error {
+	destination := mappedCloudIPDestination(cip)
+	if destination == "" {
+		return nil
+	}
+	klog.V(4).Infof("ensureCloudIPUnmappedFromNode (%q, %q)", cip.ID, destination)
+	client, err := c.CloudClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.UnMapCloudIP(ctx, cip.ID)
+	return err
+}
+
+// ensureAllocatedCloudIPReleased unmaps the Cloud IPs named in the
+// service's allocation annotation from its node. The Cloud IPs belong
+// to the user, so they are left allocated.
+func (c *cloud) ensureAllocatedCloudIPReleased(ctx context.Context, apiservice *v1.Service) error {
+	for _, cipID := range cloudIPAllocations(apiservice) {
+		cip, err := c.GetCloudIP(ctx, cipID)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.ensureCloudIPUnmappedFromNode(ctx, cip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectCloudIPServer returns the server the Cloud IP should be mapped
+// to: the current one if its node is still listed, otherwise the
+// server of the first node by name
+func selectCloudIPServer(current string, nodes []*v1.Node) string {
+	servers := mapNodesToServerIDs(slices.SortedFunc(slices.Values(nodes), func(a, b *v1.Node) int {
+		return cmp.Compare(a.Name, b.Name)
+	}))
+	if len(servers) == 0 {
+		return ""
+	}
+	if slices.Contains(servers, current) {
+		return current
+	}
+	return servers[0]
+}
+
+// buildPortTranslators forwards each service port to its node port
+func buildPortTranslators(apiservice *v1.Service) []brightbox.PortTranslator {
+	result := make([]brightbox.PortTranslator, 0, len(apiservice.Spec.Ports))
+	for _, port := range apiservice.Spec.Ports {
+		protocol := transportprotocol.Tcp
+		if port.Protocol == v1.ProtocolUDP {
+			protocol = transportprotocol.Udp
+		}
+		result = append(result, brightbox.PortTranslator{
+			Incoming: uint16(port.Port),
+			Outgoing: uint16(port.NodePort),
+			Protocol: protocol,
+		})
+	}
+	return result
+}
+
+// ensurePortTranslatorsCleared stops a Cloud IP leaving Cloud IP mode
+// translating ports. The API ignores an empty list, so each port is
+// translated to itself instead.
+func (c *cloud) ensurePortTranslatorsCleared(ctx context.Context, cip *brightbox.CloudIP) error {
+	if len(cip.PortTranslators) == 0 {
+		return nil
+	}
+	translators := make([]brightbox.PortTranslator, 0, len(cip.PortTranslators))
+	for _, translator := range cip.PortTranslators {
+		translator.Outgoing = translator.Incoming
+		translators = append(translators, translator)
+	}
+	if portTranslatorsEqual(cip.PortTranslators, translators) {
+		return nil
+	}
+	_, err := c.updateCloudIP(ctx, brightbox.CloudIPOptions{ID: cip.ID, PortTranslators: translators})
+	return err
+}
+
+func portTranslatorsEqual(a []brightbox.PortTranslator, b []brightbox.PortTranslator) bool {
+	compare := func(x, y brightbox.PortTranslator) int {
+		return cmp.Or(
+			cmp.Compare(x.Incoming, y.Incoming),
+			cmp.Compare(x.Protocol.String(), y.Protocol.String()),
+		)
+	}
+	return slices.Equal(slices.SortedFunc(slices.Values(a), compare), slices.SortedFunc(slices.Values(b), compare))
+}
+
+// toCloudIPStatus publishes the addresses of a Cloud IP mapped straight
+// to a node
+func toCloudIPStatus(cip *brightbox.CloudIP, mode string) *v1.LoadBalancerStatus {
+	status := toLoadBalancerStatus(&brightbox.LoadBalancer{CloudIPs: []brightbox.CloudIP{*cip}}, mode)
+	for i := range status.Ingress {
+		if status.Ingress[i].IP != "" {
+			status.Ingress[i].IPMode = &vipIPMode
+		}
+	}
+	return status
+}
+
+// getCloudIPServiceStatus returns the status of a service in Cloud IP
+// mode, or nil if its Cloud IP is not mapped
+func (c *cloud) getCloudIPServiceStatus(ctx context.Context, owned *ownedResources, apiservice *v1.Service) (*v1.LoadBalancerStatus, error) {
+	if owned == nil {
+		return nil, nil
+	}
+	cloudIPList, err := c.GetCloudIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allocated := cloudIPAllocations(apiservice)
+	cip := findMatchingCloudIP(cloudIPList, func(cip *brightbox.CloudIP) bool {
+		return (slices.Contains(allocated, cip.ID) || owned.ledger.ownsCloudIP(cip.ID)) &&
+			mappedCloudIPDestination(cip) != ""
+	})
+	if cip == nil {
+		return nil, nil
+	}
+	return toCloudIPStatus(cip, c.getStatusMode(apiservice)), nil
+}
+
+// preferReadyNodes returns the nodes that are ready, so the Cloud IP
+// moves off a failed node. If none are ready all the nodes are kept,
+// rather than leave the service without an address.
+func preferReadyNodes(nodes []*v1.Node) []*v1.Node {
+	result := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if isNodeReady(node) {
+			result = append(result, node)
+		}
+	}
+	if len(result) == 0 {
+		return nodes
+	}
+	return result
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// watchNodeReadiness re-syncs services in Cloud IP mode when a node
+// becomes ready or not ready, queueing them whenever a hash of the ready
+// nodes moves on. The service controller does not re-sync load
+// balancers when node readiness changes.
+func (c *cloud) watchNodeReadiness() {
+	klog.V(4).Info("watchNodeReadiness called")
+	informer := c.informerFactory.Core().V1().Nodes()
+	services := c.informerFactory.Core().V1().Services().Lister()
+	nodes := informer.Lister()
+	handler := func() {
+		nodeList, err := nodes.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("Failed to list nodes: %v", err)
+			return
+		}
+		ready := sets.New[string]()
+		for _, node := range nodeList {
+			if isNodeReady(node) {
+				ready.Insert(node.Name)
+			}
+		}
+		serviceList, err := services.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("Failed to list services: %v", err)
+			return
+		}
+		hash := endpointNodesHash(ready)
+		for _, service := range serviceList {
+			if service.Spec.Type != v1.ServiceTypeLoadBalancer || c.serviceMode(service) != modeCloudIP {
+				continue
+			}
+			c.queueServiceResync(service, resyncReadyNodes, hash)
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { handler() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1.Node)
+			if !ok || isNodeReady(oldNode) == isNodeReady(newNode) {
+				return
+			}
+			handler()
+		},
+		DeleteFunc: func(interface{}) { handler() },
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch node readiness: %v", err)
+	}
+}
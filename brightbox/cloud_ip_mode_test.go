@@ -0,0 +1,589 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/cloudipstatus"
+	"github.com/brightbox/gobrightbox/v2/enums/transportprotocol"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectCloudIPServer(t *testing.T) {
+	nodes := []*v1.Node{
+		testNode("node-b", "srv-bbbbb"),
+		testNode("node-a", "srv-aaaaa"),
+		testNode("node-c", ""),
+	}
+	testCases := map[string]struct {
+		current  string
+		nodes    []*v1.Node
+		expected string
+	}{
+		"unmapped": {
+			nodes:    nodes,
+			expected: "srv-aaaaa",
+		},
+		"stays put": {
+			current:  "srv-bbbbb",
+			nodes:    nodes,
+			expected: "srv-bbbbb",
+		},
+		"node gone": {
+			current:  "srv-ccccc",
+			nodes:    nodes,
+			expected: "srv-aaaaa",
+		},
+		"no servers": {
+			nodes: nodes[2:],
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(selectCloudIPServer(tc.current, tc.nodes), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestPortTranslatorsEqual(t *testing.T) {
+	smtp := brightbox.PortTranslator{Incoming: 25, Outgoing: 30025, Protocol: transportprotocol.Tcp}
+	submission := brightbox.PortTranslator{Incoming: 587, Outgoing: 30587, Protocol: transportprotocol.Tcp}
+	testCases := map[string]struct {
+		a        []brightbox.PortTranslator
+		b        []brightbox.PortTranslator
+		expected bool
+	}{
+		"same":           {a: []brightbox.PortTranslator{smtp, submission}, b: []brightbox.PortTranslator{smtp, submission}, expected: true},
+		"reordered":      {a: []brightbox.PortTranslator{submission, smtp}, b: []brightbox.PortTranslator{smtp, submission}, expected: true},
+		"missing":        {a: []brightbox.PortTranslator{smtp}, b: []brightbox.PortTranslator{smtp, submission}},
+		"new node port":  {a: []brightbox.PortTranslator{{Incoming: 25, Outgoing: 31025, Protocol: transportprotocol.Tcp}}, b: []brightbox.PortTranslator{smtp}},
+		"none":           {expected: true},
+		"none to create": {b: []brightbox.PortTranslator{smtp}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(portTranslatorsEqual(tc.a, tc.b), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCloudIPService(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	service := testCloudIPService()
+	nodes := []*v1.Node{
+		testNode("node-b", "srv-bbbbb"),
+		testNode("node-a", "srv-aaaaa"),
+	}
+	status, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes)
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	expected := []string{
+		"create grp-new",
+		"create cip-new",
+		"update grp-new",
+		"add grp-new",
+		"create fwp-new",
+		"create rule tcp 0.0.0.0/0 srv-aaaaa",
+		"create rule tcp ::/0 srv-aaaaa",
+		"update cip-new",
+		"map cip-new srv-aaaaa",
+	}
+	if diff := deep.Equal(fake.calls, expected); diff != nil {
+		t.Error(diff)
+	}
+	expectedStatus := &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{IP: "109.107.50.1", IPMode: &vipIPMode}},
+	}
+	if diff := deep.Equal(status, expectedStatus); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(fake.cloudIPs[len(fake.cloudIPs)-1].PortTranslators, []brightbox.PortTranslator{
+		{Incoming: 25, Outgoing: 30025, Protocol: transportprotocol.Tcp},
+	}); diff != nil {
+		t.Error(diff)
+	}
+
+	// The node goes
+	fake.calls = nil
+	if _, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes[:1]); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls, []string{
+		"remove grp-new",
+		"update rule fwr-tcp-0.0.0.0/0 srv-bbbbb",
+		"update rule fwr-tcp-::/0 srv-bbbbb",
+		"unmap cip-new",
+		"map cip-new srv-bbbbb",
+	}); diff != nil {
+		t.Error(diff)
+	}
+	status, exists, err := client.GetLoadBalancer(context.TODO(), "kubernetes", service)
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if !exists {
+		t.Errorf("expected the Cloud IP to be found")
+	}
+	if diff := deep.Equal(status, expectedStatus); diff != nil {
+		t.Error(diff)
+	}
+
+	fake.calls = nil
+	if err := client.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", service); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	expected = []string{
+		"unmap cip-new",
+		"destroy cip-new",
+		"update grp-new",
+		"destroy fwp-new",
+		"remove grp-new",
+		"destroy grp-new",
+	}
+	if diff := deep.Equal(fake.calls, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCloudIPServiceFailover(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	service := testCloudIPService()
+	nodes := []*v1.Node{
+		testReadyNode("node-a", "srv-aaaaa", true),
+		testReadyNode("node-b", "srv-bbbbb", true),
+	}
+	if _, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	fake.calls = nil
+	nodes[0] = testReadyNode("node-a", "srv-aaaaa", false)
+	if _, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls, []string{
+		"remove grp-new",
+		"update rule fwr-tcp-0.0.0.0/0 srv-bbbbb",
+		"update rule fwr-tcp-::/0 srv-bbbbb",
+		"unmap cip-new",
+		"map cip-new srv-bbbbb",
+	}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCloudIPServiceServerGroupTarget(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	service := testCloudIPService()
+	service.Annotations[serviceAnnotationLoadBalancerCloudIPTarget] = cloudIPTargetServerGroup
+	nodes := []*v1.Node{testNode("node-a", "srv-aaaaa")}
+	if _, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls[len(fake.calls)-1], "map cip-new grp-new"); diff != nil {
+		t.Error(diff)
+	}
+	status, exists, err := client.GetLoadBalancer(context.TODO(), "kubernetes", service)
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if !exists || len(status.Ingress) != 1 {
+		t.Errorf("expected the Cloud IP to be found, got %v", status)
+	}
+}
+
+func TestCloudIPServiceReplacesLoadBalancer(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	service := testLedgerService("web", "uid-web")
+	service.Annotations = map[string]string{serviceAnnotationLoadBalancerMode: modeNameCloudIP}
+	service.Spec.SessionAffinity = v1.ServiceAffinityNone
+	service.Spec.Ports = []v1.ServicePort{
+		{Name: "game", Protocol: v1.ProtocolUDP, Port: 27015, NodePort: 30015},
+	}
+	nodes := []*v1.Node{testNode("node-a", "srv-aaaaa")}
+	if _, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	expected := []string{
+		"update grp-web",
+		"destroy lba-web",
+		"update grp-web",
+		"add grp-web",
+		"update fwp-web",
+		"create rule udp 0.0.0.0/0 srv-aaaaa",
+		"create rule udp ::/0 srv-aaaaa",
+		"update cip-web",
+		"map cip-web srv-aaaaa",
+	}
+	if diff := deep.Equal(fake.calls, expected); diff != nil {
+		t.Error(diff)
+	}
+	ledger, _ := parseOwnershipLedger(fake.groups[0].Description)
+	if diff := deep.Equal(ledger.loadBalancer, ""); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(fake.cloudIPs[0].PortTranslators, []brightbox.PortTranslator{
+		{Incoming: 27015, Outgoing: 30015, Protocol: transportprotocol.Udp},
+	}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEnsureMappedCloudIPLeavesCloudIPMode(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	cip := &fake.cloudIPs[0]
+	cip.Status = cloudipstatus.Mapped
+	cip.Server = &brightbox.Server{ID: "srv-aaaaa"}
+	cip.PortTranslators = []brightbox.PortTranslator{{Incoming: 25, Outgoing: 30025, Protocol: transportprotocol.Tcp}}
+	ledger, _ := parseOwnershipLedger(fake.groups[0].Description)
+	owned := &ownedResources{group: &fake.groups[0], ledger: ledger}
+	mapped := *cip
+	if err := client.ensureMappedCloudIP(context.TODO(), owned, testLedgerService("web", "uid-web"), &brightbox.LoadBalancer{ID: "lba-web"}, &mapped); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls, []string{"unmap cip-web", "update cip-web", "map cip-web lba-web"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(cip.PortTranslators, []brightbox.PortTranslator{{Incoming: 25, Outgoing: 25, Protocol: transportprotocol.Tcp}}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildPortTranslators(t *testing.T) {
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Name: "smtp", Protocol: v1.ProtocolTCP, Port: 25, NodePort: 30025},
+				{Name: "game", Protocol: v1.ProtocolUDP, Port: 27015, NodePort: 30015},
+			},
+		},
+	}
+	expected := []brightbox.PortTranslator{
+		{Incoming: 25, Outgoing: 30025, Protocol: transportprotocol.Tcp},
+		{Incoming: 27015, Outgoing: 30015, Protocol: transportprotocol.Udp},
+	}
+	if diff := deep.Equal(buildPortTranslators(service), expected); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(createPortListString(service), "30025"); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(createUDPPortListString(service), "30015"); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestPreferReadyNodes(t *testing.T) {
+	ready := testReadyNode("node-a", "srv-aaaaa", true)
+	notReady := testReadyNode("node-b", "srv-bbbbb", false)
+	unknown := testNode("node-c", "srv-ccccc")
+	testCases := map[string]struct {
+		nodes    []*v1.Node
+		expected []*v1.Node
+	}{
+		"some ready": {
+			nodes:    []*v1.Node{ready, notReady, unknown},
+			expected: []*v1.Node{ready},
+		},
+		"none ready": {
+			nodes:    []*v1.Node{notReady, unknown},
+			expected: []*v1.Node{notReady, unknown},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(preferReadyNodes(tc.nodes), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestValidateCloudIPServiceSpec(t *testing.T) {
+	testCases := map[string]struct {
+		protocol     v1.Protocol
+		sourceRanges []string
+		status       string
+	}{
+		"tcp":  {protocol: v1.ProtocolTCP},
+		"udp":  {protocol: v1.ProtocolUDP},
+		"sctp": {protocol: v1.ProtocolSCTP, status: "SCTP nodeports are not supported"},
+		"source ranges": {
+			protocol:     v1.ProtocolTCP,
+			sourceRanges: []string{"192.168.0.0/16"},
+		},
+		"invalid source range": {
+			protocol:     v1.ProtocolTCP,
+			sourceRanges: []string{"192.168.0.0/16", "10.0.0.300/8"},
+			status:       "Invalid load balancer source range \"10.0.0.300/8\": invalid CIDR address: 10.0.0.300/8",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := testCloudIPService()
+			service.Spec.Ports[0].Protocol = tc.protocol
+			service.Spec.LoadBalancerSourceRanges = tc.sourceRanges
+			err := validateCloudIPServiceSpec(service)
+			if tc.status == "" {
+				if err != nil {
+					t.Errorf("Error when not expected: %q", err.Error())
+				}
+			} else if err == nil || err.Error() != tc.status {
+				t.Errorf("expected %q, got %v", tc.status, err)
+			}
+		})
+	}
+}
+
+func testCloudIPService() *v1.Service {
+	class := defaultCloudIPClass
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "smtp",
+			Namespace: "default",
+			UID:       "uid-smtp",
+			Annotations: map[string]string{
+				serviceAnnotationLoadBalancerStatusMode: statusModeIP,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Type:              v1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: &class,
+			SessionAffinity:   v1.ServiceAffinityNone,
+			Ports: []v1.ServicePort{
+				{Name: "smtp", Protocol: v1.ProtocolTCP, Port: 25, NodePort: 30025},
+			},
+		},
+	}
+}
+
+func testNode(name string, server string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if server != "" {
+		node.Spec.ProviderID = k8ssdk.MapServerIDToProviderID(server)
+	}
+	return node
+}
+
+func testReadyNode(name string, server string, ready bool) *v1.Node {
+	node := testNode(name, server)
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}
+	return node
+}
+
+// fakeCloudIPCloud adds what Cloud IP mode needs to fakeLedgerCloud
+type fakeCloudIPCloud struct {
+	*fakeLedgerCloud
+}
+
+func newFakeCloudIPCloud() *fakeCloudIPCloud {
+	return &fakeCloudIPCloud{fakeLedgerCloud: newFakeLedgerCloud()}
+}
+
+func (f *fakeCloudIPCloud) findCloudIP(identifier string) (*brightbox.CloudIP, error) {
+	for i := range f.cloudIPs {
+		if f.cloudIPs[i].ID == identifier {
+			return &f.cloudIPs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to Cloud IP call", identifier)
+}
+
+func (f *fakeCloudIPCloud) CloudIP(_ context.Context, identifier string) (*brightbox.CloudIP, error) {
+	cip, err := f.findCloudIP(identifier)
+	if err != nil {
+		return nil, err
+	}
+	result := *cip
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) CreateCloudIP(_ context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	cip := brightbox.CloudIP{
+		ID:         "cip-new",
+		Name:       *options.Name,
+		PublicIPv4: "109.107.50.1",
+		Fqdn:       "cip-109-107-50-1.gb1.brightbox.com",
+		Status:     cloudipstatus.Unmapped,
+	}
+	f.calls = append(f.calls, "create "+cip.ID)
+	f.cloudIPs = append(f.cloudIPs, cip)
+	return &cip, nil
+}
+
+func (f *fakeCloudIPCloud) UpdateCloudIP(_ context.Context, options brightbox.CloudIPOptions) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "update "+options.ID)
+	cip, err := f.findCloudIP(options.ID)
+	if err != nil {
+		return nil, err
+	}
+	if options.Name != nil {
+		cip.Name = *options.Name
+	}
+	if options.ReverseDNS != nil {
+		cip.ReverseDNS = *options.ReverseDNS
+	}
+	if options.PortTranslators != nil {
+		cip.PortTranslators = options.PortTranslators
+	}
+	result := *cip
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) MapCloudIP(_ context.Context, identifier string, attachment brightbox.CloudIPAttachment) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "map "+identifier+" "+attachment.Destination)
+	cip, err := f.findCloudIP(identifier)
+	if err != nil {
+		return nil, err
+	}
+	if cip.Status == cloudipstatus.Mapped {
+		return nil, fmt.Errorf("Cloud IP %s is already mapped", identifier)
+	}
+	cip.Status = cloudipstatus.Mapped
+	cip.Server = &brightbox.Server{ID: attachment.Destination}
+	result := *cip
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) UnMapCloudIP(_ context.Context, identifier string) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "unmap "+identifier)
+	cip, err := f.findCloudIP(identifier)
+	if err != nil {
+		return nil, err
+	}
+	cip.Status = cloudipstatus.Unmapped
+	cip.Server = nil
+	result := *cip
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) findGroup(identifier string) (*brightbox.ServerGroup, error) {
+	for i := range f.groups {
+		if f.groups[i].ID == identifier {
+			return &f.groups[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to server group call", identifier)
+}
+
+func (f *fakeCloudIPCloud) AddServersToServerGroup(_ context.Context, identifier string, servers brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	f.calls = append(f.calls, "add "+identifier)
+	group, err := f.findGroup(identifier)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range servers.Servers {
+		group.Servers = append(group.Servers, brightbox.Server{ID: member.Server})
+	}
+	result := *group
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) RemoveServersFromServerGroup(_ context.Context, identifier string, servers brightbox.ServerGroupMemberList) (*brightbox.ServerGroup, error) {
+	f.calls = append(f.calls, "remove "+identifier)
+	group, err := f.findGroup(identifier)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []brightbox.Server
+	for _, server := range group.Servers {
+		removed := false
+		for _, member := range servers.Servers {
+			removed = removed || member.Server == server.ID
+		}
+		if !removed {
+			remaining = append(remaining, server)
+		}
+	}
+	group.Servers = remaining
+	result := *group
+	return &result, nil
+}
+
+func (f *fakeCloudIPCloud) CreateFirewallPolicy(_ context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	policy := brightbox.FirewallPolicy{ID: "fwp-new", Name: *options.Name, Description: *options.Description}
+	f.calls = append(f.calls, "create "+policy.ID)
+	f.policies = append(f.policies, policy)
+	group, err := f.findGroup(options.ServerGroup)
+	if err != nil {
+		return nil, err
+	}
+	group.FirewallPolicy = &brightbox.FirewallPolicy{ID: policy.ID}
+	return &policy, nil
+}
+
+func (f *fakeCloudIPCloud) UpdateFirewallPolicy(_ context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	f.calls = append(f.calls, "update "+options.ID)
+	for i := range f.policies {
+		if f.policies[i].ID == options.ID {
+			f.policies[i].Description = *options.Description
+			result := f.policies[i]
+			return &result, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to UpdateFirewallPolicy", options.ID)
+}
+
+func (f *fakeCloudIPCloud) CreateFirewallRule(_ context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	f.calls = append(f.calls, "create rule "+*options.Protocol+" "+*options.Source+" "+*options.Destination)
+	rule := brightbox.FirewallRule{
+		ID:              "fwr-" + *options.Protocol + "-" + *options.Source,
+		Protocol:        *options.Protocol,
+		Source:          *options.Source,
+		Destination:     *options.Destination,
+		DestinationPort: *options.DestinationPort,
+		Description:     *options.Description,
+	}
+	for i := range f.policies {
+		if f.policies[i].ID == options.FirewallPolicy {
+			f.policies[i].Rules = append(f.policies[i].Rules, rule)
+		}
+	}
+	return &rule, nil
+}
+
+func (f *fakeCloudIPCloud) UpdateFirewallRule(_ context.Context, options brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	f.calls = append(f.calls, "update rule "+options.ID+" "+*options.Destination)
+	for i := range f.policies {
+		for j := range f.policies[i].Rules {
+			if f.policies[i].Rules[j].ID == options.ID {
+				f.policies[i].Rules[j].Destination = *options.Destination
+				result := f.policies[i].Rules[j]
+				return &result, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to UpdateFirewallRule", options.ID)
+}
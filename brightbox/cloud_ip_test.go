@@ -0,0 +1,351 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/loadbalancerstatus"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCloudIPAllocations(t *testing.T) {
+	testCases := map[string]struct {
+		annotations map[string]string
+		expected    []string
+	}{
+		"none": {},
+		"single": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerCloudipAllocations: "cip-aaaaa"},
+			expected:    []string{"cip-aaaaa"},
+		},
+		"list": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerCloudipAllocations: "cip-bbbbb, cip-aaaaa,cip-bbbbb,"},
+			expected:    []string{"cip-bbbbb", "cip-aaaaa"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if diff := deep.Equal(cloudIPAllocations(service), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestValidateCloudIPAllocations(t *testing.T) {
+	testCases := map[string]struct {
+		value  string
+		status string
+	}{
+		"list": {
+			value: "cip-aaaaa,cip-bbbbb",
+		},
+		"spaces": {
+			value: "cip-aaaaa, cip-bbbbb",
+		},
+		"bad entry": {
+			value:  "cip-aaaaa,10.0.0.1",
+			status: `"service.beta.kubernetes.io/brightbox-load-balancer-cloudip-allocations" needs to match the pattern "^cip-[0-9a-z]{5,}$"`,
+		},
+		"empty entry": {
+			value:  "cip-aaaaa,,cip-bbbbb",
+			status: `"service.beta.kubernetes.io/brightbox-load-balancer-cloudip-allocations" needs to match the pattern "^cip-[0-9a-z]{5,}$"`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateAnnotations(map[string]string{serviceAnnotationLoadBalancerCloudipAllocations: tc.value})
+			if tc.status == "" {
+				if err != nil {
+					t.Errorf("Error when not expected: %q", err.Error())
+				}
+			} else if err == nil || err.Error() != tc.status {
+				t.Errorf("expected %q, got %v", tc.status, err)
+			}
+		})
+	}
+	service := testCloudIPService()
+	service.Annotations[serviceAnnotationLoadBalancerCloudipAllocations] = "cip-aaaaa,cip-bbbbb"
+	if err := validateCloudIPServiceSpec(service); err == nil {
+		t.Errorf("expected Cloud IP mode to reject more than one Cloud IP")
+	}
+}
+
+func TestEnsureOtherCloudIPsDeposed(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	mapped := []brightbox.CloudIP{{ID: "cip-web"}, {ID: "cip-old"}, {ID: "cip-api"}}
+	if err := client.ensureOtherCloudIPsDeposed(context.TODO(), mapped, []string{"cip-api", "cip-web"}); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls, []string{"unmap cip-old"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEnsureCloudIPsDeletedKeepsWanted(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.cloudIPs = append(fake.cloudIPs, brightbox.CloudIP{ID: "cip-extra"})
+	owned.ledger.cloudIPs = append(owned.ledger.cloudIPs, "cip-extra")
+	if err := client.ensureCloudIPsDeleted(context.TODO(), []string{"cip-web", "cip-other"}, owned); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(fake.calls, []string{"destroy cip-extra"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(owned.ledger.cloudIPs, []string{"cip-web"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestErrorIfNotComplete(t *testing.T) {
+	lb := &brightbox.LoadBalancer{
+		ID:       "lba-web",
+		Status:   loadbalancerstatus.Active,
+		CloudIPs: []brightbox.CloudIP{{ID: "cip-aaaaa"}, {ID: "cip-bbbbb"}},
+	}
+	testCases := map[string]struct {
+		wanted []string
+		status string
+	}{
+		"all mapped": {
+			wanted: []string{"cip-bbbbb", "cip-aaaaa"},
+		},
+		"one missing": {
+			wanted: []string{"cip-aaaaa", "cip-ccccc"},
+			status: `Mapping of CloudIP "cip-ccccc" to "lba-web" not complete`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := errorIfNotComplete(lb, tc.wanted, "web.default.kubernetes")
+			if tc.status == "" {
+				if err != nil {
+					t.Errorf("Error when not expected: %q", err.Error())
+				}
+			} else if err == nil || err.Error() != tc.status {
+				t.Errorf("expected %q, got %v", tc.status, err)
+			}
+		})
+	}
+}
+
+func TestRetainsCloudIPs(t *testing.T) {
+	testCases := map[string]struct {
+		config      cloudConfig
+		annotations map[string]string
+		expected    bool
+	}{
+		"none": {},
+		"annotation": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerCloudipRetain: "true"},
+			expected:    true,
+		},
+		"cluster default": {
+			config:   cloudConfig{DefaultAnnotations: map[string]string{serviceAnnotationLoadBalancerCloudipRetain: "true"}},
+			expected: true,
+		},
+		"annotation overrides default": {
+			config:      cloudConfig{DefaultAnnotations: map[string]string{serviceAnnotationLoadBalancerCloudipRetain: "true"}},
+			annotations: map[string]string{serviceAnnotationLoadBalancerCloudipRetain: "false"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &cloud{config: tc.config}
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if diff := deep.Equal(client.retainsCloudIPs(service), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+	err := validateAnnotations(map[string]string{serviceAnnotationLoadBalancerCloudipRetain: "sometimes"})
+	expected := `"service.beta.kubernetes.io/brightbox-load-balancer-cloudip-retain" needs to be true or false`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestCloudIPRetainedAndReclaimed(t *testing.T) {
+	fake := newFakeCloudIPCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ensureCloudIPsReleased(context.TODO(), owned); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(fake.calls, []string{"update cip-web", "update grp-web"}); diff != nil {
+		t.Error(diff)
+	}
+	cip, _ := fake.findCloudIP("cip-web")
+	if diff := deep.Equal(cip.Name, "released:web.default.kubernetes"); diff != nil {
+		t.Error(diff)
+	}
+	if len(owned.ledger.cloudIPs) != 0 {
+		t.Errorf("expected the ledger to drop released Cloud IPs, got %v", owned.ledger.cloudIPs)
+	}
+
+	// A new service with the same name picks it up again
+	fake.calls = nil
+	owned.ledger.loadBalancer = ""
+	result, err := lookupOwnedCloudIP(context.TODO(), client, owned, testLedgerService("web", "uid-web"))
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(result.ID, "cip-web"); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(result.Name, "web.default.kubernetes"); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(fake.calls, []string{"update cip-web", "update grp-web"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(owned.ledger.cloudIPs, []string{"cip-web"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReleasedCloudIPName(t *testing.T) {
+	testCases := map[string]struct {
+		name     string
+		expected string
+	}{
+		"short": {
+			name:     "web.default.kubernetes",
+			expected: "released:web.default.kubernetes",
+		},
+		"longest name": {
+			name:     strings.Repeat("a", maxLoadBalancerNameLength),
+			expected: "released:" + strings.Repeat("a", maxLoadBalancerNameLength-len("released:")-loadBalancerNameHashLength-1) + "-a14907e4",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := releasedCloudIPName(tc.name)
+			if diff := deep.Equal(result, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+			if len(result) > maxLoadBalancerNameLength {
+				t.Errorf("expected at most %d characters, got %d", maxLoadBalancerNameLength, len(result))
+			}
+		})
+	}
+}
+
+func TestEnsureCloudIPReverseDNS(t *testing.T) {
+	resolver := fakeResolver{
+		"mail.example.com":  {resolvCip.PublicIPv6},
+		"other.example.com": {publicIP2},
+	}
+	testCases := map[string]struct {
+		annotations map[string]string
+		reverseDNS  string
+		second      bool
+		expected    []string
+		calls       []string
+		status      string
+	}{
+		"none": {
+			expected: []string{""},
+		},
+		"confirmed": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerReverseDNS: "Mail.example.com."},
+			expected:    []string{"mail.example.com"},
+			calls:       []string{"update cip-web"},
+		},
+		"already set": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerReverseDNS: "mail.example.com"},
+			reverseDNS:  "mail.example.com",
+			expected:    []string{"mail.example.com"},
+		},
+		"second cloud ip": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerReverseDNS: "other.example.com"},
+			second:      true,
+			expected:    []string{"", "other.example.com"},
+			calls:       []string{"update cip-old"},
+		},
+		"not confirmed": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerReverseDNS: "other.example.com"},
+			status:      `Reverse DNS name "other.example.com" does not resolve to Cloud IP cip-web`,
+		},
+		"unresolved": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerReverseDNS: "missing.example.com"},
+			status:      `Failed to resolve reverse DNS name "missing.example.com": lookup missing.example.com: no such host`,
+		},
+		"trusted": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerReverseDNS:           "missing.example.com",
+				serviceAnnotationLoadBalancerSkipDomainResolution: "true",
+			},
+			expected: []string{"missing.example.com"},
+			calls:    []string{"update cip-web"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeCloudIPCloud()
+			client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil), resolver: resolver}
+			web, _ := fake.findCloudIP("cip-web")
+			web.PublicIPv4, web.PublicIPv6, web.ReverseDNS = resolvCip.PublicIPv4, resolvCip.PublicIPv6, tc.reverseDNS
+			old, _ := fake.findCloudIP("cip-old")
+			old.PublicIPv4, old.PublicIPv6 = publicIP2, "2a02:1348:ffff:ffff::bebe:bebe"
+			cloudIPs := []*brightbox.CloudIP{web}
+			if tc.second {
+				cloudIPs = append(cloudIPs, old)
+			}
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			result, err := client.ensureCloudIPReverseDNS(context.TODO(), service, cloudIPs)
+			if tc.status != "" {
+				if err == nil || err.Error() != tc.status {
+					t.Errorf("expected %q, got %v", tc.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			var reverseDNS []string
+			for _, cip := range result {
+				reverseDNS = append(reverseDNS, cip.ReverseDNS)
+			}
+			if diff := deep.Equal(reverseDNS, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(fake.calls, tc.calls); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+	err := validateAnnotations(map[string]string{serviceAnnotationLoadBalancerReverseDNS: "mail_server.example.com"})
+	if err == nil {
+		t.Error("expected an invalid reverse DNS name to be rejected")
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Environment variables read by k8ssdk when it connects to the
+// Brightbox API.
+const (
+	clientEnvVar       = "BRIGHTBOX_CLIENT"
+	clientSecretEnvVar = "BRIGHTBOX_CLIENT_SECRET"
+	usernameEnvVar     = "BRIGHTBOX_USER_NAME"
+	passwordEnvVar     = "BRIGHTBOX_PASSWORD"
+	accountEnvVar      = "BRIGHTBOX_ACCOUNT"
+	apiURLEnvVar       = "BRIGHTBOX_API_URL"
+
+	// The controller manager's default --cluster-name
+	defaultClusterName = "kubernetes"
+)
+
+// cloudConfig is the YAML document passed to the controller with the
+// --cloud-config flag. Every field is optional.
+type cloudConfig struct {
+	// Brightbox API connection details. Any environment variable that
+	// is set overrides the matching value here.
+	APIURL       string `json:"apiURL,omitempty"`
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	UserName     string `json:"userName,omitempty"`
+	Password     string `json:"password,omitempty"`
+	Account      string `json:"account,omitempty"`
+
+	// RegionCIDRs are the source ranges allowed through the node
+	// firewall in load balancer mode, where traffic comes from the load
+	// balancer. Defaults to the region's private IPv4 network.
+	RegionCIDRs []string `json:"regionCIDRs,omitempty"`
+
+	// StatusMode sets what is published in the ingress status of load
+	// balancer services without a status mode annotation: "hostname"
+	// (default), "ip" or "both".
+	StatusMode string `json:"statusMode,omitempty"`
+
+	// DefaultAnnotations are applied to any load balancer service that
+	// does not set the annotation itself.
+	DefaultAnnotations map[string]string `json:"defaultAnnotations,omitempty"`
+
+	// NodeAddresses sets the order and families of the addresses
+	// reported for nodes.
+	NodeAddresses *nodeAddressesConfig `json:"nodeAddresses,omitempty"`
+
+	// ClusterName has to match the controller manager's --cluster-name,
+	// which is checked at startup. It names the load balancers of
+	// services with a Brightbox class, which the service controller does
+	// not handle. Defaults to "kubernetes".
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// LoadBalancerClass is the spec.loadBalancerClass of services given
+	// a Brightbox load balancer, alongside services with no class.
+	// Defaults to "brightbox.com/load-balancer".
+	LoadBalancerClass string `json:"loadBalancerClass,omitempty"`
+
+	// CloudIPClass is the spec.loadBalancerClass of services given a
+	// Cloud IP mapped straight to a node, without a load balancer.
+	// Defaults to "brightbox.com/cloud-ip".
+	CloudIPClass string `json:"cloudIPClass,omitempty"`
+
+	// DNSServers are queried directly, in turn, to check that load
+	// balancer domains resolve, in place of the system resolver. Each
+	// is an address with an optional port, which defaults to 53.
+	DNSServers []string `json:"dnsServers,omitempty"`
+
+	// DNSUpdates has the controller write the DNS records of load
+	// balancer domains. Leave it out to manage the records by hand.
+	DNSUpdates *dnsUpdatesConfig `json:"dnsUpdates,omitempty"`
+
+	// APIClient sets the rate limit, retries and circuit breaker of
+	// Brightbox API calls.
+	APIClient *apiClientConfig `json:"apiClient,omitempty"`
+
+	// APICacheTTL is how long server and Cloud IP lookups are reused
+	// for. Defaults to 30 seconds. Set it to 0s to turn the cache off.
+	APICacheTTL *metav1.Duration `json:"apiCacheTTL,omitempty"`
+
+	// BulkServerLookups answers the node lifecycle checks from a list of
+	// every server in the account, fetched once each apiCacheTTL,
+	// rather than looking up each node's server. Worth turning on for
+	// large clusters in accounts without many other servers.
+	BulkServerLookups bool `json:"bulkServerLookups,omitempty"`
+
+	// AdoptLegacyResources lets a service take over a server group
+	// with no description and its load balancer's name, and the load
+	// balancer and Cloud IPs with the same name, as built by releases
+	// before ownership ledgers. Turn it on while upgrading from such a
+	// release, then off again, as a group built by hand looks the same.
+	AdoptLegacyResources bool `json:"adoptLegacyResources,omitempty"`
+
+	// GarbageCollection enables the sweeper that removes Brightbox
+	// resources left behind by services that no longer exist.
+	GarbageCollection *garbageCollectionConfig `json:"garbageCollection,omitempty"`
+}
+
+// readCloudConfig parses and validates the cloud config. A missing
+// config is the same as an empty one.
+func readCloudConfig(config io.Reader) (*cloudConfig, error) {
+	result := &cloudConfig{}
+	if config == nil {
+		return result, nil
+	}
+	data, err := io.ReadAll(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read cloud config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, result); err != nil {
+		return nil, fmt.Errorf("Failed to parse cloud config: %w", err)
+	}
+	if err := result.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid cloud config: %w", err)
+	}
+	return result, nil
+}
+
+func (cfg *cloudConfig) validate() error {
+	for _, cidr := range cfg.RegionCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("regionCIDRs: %w", err)
+		}
+	}
+	for _, server := range cfg.DNSServers {
+		if _, err := dnsServerAddress(server); err != nil {
+			return fmt.Errorf("dnsServers: %w", err)
+		}
+	}
+	if cfg.NodeAddresses != nil {
+		if err := cfg.NodeAddresses.validate(); err != nil {
+			return fmt.Errorf("nodeAddresses: %w", err)
+		}
+	}
+	if cfg.StatusMode != "" && !slices.Contains(validStatusModes, cfg.StatusMode) {
+		return fmt.Errorf("statusMode needs to be one of %v", validStatusModes)
+	}
+	if err := validateAnnotations(cfg.DefaultAnnotations); err != nil {
+		return fmt.Errorf("defaultAnnotations: %w", err)
+	}
+	for _, class := range []string{cfg.LoadBalancerClass, cfg.CloudIPClass} {
+		if class == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(class); len(errs) > 0 {
+			return fmt.Errorf("Invalid load balancer class %q: %s", class, strings.Join(errs, ", "))
+		}
+	}
+	if cfg.loadBalancerClass() == cfg.cloudIPClass() {
+		return fmt.Errorf("loadBalancerClass and cloudIPClass need to be different")
+	}
+	if cfg.DNSUpdates != nil {
+		if err := cfg.DNSUpdates.validate(); err != nil {
+			return fmt.Errorf("dnsUpdates: %w", err)
+		}
+	}
+	if cfg.APIClient != nil {
+		if err := cfg.APIClient.validate(); err != nil {
+			return fmt.Errorf("apiClient: %w", err)
+		}
+	}
+	if cfg.APICacheTTL != nil && cfg.APICacheTTL.Duration < 0 {
+		return fmt.Errorf("apiCacheTTL cannot be negative")
+	}
+	if cfg.BulkServerLookups && cfg.apiCacheTTL() == 0 {
+		return fmt.Errorf("bulkServerLookups needs apiCacheTTL to be above 0s")
+	}
+	if cfg.GarbageCollection != nil {
+		if err := cfg.GarbageCollection.validate(); err != nil {
+			return fmt.Errorf("garbageCollection: %w", err)
+		}
+	}
+	return nil
+}
+
+// apiCredentials are the details the controller signs in to the API
+// with
+type apiCredentials struct {
+	apiURL       string
+	clientID     string
+	clientSecret string
+	userName     string
+	password     string
+	account      string
+}
+
+// apiCredentials returns the API details in the cloud config. Variables
+// that are set in the environment always win.
+func (cfg *cloudConfig) apiCredentials() apiCredentials {
+	return apiCredentials{
+		apiURL:       getenvWithDefault(apiURLEnvVar, cfg.APIURL),
+		clientID:     getenvWithDefault(clientEnvVar, cfg.ClientID),
+		clientSecret: getenvWithDefault(clientSecretEnvVar, cfg.ClientSecret),
+		userName:     getenvWithDefault(usernameEnvVar, cfg.UserName),
+		password:     getenvWithDefault(passwordEnvVar, cfg.Password),
+		account:      getenvWithDefault(accountEnvVar, cfg.Account),
+	}
+}
+
+func getenvWithDefault(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// regionCIDRs returns the default firewall source ranges
+func (cfg *cloudConfig) regionCIDRs() []string {
+	if len(cfg.RegionCIDRs) == 0 {
+		return []string{defaultRegionCidr}
+	}
+	return cfg.RegionCIDRs
+}
+
+func (cfg *cloudConfig) clusterName() string {
+	if cfg.ClusterName == "" {
+		return defaultClusterName
+	}
+	return cfg.ClusterName
+}
+
+func (cfg *cloudConfig) loadBalancerClass() string {
+	if cfg.LoadBalancerClass == "" {
+		return defaultLoadBalancerClass
+	}
+	return cfg.LoadBalancerClass
+}
+
+func (cfg *cloudConfig) cloudIPClass() string {
+	if cfg.CloudIPClass == "" {
+		return defaultCloudIPClass
+	}
+	return cfg.CloudIPClass
+}
+
+// apiCacheTTL returns how long lookups are cached for, or 0 if they
+// aren't
+func (cfg *cloudConfig) apiCacheTTL() time.Duration {
+	if cfg.APICacheTTL == nil {
+		return defaultAPICacheTTL
+	}
+	return cfg.APICacheTTL.Duration
+}
+
+// dnsServers returns the configured DNS servers with their ports
+func (cfg *cloudConfig) dnsServers() []string {
+	result := make([]string, 0, len(cfg.DNSServers))
+	for _, server := range cfg.DNSServers {
+		if address, err := dnsServerAddress(server); err == nil {
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// withDefaultAnnotations returns the service with any missing default
+// annotations added. The original is copied rather than altered.
+func (cfg *cloudConfig) withDefaultAnnotations(apiservice *v1.Service) *v1.Service {
+	var result *v1.Service
+	for annotation, value := range cfg.DefaultAnnotations {
+		if _, ok := apiservice.Annotations[annotation]; ok {
+			continue
+		}
+		if result == nil {
+			result = apiservice.DeepCopy()
+			if result.Annotations == nil {
+				result.Annotations = make(map[string]string, len(cfg.DefaultAnnotations))
+			}
+		}
+		result.Annotations[annotation] = value
+	}
+	if result == nil {
+		return apiservice
+	}
+	return result
+}
+
+// Address families of node addresses
+const (
+	ipv4Family = "ipv4"
+	ipv6Family = "ipv6"
+)
+
+var (
+	defaultFamilies = []string{ipv4Family, ipv6Family}
+	familyNames     = map[string]string{ipv4Family: "IPv4", ipv6Family: "IPv6"}
+)
+
+// nodeAddressesConfig is the nodeAddresses section of the cloud config
+type nodeAddressesConfig struct {
+	// Families lists the address families reported, in order. Put
+	// ipv6 first for IPv6 primary clusters, or leave a family out to
+	// report none of its addresses. Defaults to [ipv4, ipv6].
+	Families []string `json:"families,omitempty"`
+
+	// CloudIPs reports the Cloud IPs mapped to a server as its
+	// ExternalIPs. Defaults to true.
+	CloudIPs *bool `json:"cloudIPs,omitempty"`
+
+	// IPv6AddressType is how a server's interface IPv6 address is
+	// reported. Brightbox IPv6 addresses are public, so it defaults to
+	// ExternalIP. Set InternalIP to use it for traffic within the
+	// cluster.
+	IPv6AddressType v1.NodeAddressType `json:"ipv6AddressType,omitempty"`
+}
+
+func (cfg *nodeAddressesConfig) validate() error {
+	for i, family := range cfg.Families {
+		if _, ok := familyNames[family]; !ok {
+			return fmt.Errorf("families needs to list %v", defaultFamilies)
+		}
+		if slices.Contains(cfg.Families[:i], family) {
+			return fmt.Errorf("families lists %q twice", family)
+		}
+	}
+	switch cfg.IPv6AddressType {
+	case "", v1.NodeExternalIP, v1.NodeInternalIP:
+	default:
+		return fmt.Errorf("ipv6AddressType needs to be %s or %s", v1.NodeExternalIP, v1.NodeInternalIP)
+	}
+	return nil
+}
+
+// families returns the address families to report, in order. Safe to
+// call on a nil config.
+func (cfg *nodeAddressesConfig) families() []string {
+	if cfg == nil || len(cfg.Families) == 0 {
+		return defaultFamilies
+	}
+	return cfg.Families
+}
+
+// ipv6AddressType returns the type of interface IPv6 addresses. Safe
+// to call on a nil config.
+func (cfg *nodeAddressesConfig) ipv6AddressType() v1.NodeAddressType {
+	if cfg == nil || cfg.IPv6AddressType == "" {
+		return v1.NodeExternalIP
+	}
+	return cfg.IPv6AddressType
+}
+
+// cloudIPs reports whether Cloud IPs are listed as ExternalIPs. Safe to
+// call on a nil config.
+func (cfg *nodeAddressesConfig) cloudIPs() bool {
+	return cfg == nil || cfg.CloudIPs == nil || *cfg.CloudIPs
+}
@@ -0,0 +1,286 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadCloudConfig(t *testing.T) {
+	testCases := map[string]struct {
+		config string
+		result *cloudConfig
+		status string
+	}{
+		"empty": {
+			config: "",
+			result: &cloudConfig{},
+		},
+		"full": {
+			config: `
+apiURL: https://api.gb1.brightbox.com
+clientID: cli-testy
+clientSecret: secret
+regionCIDRs:
+- 10.0.0.0/8
+- 2a02:1348:0140::/42
+defaultAnnotations:
+  service.beta.kubernetes.io/brightbox-load-balancer-policy: source-address
+`,
+			result: &cloudConfig{
+				APIURL:       "https://api.gb1.brightbox.com",
+				ClientID:     "cli-testy",
+				ClientSecret: "secret",
+				RegionCIDRs:  []string{"10.0.0.0/8", "2a02:1348:0140::/42"},
+				DefaultAnnotations: map[string]string{
+					serviceAnnotationLoadBalancerPolicy: "source-address",
+				},
+			},
+		},
+		"garbage collection": {
+			config: `
+garbageCollection:
+  clusterName: production
+  interval: 5m
+  dryRun: true
+`,
+			result: &cloudConfig{
+				GarbageCollection: &garbageCollectionConfig{
+					ClusterName: "production",
+					Interval:    metav1.Duration{Duration: 5 * time.Minute},
+					DryRun:      true,
+				},
+			},
+		},
+		"load balancer classes": {
+			config: `
+clusterName: production
+loadBalancerClass: example.com/brightbox
+cloudIPClass: example.com/brightbox-cloud-ip
+`,
+			result: &cloudConfig{
+				ClusterName:       "production",
+				LoadBalancerClass: "example.com/brightbox",
+				CloudIPClass:      "example.com/brightbox-cloud-ip",
+			},
+		},
+		"dns servers": {
+			config: "dnsServers: [10.0.0.53, '10.0.1.53:5353']",
+			result: &cloudConfig{
+				DNSServers: []string{"10.0.0.53", "10.0.1.53:5353"},
+			},
+		},
+		"bad dns server": {
+			config: "dnsServers: [ns1.example.com]",
+			status: "Invalid cloud config: dnsServers: Invalid DNS server \"ns1.example.com\"",
+		},
+		"dns updates": {
+			config: `
+dnsUpdates:
+  zone: example.com
+  cname: true
+  rfc2136:
+    server: 10.0.0.53
+    tsigKeyName: k8s
+    tsigSecret: c2VjcmV0
+`,
+			result: &cloudConfig{
+				DNSUpdates: &dnsUpdatesConfig{
+					Zone:  "example.com",
+					CNAME: true,
+					RFC2136: &rfc2136Config{
+						Server:      "10.0.0.53",
+						TSIGKeyName: "k8s",
+						TSIGSecret:  "c2VjcmV0",
+					},
+				},
+			},
+		},
+		"dns updates without provider": {
+			config: "dnsUpdates: {zone: example.com}",
+			status: "Invalid cloud config: dnsUpdates: a provider section is required",
+		},
+		"dns updates without zone": {
+			config: "dnsUpdates: {rfc2136: {server: 10.0.0.53}}",
+			status: "Invalid cloud config: dnsUpdates: zone is required",
+		},
+		"bad tsig secret": {
+			config: "dnsUpdates: {zone: example.com, rfc2136: {server: 10.0.0.53, tsigKeyName: k8s, tsigSecret: not-base64!}}",
+			status: "Invalid cloud config: dnsUpdates: rfc2136: tsigSecret needs to be base64 encoded",
+		},
+		"bad tsig algorithm": {
+			config: "dnsUpdates: {zone: example.com, rfc2136: {server: 10.0.0.53, tsigKeyName: k8s, tsigSecret: c2VjcmV0, tsigAlgorithm: hmac-md5}}",
+			status: "Invalid cloud config: dnsUpdates: rfc2136: tsigAlgorithm needs to be one of [hmac-sha256 hmac-sha512]",
+		},
+		"node addresses": {
+			config: "nodeAddresses: {families: [ipv6], cloudIPs: false}",
+			result: &cloudConfig{
+				NodeAddresses: &nodeAddressesConfig{
+					Families: []string{"ipv6"},
+					CloudIPs: &falsevar,
+				},
+			},
+		},
+		"bad node address family": {
+			config: "nodeAddresses: {families: [ipv5]}",
+			status: "Invalid cloud config: nodeAddresses: families needs to list [ipv4 ipv6]",
+		},
+		"bad ipv6 address type": {
+			config: "nodeAddresses: {ipv6AddressType: Hostname}",
+			status: "Invalid cloud config: nodeAddresses: ipv6AddressType needs to be ExternalIP or InternalIP",
+		},
+		"repeated node address family": {
+			config: "nodeAddresses: {families: [ipv4, ipv4]}",
+			status: "Invalid cloud config: nodeAddresses: families lists \"ipv4\" twice",
+		},
+		"bad load balancer class": {
+			config: "loadBalancerClass: brightbox load balancer",
+			status: "Invalid cloud config: Invalid load balancer class \"brightbox load balancer\"",
+		},
+		"same load balancer classes": {
+			config: "cloudIPClass: brightbox.com/load-balancer",
+			status: "Invalid cloud config: loadBalancerClass and cloudIPClass need to be different",
+		},
+		"negative grace period": {
+			config: "garbageCollection: {gracePeriod: -1h}",
+			status: "Invalid cloud config: garbageCollection: gracePeriod cannot be negative",
+		},
+		"api cache off": {
+			config: "apiCacheTTL: 0s",
+			result: &cloudConfig{APICacheTTL: &metav1.Duration{}},
+		},
+		"negative api cache ttl": {
+			config: "apiCacheTTL: -30s",
+			status: "Invalid cloud config: apiCacheTTL cannot be negative",
+		},
+		"bulk server lookups without a cache": {
+			config: "{apiCacheTTL: 0s, bulkServerLookups: true}",
+			status: "Invalid cloud config: bulkServerLookups needs apiCacheTTL to be above 0s",
+		},
+		"api client": {
+			config: "apiClient: {rateLimit: 5, retries: 0, breakerCooldown: 1m}",
+			result: &cloudConfig{APIClient: &apiClientConfig{
+				RateLimit:       5,
+				Retries:         new(int),
+				BreakerCooldown: metav1.Duration{Duration: time.Minute},
+			}},
+		},
+		"negative api retries": {
+			config: "apiClient: {retries: -1}",
+			status: "Invalid cloud config: apiClient: retries cannot be negative",
+		},
+		"unknown field": {
+			config: "clientKey: cli-testy",
+			status: "Failed to parse cloud config:",
+		},
+		"not yaml": {
+			config: "dummy",
+			status: "Failed to parse cloud config:",
+		},
+		"bad cidr": {
+			config: "regionCIDRs: [10.0.0.0/33]",
+			status: "Invalid cloud config: regionCIDRs: invalid CIDR address: 10.0.0.0/33",
+		},
+		"bad annotation": {
+			config: "defaultAnnotations: {service.beta.kubernetes.io/brightbox-load-balancer-policy: magic-routing}",
+			status: "Invalid cloud config: defaultAnnotations: Invalid Load Balancer Policy \"magic-routing\"",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := readCloudConfig(strings.NewReader(tc.config))
+			if tc.status != "" {
+				if err == nil {
+					t.Errorf("Expected error %q got nil", tc.status)
+				} else if !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("Expected %q, got %q", tc.status, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Error when not expected: %q", err.Error())
+			} else if diff := deep.Equal(result, tc.result); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestReadNilCloudConfig(t *testing.T) {
+	result, err := readCloudConfig(nil)
+	if err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	} else if diff := deep.Equal(result.regionCIDRs(), []string{defaultRegionCidr}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAPICredentials(t *testing.T) {
+	k8ssdk.ResetAuthEnvironment()
+	t.Cleanup(k8ssdk.ResetAuthEnvironment)
+	t.Setenv(clientEnvVar, "cli-envvy")
+	cfg := &cloudConfig{
+		APIURL:   "https://api.gb1.brightbox.com",
+		ClientID: "cli-testy",
+	}
+	expected := apiCredentials{
+		apiURL:   "https://api.gb1.brightbox.com",
+		clientID: "cli-envvy",
+	}
+	if got := cfg.apiCredentials(); got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+	if _, ok := os.LookupEnv(apiURLEnvVar); ok {
+		t.Errorf("Expected %s to remain unset", apiURLEnvVar)
+	}
+}
+
+func TestWithDefaultAnnotations(t *testing.T) {
+	cfg := &cloudConfig{
+		DefaultAnnotations: map[string]string{
+			serviceAnnotationLoadBalancerPolicy:           "source-address",
+			serviceAnnotationLoadBalancerListenerProtocol: "tcp",
+		},
+	}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				serviceAnnotationLoadBalancerPolicy: "round-robin",
+			},
+		},
+	}
+	result := cfg.withDefaultAnnotations(service)
+	expected := map[string]string{
+		serviceAnnotationLoadBalancerPolicy:           "round-robin",
+		serviceAnnotationLoadBalancerListenerProtocol: "tcp",
+	}
+	if diff := deep.Equal(result.Annotations, expected); diff != nil {
+		t.Error(diff)
+	}
+	if len(service.Annotations) != 1 {
+		t.Errorf("Original service annotations altered: %v", service.Annotations)
+	}
+	if result := (&cloudConfig{}).withDefaultAnnotations(service); result != service {
+		t.Errorf("Expected service to be returned unchanged")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/miekg/dns"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const defaultDNSRecordTTL = 300
+
+// dnsUpdatesConfig is the dnsUpdates section of the cloud config. It
+// has the controller write the records for the `ssl-domains` and
+// `reverse-dns` of load balancer services, rather than leaving them to
+// be made by hand.
+type dnsUpdatesConfig struct {
+	// Zone holds the records. Domains outside it are left alone.
+	Zone string `json:"zone"`
+
+	// TTL of the records written. Defaults to 300 seconds.
+	TTL uint32 `json:"ttl,omitempty"`
+
+	// CNAME points domains at the Cloud IP's hostname instead of its
+	// addresses. Only used when the load balancer has a single Cloud
+	// IP, and never for the zone apex.
+	CNAME bool `json:"cname,omitempty"`
+
+	// RFC2136 sends dynamic updates to the zone's primary DNS server
+	RFC2136 *rfc2136Config `json:"rfc2136,omitempty"`
+}
+
+func (cfg *dnsUpdatesConfig) validate() error {
+	if cfg.Zone == "" {
+		return fmt.Errorf("zone is required")
+	}
+	if _, ok := dns.IsDomainName(cfg.Zone); !ok {
+		return fmt.Errorf("Invalid zone %q", cfg.Zone)
+	}
+	if cfg.RFC2136 == nil {
+		return fmt.Errorf("a provider section is required")
+	}
+	if err := cfg.RFC2136.validate(); err != nil {
+		return fmt.Errorf("rfc2136: %w", err)
+	}
+	return nil
+}
+
+func (cfg *dnsUpdatesConfig) ttl() uint32 {
+	if cfg.TTL == 0 {
+		return defaultDNSRecordTTL
+	}
+	return cfg.TTL
+}
+
+// provider returns the DNS provider the config describes
+func (cfg *dnsUpdatesConfig) provider() (dnsProvider, error) {
+	return newRFC2136Provider(cfg.RFC2136, cfg.Zone)
+}
+
+// managedNames returns the domains that fall inside the zone
+func (cfg *dnsUpdatesConfig) managedNames(domains []string) []string {
+	zone := canonicalName(cfg.Zone)
+	var result []string
+	for _, domain := range domains {
+		name := canonicalName(domain)
+		if name == "" || slices.Contains(result, name) {
+			continue
+		}
+		if name != zone && !strings.HasSuffix(name, "."+zone) {
+			klog.V(4).Infof("Domain %q is outside DNS zone %q, leaving it alone", domain, cfg.Zone)
+			continue
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// buildRecords points each name at the Cloud IPs
+func (cfg *dnsUpdatesConfig) buildRecords(names []string, cloudIPs []*brightbox.CloudIP) []dnsRecord {
+	zone := canonicalName(cfg.Zone)
+	ttl := cfg.ttl()
+	var result []dnsRecord
+	for _, name := range names {
+		if cfg.CNAME && len(cloudIPs) == 1 && cloudIPs[0].Fqdn != "" && name != zone {
+			result = append(result, dnsRecord{name: name, rrType: dns.TypeCNAME, value: canonicalName(cloudIPs[0].Fqdn), ttl: ttl})
+			continue
+		}
+		for _, cip := range cloudIPs {
+			if ip := net.ParseIP(cip.PublicIPv4); ip != nil {
+				result = append(result, dnsRecord{name: name, rrType: dns.TypeA, value: ip.String(), ttl: ttl})
+			}
+			if ip := net.ParseIP(cip.PublicIPv6); ip != nil {
+				result = append(result, dnsRecord{name: name, rrType: dns.TypeAAAA, value: ip.String(), ttl: ttl})
+			}
+		}
+	}
+	return result
+}
+
+// registryRecord is the TXT record claiming the name for the owner
+func (cfg *dnsUpdatesConfig) registryRecord(name string, owner string) dnsRecord {
+	return dnsRecord{name: registryName(name), rrType: dns.TypeTXT, value: owner, ttl: cfg.ttl()}
+}
+
+// dnsRecord is a record pointing a load balancer domain at a Cloud IP,
+// or the registry record of a domain
+type dnsRecord struct {
+	name   string
+	rrType uint16
+	value  string
+	ttl    uint32
+}
+
+func (r dnsRecord) String() string {
+	return fmt.Sprintf("%s %d %s %s", r.name, r.ttl, dns.TypeToString[r.rrType], r.value)
+}
+
+// dnsProvider writes load balancer records to a DNS zone
+type dnsProvider interface {
+	// lookupRecords returns the A, AAAA and CNAME records of the name
+	// and the TXT records of its registry name
+	lookupRecords(ctx context.Context, name string) ([]dnsRecord, error)
+
+	// replaceRecords removes the A, AAAA and CNAME records of the
+	// names and the TXT records of their registry names, and adds the
+	// records given in their place, in one go where the provider
+	// allows.
+	replaceRecords(ctx context.Context, names []string, records []dnsRecord) error
+
+	// registryRecords returns the TXT records of every registry name
+	// in the zone
+	registryRecords(ctx context.Context) ([]dnsRecord, error)
+}
+
+// dnsRecordTypes are the record types the controller manages
+var dnsRecordTypes = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME}
+
+// Every managed name has a TXT record under this prefix naming the
+// service that owns it, as external-dns does, so names written by hand
+// or by another service are never touched. The registry can't share
+// the name itself, which may hold a CNAME.
+const dnsRegistryPrefix = "_k8s-brightbox-ccm."
+
+func registryName(name string) string {
+	return dnsRegistryPrefix + name
+}
+
+// dnsOwner is the registry value of the ledger's service
+func dnsOwner(ledger *ownershipLedger) string {
+	return fmt.Sprintf("heritage=%s,cluster=%s,service=%s", ownershipMarker, ledger.clusterName, ledger.serviceUID)
+}
+
+// dnsNameOwner returns the service UID in the registry records of a
+// name, and whether the name is free: it has no registry record and
+// no records of its own
+func dnsNameOwner(name string, records []dnsRecord) (types.UID, bool) {
+	free := true
+	for _, record := range records {
+		if record.name != registryName(name) {
+			free = false
+			continue
+		}
+		if uid, ok := registryOwner(record.value); ok {
+			return uid, false
+		}
+	}
+	return "", free
+}
+
+// registryOwner returns the service UID in a registry record written
+// by the controller
+func registryOwner(value string) (types.UID, bool) {
+	fields := strings.Split(value, ",")
+	if !slices.Contains(fields, "heritage="+ownershipMarker) {
+		return "", false
+	}
+	for _, field := range fields {
+		if uid, ok := strings.CutPrefix(field, "service="); ok {
+			return types.UID(uid), true
+		}
+	}
+	return "", false
+}
+
+// registeredDNSNames returns the names the zone's registry records say
+// the ledger's service owns
+func (c *cloud) registeredDNSNames(ctx context.Context, ledger *ownershipLedger) ([]string, error) {
+	records, err := c.dns.registryRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, record := range records {
+		name, ok := strings.CutPrefix(record.name, dnsRegistryPrefix)
+		if !ok || slices.Contains(result, name) {
+			continue
+		}
+		if uid, ok := registryOwner(record.value); ok && ledger.ownedBy(uid) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// dnsNameNotOwnedError explains why the controller won't write a name
+func dnsNameNotOwnedError(name string) error {
+	return fmt.Errorf("DNS name %q has records not owned by this service. Remove them to continue", name)
+}
+
+// sameRecords reports whether two lists hold the same records in any
+// order
+func sameRecords(a []dnsRecord, b []dnsRecord) bool {
+	return slices.Equal(sortedRecords(a), sortedRecords(b))
+}
+
+func sortedRecords(records []dnsRecord) []string {
+	result := make([]string, 0, len(records))
+	for _, record := range records {
+		result = append(result, record.String())
+	}
+	slices.Sort(result)
+	return result
+}
+
+// recordsFor returns the records of the name and its registry name
+func recordsFor(name string, records []dnsRecord) []dnsRecord {
+	var result []dnsRecord
+	for _, record := range records {
+		if record.name == name || record.name == registryName(name) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// ensureDNSRecords points the service's domains and reverse DNS name in
+// the managed zone at its Cloud IPs, and removes the records of any name
+// it no longer lists. Names that hold records the service does not own
+// are an error. Names whose records are already right are left alone.
+// The names the service owns are found from the zone's registry
+// records.
+func (c *cloud) ensureDNSRecords(ctx context.Context, owned *ownedResources, apiservice *v1.Service, cloudIPs []*brightbox.CloudIP) error {
+	if c.dns == nil {
+		return nil
+	}
+	cfg := c.config.DNSUpdates
+	domains := append(extraLoadBalancerDomains(apiservice.Annotations), apiservice.Annotations[serviceAnnotationLoadBalancerReverseDNS])
+	names := cfg.managedNames(domains)
+	klog.V(4).Infof("ensureDNSRecords (%q, %v)", owned.group.Name, names)
+	registered, err := c.registeredDNSNames(ctx, owned.ledger)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, name := range registered {
+		if !slices.Contains(names, name) {
+			stale = append(stale, name)
+		}
+	}
+	if err := c.removeOwnedDNSRecords(ctx, owned.ledger, stale); err != nil {
+		return err
+	}
+	owner := dnsOwner(owned.ledger)
+	records := cfg.buildRecords(names, cloudIPs)
+	for _, name := range names {
+		records = append(records, cfg.registryRecord(name, owner))
+	}
+	var changed []string
+	for _, name := range names {
+		current, err := c.dns.lookupRecords(ctx, name)
+		if err != nil {
+			return err
+		}
+		if uid, free := dnsNameOwner(name, current); !free && !owned.ledger.ownedBy(uid) {
+			return dnsNameNotOwnedError(name)
+		}
+		if !sameRecords(current, recordsFor(name, records)) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	var changes []dnsRecord
+	for _, name := range changed {
+		changes = append(changes, recordsFor(name, records)...)
+	}
+	if err := c.dns.replaceRecords(ctx, changed, changes); err != nil {
+		return err
+	}
+	c.recordEvent(apiservice, eventUpdatedDNSRecords, "Updated DNS records for %s", strings.Join(changed, ", "))
+	return nil
+}
+
+// removeOwnedDNSRecords removes the records of the names the ledger's
+// service owns. Names that have been claimed by anything else are left
+// alone.
+func (c *cloud) removeOwnedDNSRecords(ctx context.Context, ledger *ownershipLedger, names []string) error {
+	var owned []string
+	for _, name := range names {
+		current, err := c.dns.lookupRecords(ctx, name)
+		if err != nil {
+			return err
+		}
+		uid, free := dnsNameOwner(name, current)
+		switch {
+		case free:
+		case ledger.ownedBy(uid):
+			owned = append(owned, name)
+		default:
+			klog.Warningf("DNS name %q is not owned by service %s, leaving its records in place", name, ledger.serviceUID)
+		}
+	}
+	if len(owned) == 0 {
+		return nil
+	}
+	return c.dns.replaceRecords(ctx, owned, nil)
+}
+
+// ensureDNSRecordsRemoved removes the records of every name the
+// service owns
+func (c *cloud) ensureDNSRecordsRemoved(ctx context.Context, owned *ownedResources) error {
+	if c.dns == nil {
+		return nil
+	}
+	names, err := c.registeredDNSNames(ctx, owned.ledger)
+	if err != nil {
+		return err
+	}
+	klog.V(4).Infof("ensureDNSRecordsRemoved (%q, %v)", owned.group.Name, names)
+	return c.removeOwnedDNSRecords(ctx, owned.ledger, names)
+}
+
+// canonicalName lower cases a domain and drops any trailing dot
+func canonicalName(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// fullyQualified adds the trailing dot DNS messages expect
+func fullyQualified(domain string) string {
+	return canonicalName(domain) + "."
+}
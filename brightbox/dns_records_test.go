@@ -0,0 +1,261 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	"github.com/miekg/dns"
+)
+
+// fakeDNSProvider holds a zone's records and records the updates it is
+// asked to make
+type fakeDNSProvider struct {
+	records []dnsRecord
+	calls   []string
+}
+
+func (f *fakeDNSProvider) lookupRecords(_ context.Context, name string) ([]dnsRecord, error) {
+	return recordsFor(name, f.records), nil
+}
+
+func (f *fakeDNSProvider) replaceRecords(_ context.Context, names []string, records []dnsRecord) error {
+	f.calls = append(f.calls, fmt.Sprintf("replace %v %s", names, describeRecords(records)))
+	f.records = slices.DeleteFunc(f.records, func(record dnsRecord) bool {
+		return slices.ContainsFunc(names, func(name string) bool {
+			return record.name == name || record.name == registryName(name)
+		})
+	})
+	f.records = append(f.records, records...)
+	return nil
+}
+
+func (f *fakeDNSProvider) registryRecords(_ context.Context) ([]dnsRecord, error) {
+	var result []dnsRecord
+	for _, record := range f.records {
+		if strings.HasPrefix(record.name, dnsRegistryPrefix) {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+func describeRecords(records []dnsRecord) string {
+	result := make([]string, 0, len(records))
+	for _, record := range records {
+		result = append(result, fmt.Sprintf("%s %s %s", record.name, dns.TypeToString[record.rrType], record.value))
+	}
+	return "[" + strings.Join(result, ", ") + "]"
+}
+
+func TestManagedNames(t *testing.T) {
+	cfg := &dnsUpdatesConfig{Zone: "Example.com."}
+	names := cfg.managedNames([]string{"www.example.com", "other.org", "EXAMPLE.COM.", "www.example.com.", "badexample.com", ""})
+	if diff := deep.Equal(names, []string{"www.example.com", "example.com"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildDNSRecords(t *testing.T) {
+	second := &brightbox.CloudIP{ID: "cip-manul", PublicIPv4: publicIP2, Fqdn: fqdn2}
+	testCases := map[string]struct {
+		cname    bool
+		cloudIPs []*brightbox.CloudIP
+		expected string
+	}{
+		"addresses": {
+			cloudIPs: []*brightbox.CloudIP{&resolvCip},
+			expected: "[www.example.com A 109.107.39.92, www.example.com AAAA 2a02:1348:ffff:ffff::6d6b:275c, example.com A 109.107.39.92, example.com AAAA 2a02:1348:ffff:ffff::6d6b:275c]",
+		},
+		"cname": {
+			cname:    true,
+			cloudIPs: []*brightbox.CloudIP{&resolvCip},
+			expected: "[www.example.com CNAME cip-vsalc.gb1s.brightbox.com, example.com A 109.107.39.92, example.com AAAA 2a02:1348:ffff:ffff::6d6b:275c]",
+		},
+		"cname with two cloud ips": {
+			cname:    true,
+			cloudIPs: []*brightbox.CloudIP{second, &resolvCip},
+			expected: "[www.example.com A 190.190.190.190, www.example.com A 109.107.39.92, www.example.com AAAA 2a02:1348:ffff:ffff::6d6b:275c, example.com A 190.190.190.190, example.com A 109.107.39.92, example.com AAAA 2a02:1348:ffff:ffff::6d6b:275c]",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &dnsUpdatesConfig{Zone: "example.com", CNAME: tc.cname}
+			records := cfg.buildRecords([]string{"www.example.com", "example.com"}, tc.cloudIPs)
+			if diff := deep.Equal(describeRecords(records), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestEnsureDNSRecords(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	provider := &fakeDNSProvider{}
+	client := &cloud{
+		Cloud:  k8ssdk.MakeTestClient(fake, nil),
+		config: cloudConfig{DNSUpdates: &dnsUpdatesConfig{Zone: "example.com", CNAME: true}},
+		dns:    provider,
+	}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := testLedgerService("web", "uid-web")
+	service.Annotations = map[string]string{serviceAnnotationLoadBalancerSslDomains: "www.example.com,api.example.com,other.org"}
+	cloudIPs := []*brightbox.CloudIP{&resolvCip}
+	if err := client.ensureDNSRecords(context.TODO(), owned, service, cloudIPs); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	// The names are kept in the zone, not the ledger
+	if len(fake.calls) != 0 {
+		t.Errorf("expected the ledger to be left alone, got %v", fake.calls)
+	}
+
+	// Nothing to change
+	if err := client.ensureDNSRecords(context.TODO(), owned, service, cloudIPs); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+
+	// A domain is dropped from the annotation
+	service.Annotations[serviceAnnotationLoadBalancerSslDomains] = "www.example.com"
+	if err := client.ensureDNSRecords(context.TODO(), owned, service, cloudIPs); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+
+	// The service is deleted
+	if err := client.ensureDNSRecordsRemoved(context.TODO(), owned); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	registry := func(name string) string {
+		return "_k8s-brightbox-ccm." + name + " TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web"
+	}
+	expected := []string{
+		"replace [www.example.com api.example.com] [www.example.com CNAME cip-vsalc.gb1s.brightbox.com, " + registry("www.example.com") + ", api.example.com CNAME cip-vsalc.gb1s.brightbox.com, " + registry("api.example.com") + "]",
+		"replace [api.example.com] []",
+		"replace [www.example.com] []",
+	}
+	if diff := deep.Equal(provider.calls, expected); diff != nil {
+		t.Error(diff)
+	}
+	if len(provider.records) != 0 {
+		t.Errorf("expected the zone to be empty, got %v", provider.records)
+	}
+}
+
+func TestEnsureDNSRecordsOwnership(t *testing.T) {
+	otherOwner := dnsRecord{name: "_k8s-brightbox-ccm.www.example.com", rrType: dns.TypeTXT, value: "heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-other", ttl: 300}
+	handMade := dnsRecord{name: "www.example.com", rrType: dns.TypeA, value: "192.0.2.1", ttl: 3600}
+	testCases := map[string]struct {
+		records []dnsRecord
+		domains string
+		calls   []string
+		status  string
+	}{
+		"records written by hand": {
+			records: []dnsRecord{handMade},
+			domains: "www.example.com",
+			status:  `DNS name "www.example.com" has records not owned by this service`,
+		},
+		"claimed by another service": {
+			records: []dnsRecord{otherOwner},
+			domains: "www.example.com",
+			status:  `DNS name "www.example.com" has records not owned by this service`,
+		},
+		"stale name": {
+			records: []dnsRecord{
+				{name: "www.example.com", rrType: dns.TypeCNAME, value: "cip-vsalc.gb1s.brightbox.com", ttl: 300},
+				{name: "_k8s-brightbox-ccm.www.example.com", rrType: dns.TypeTXT, value: "heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web", ttl: 300},
+			},
+			domains: "api.example.com",
+			calls: []string{
+				"replace [www.example.com] []",
+				"replace [api.example.com] [api.example.com CNAME cip-vsalc.gb1s.brightbox.com, _k8s-brightbox-ccm.api.example.com TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web]",
+			},
+		},
+		"name claimed by another service": {
+			records: []dnsRecord{otherOwner, handMade},
+			domains: "api.example.com",
+			calls: []string{
+				"replace [api.example.com] [api.example.com CNAME cip-vsalc.gb1s.brightbox.com, _k8s-brightbox-ccm.api.example.com TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web]",
+			},
+		},
+		"ttl changed": {
+			records: []dnsRecord{
+				{name: "api.example.com", rrType: dns.TypeCNAME, value: "cip-vsalc.gb1s.brightbox.com", ttl: 60},
+				{name: "_k8s-brightbox-ccm.api.example.com", rrType: dns.TypeTXT, value: "heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web", ttl: 60},
+			},
+			domains: "api.example.com",
+			calls: []string{
+				"replace [api.example.com] [api.example.com CNAME cip-vsalc.gb1s.brightbox.com, _k8s-brightbox-ccm.api.example.com TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web]",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeLedgerCloud()
+			provider := &fakeDNSProvider{records: tc.records}
+			client := &cloud{
+				Cloud:  k8ssdk.MakeTestClient(fake, nil),
+				config: cloudConfig{DNSUpdates: &dnsUpdatesConfig{Zone: "example.com", CNAME: true}},
+				dns:    provider,
+			}
+			owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			service := testLedgerService("web", "uid-web")
+			service.Annotations = map[string]string{serviceAnnotationLoadBalancerSslDomains: tc.domains}
+			err = client.ensureDNSRecords(context.TODO(), owned, service, []*brightbox.CloudIP{&resolvCip})
+			if tc.status != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("expected %q, got %v", tc.status, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			if diff := deep.Equal(provider.calls, tc.calls); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestEnsureDNSRecordsWithoutProvider(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := testLedgerService("web", "uid-web")
+	service.Annotations = map[string]string{serviceAnnotationLoadBalancerSslDomains: "www.example.com"}
+	if err := client.ensureDNSRecords(context.TODO(), owned, service, []*brightbox.CloudIP{&resolvCip}); err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	}
+	if err := client.ensureDNSRecordsRemoved(context.TODO(), owned); err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("expected no changes, got %v", fake.calls)
+	}
+}
@@ -15,28 +15,106 @@
 package brightbox
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
+	v1 "k8s.io/api/core/v1"
 )
 
-func ensureLoadBalancerDomainResolution(annotationList map[string]string, cloudIP *brightbox.CloudIP) ([]string, error) {
-	domains := append(extraLoadBalancerDomains(annotationList), cloudIP.Fqdn, cloudIP.ReverseDNS)
+const (
+	defaultDNSPort = "53"
+	dnsDialTimeout = 5 * time.Second
+)
+
+// domainResolver looks up the addresses of a domain. *net.Resolver
+// meets it, and tests can substitute their own.
+type domainResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// newDomainResolver returns the system resolver, or one that queries
+// the given DNS servers directly, taking each in turn
+func newDomainResolver(servers []string) domainResolver {
+	if len(servers) == 0 {
+		return net.DefaultResolver
+	}
+	var next atomic.Uint32
+	dialer := &net.Dialer{Timeout: dnsDialTimeout}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(next.Add(1)-1)%len(servers)]
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// dnsServerAddress adds the default port to a DNS server address if it
+// doesn't have one
+func dnsServerAddress(server string) (string, error) {
+	if net.ParseIP(server) != nil {
+		return net.JoinHostPort(server, defaultDNSPort), nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", fmt.Errorf("Invalid DNS server %q: %w", server, err)
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("Invalid DNS server %q: needs to be an IP address", server)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("Invalid DNS server %q: bad port", server)
+	}
+	return server, nil
+}
+
+// domainResolver returns the resolver used to check the service's
+// domains, or nil if the service asks for them to be trusted
+func (c *cloud) domainResolver(apiservice *v1.Service) domainResolver {
+	if skip, _ := strconv.ParseBool(apiservice.Annotations[serviceAnnotationLoadBalancerSkipDomainResolution]); skip {
+		return nil
+	}
+	if c.resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.resolver
+}
+
+// ensureLoadBalancerDomainResolution returns the domains to put on the
+// load balancer, checking each resolves to one of the Cloud IPs. A nil
+// resolver trusts the domains without looking them up.
+func ensureLoadBalancerDomainResolution(ctx context.Context, resolver domainResolver, annotationList map[string]string, cloudIPs ...*brightbox.CloudIP) ([]string, error) {
+	domains := extraLoadBalancerDomains(annotationList)
+	var cloudIPList []net.IP
+	var addresses []string
+	for _, cloudIP := range cloudIPs {
+		domains = append(domains, cloudIP.Fqdn, cloudIP.ReverseDNS)
+		ipList, err := toIPList(cloudIP)
+		if err != nil {
+			return nil, err
+		}
+		cloudIPList = append(cloudIPList, ipList...)
+		addresses = append(addresses, cloudIP.PublicIPv4, cloudIP.PublicIPv6)
+	}
 	slices.Sort(domains)
 	domains = slices.Compact(domains)
-	cloudIPList, err := toIPList(cloudIP)
-	if err != nil {
-		return nil, err
+	if resolver == nil {
+		return domains, nil
 	}
 	for _, domain := range domains {
-		resolvedAddresses, err := net.LookupIP(domain)
+		resolvedAddresses, err := resolver.LookupIP(ctx, "ip", domain)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to resolve %q to load balancer address (%s,%s): %v", domain, cloudIP.PublicIPv4, cloudIP.PublicIPv6, err.Error())
+			return nil, fmt.Errorf("Failed to resolve %q to load balancer address (%s): %v", domain, strings.Join(addresses, ","), err.Error())
 		}
 		if !anyAddressMatch(cloudIPList, resolvedAddresses) {
-			return nil, fmt.Errorf("Failed to resolve %q to load balancer address (%s,%s)", domain, cloudIP.PublicIPv4, cloudIP.PublicIPv6)
+			return nil, fmt.Errorf("Failed to resolve %q to load balancer address (%s)", domain, strings.Join(addresses, ","))
 		}
 	}
 	return domains, nil
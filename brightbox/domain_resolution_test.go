@@ -0,0 +1,132 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeResolver answers from a fixed table, and reports any other
+// domain as not found
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupIP(_ context.Context, _, host string) ([]net.IP, error) {
+	addresses, ok := f[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	result := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		result = append(result, net.ParseIP(address))
+	}
+	return result, nil
+}
+
+var testResolver = fakeResolver{
+	resolvedDomain:       {resolvCip.PublicIPv4, resolvCip.PublicIPv6},
+	fqdn:                 {resolvCip.PublicIPv4},
+	"archive.ubuntu.com": {"185.125.190.36"},
+}
+
+func TestDomainResolver(t *testing.T) {
+	client := &cloud{resolver: testResolver}
+	service := &v1.Service{}
+	if diff := deep.Equal(client.domainResolver(service), domainResolver(testResolver)); diff != nil {
+		t.Error(diff)
+	}
+	service.Annotations = map[string]string{serviceAnnotationLoadBalancerSkipDomainResolution: "true"}
+	if resolver := client.domainResolver(service); resolver != nil {
+		t.Errorf("expected no resolver when skipped, got %v", resolver)
+	}
+	if resolver := (&cloud{}).domainResolver(&v1.Service{}); resolver != net.DefaultResolver {
+		t.Errorf("expected the system resolver, got %v", resolver)
+	}
+}
+
+func TestSkipDomainResolution(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				serviceAnnotationLoadBalancerSslDomains:           missingDomain,
+				serviceAnnotationLoadBalancerSkipDomainResolution: "true",
+			},
+		},
+	}
+	client := &cloud{resolver: testResolver}
+	domains, err := ensureLoadBalancerDomainResolution(context.TODO(), client.domainResolver(service), service.Annotations, &resolvCip)
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	if diff := deep.Equal(domains, []string{fqdn, resolvedDomain, missingDomain}); diff != nil {
+		t.Error(diff)
+	}
+	if _, err := ensureLoadBalancerDomainResolution(context.TODO(), nil, nil, &brightbox.CloudIP{}); err == nil {
+		t.Error("expected unparseable Cloud IP addresses to fail when skipped")
+	}
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	testCases := map[string]struct {
+		server   string
+		expected string
+		status   string
+	}{
+		"ipv4": {
+			server:   "10.0.0.53",
+			expected: "10.0.0.53:53",
+		},
+		"ipv6": {
+			server:   "2a02:1348::53",
+			expected: "[2a02:1348::53]:53",
+		},
+		"port": {
+			server:   "10.0.0.53:5353",
+			expected: "10.0.0.53:5353",
+		},
+		"hostname": {
+			server: "ns1.example.com",
+			status: `Invalid DNS server "ns1.example.com": address ns1.example.com: missing port in address`,
+		},
+		"hostname with port": {
+			server: "ns1.example.com:53",
+			status: `Invalid DNS server "ns1.example.com:53": needs to be an IP address`,
+		},
+		"bad port": {
+			server: "10.0.0.53:dns",
+			status: `Invalid DNS server "10.0.0.53:dns": bad port`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			address, err := dnsServerAddress(tc.server)
+			if tc.status == "" {
+				if err != nil {
+					t.Errorf("Error when not expected: %q", err.Error())
+				} else if diff := deep.Equal(address, tc.expected); diff != nil {
+					t.Error(diff)
+				}
+			} else if err == nil || err.Error() != tc.status {
+				t.Errorf("expected %q, got %v", tc.status, err)
+			}
+		})
+	}
+}
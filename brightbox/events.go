@@ -0,0 +1,82 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons for the events recorded on services. Failures are recorded
+// as Warnings, everything else as Normal events.
+const (
+	eventOwnershipConflict          = "OwnershipConflict"
+	eventAllocatedCloudIP           = "AllocatedCloudIP"
+	eventCloudIPAllocationFailed    = "CloudIPAllocationFailed"
+	eventReclaimedCloudIP           = "ReclaimedCloudIP"
+	eventMappedCloudIP              = "MappedCloudIP"
+	eventSetReverseDNS              = "SetReverseDNS"
+	eventReverseDNSFailed           = "ReverseDNSFailed"
+	eventCloudIPMappingFailed       = "CloudIPMappingFailed"
+	eventUpdatedDNSRecords          = "UpdatedDNSRecords"
+	eventDNSUpdateFailed            = "DNSUpdateFailed"
+	eventDomainResolutionFailed     = "DomainResolutionFailed"
+	eventCertificateSecretInvalid   = "CertificateSecretInvalid"
+	eventCreatedLoadBalancer        = "CreatedLoadBalancer"
+	eventUpdatedLoadBalancer        = "UpdatedLoadBalancer"
+	eventLoadBalancerUpdateFailed   = "LoadBalancerUpdateFailed"
+	eventFirewallRuleCreated        = "FirewallRuleCreated"
+	eventFirewallRuleUpdated        = "FirewallRuleUpdated"
+	eventFirewallRuleDeleted        = "FirewallRuleDeleted"
+	eventFirewallUpdateFailed       = "FirewallUpdateFailed"
+	eventWaitingForACME             = "WaitingForACME"
+	eventDeletedLoadBalancer        = "DeletedLoadBalancer"
+	eventLoadBalancerDeletionFailed = "LoadBalancerDeletionFailed"
+)
+
+// startEventRecorder sends events recorded on services to the API
+// server until stop is closed
+func (c *cloud) startEventRecorder(client kubernetes.Interface, stop <-chan struct{}) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: clientName})
+	go func() {
+		<-stop
+		broadcaster.Shutdown()
+	}()
+}
+
+// recordEvent records a Normal event on the service. Does nothing if
+// there is no recorder, as in tests.
+func (c *cloud) recordEvent(apiservice *v1.Service, reason string, messageFmt string, args ...interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(apiservice, v1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// recordWarning records a failed step as a Warning event on the
+// service and returns the error unchanged
+func (c *cloud) recordWarning(apiservice *v1.Service, reason string, err error) error {
+	if c.recorder == nil || err == nil {
+		return err
+	}
+	c.recorder.Event(apiservice, v1.EventTypeWarning, reason, err.Error())
+	return err
+}
@@ -0,0 +1,238 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultGarbageCollectionInterval    = 10 * time.Minute
+	defaultGarbageCollectionGracePeriod = time.Hour
+)
+
+// garbageCollectionConfig is the garbageCollection section of the
+// cloud config
+type garbageCollectionConfig struct {
+	// ClusterName has to match the controller manager's
+	// --cluster-name, which is the suffix of every load balancer name
+	// built by this controller. Defaults to the top level clusterName.
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Interval between sweeps. Defaults to 10 minutes.
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// GracePeriod is how long resources have to stay orphaned before
+	// they are removed. Defaults to an hour.
+	GracePeriod metav1.Duration `json:"gracePeriod,omitempty"`
+
+	// DryRun logs the orphans that would be removed without removing
+	// them.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+func (cfg *garbageCollectionConfig) validate() error {
+	if cfg.Interval.Duration < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	if cfg.GracePeriod.Duration < 0 {
+		return fmt.Errorf("gracePeriod cannot be negative")
+	}
+	return nil
+}
+
+func (cfg *garbageCollectionConfig) clusterName() string {
+	if cfg.ClusterName == "" {
+		return defaultClusterName
+	}
+	return cfg.ClusterName
+}
+
+func (cfg *garbageCollectionConfig) interval() time.Duration {
+	if cfg.Interval.Duration == 0 {
+		return defaultGarbageCollectionInterval
+	}
+	return cfg.Interval.Duration
+}
+
+func (cfg *garbageCollectionConfig) gracePeriod() time.Duration {
+	if cfg.GracePeriod.Duration == 0 {
+		return defaultGarbageCollectionGracePeriod
+	}
+	return cfg.GracePeriod.Duration
+}
+
+// garbageCollector removes the Brightbox resources built for services
+// that have gone without EnsureLoadBalancerDeleted being called: the
+// service was force deleted, or disappeared while the controller was
+// down. Orphans are found from the ledgers on the server groups, so only
+// resources the controller owns are touched, and each is only collected
+// once it has been orphaned for the whole grace period.
+type garbageCollector struct {
+	cloud    *cloud
+	config   garbageCollectionConfig
+	services corelisters.ServiceLister
+	// When each orphaned server group was first seen
+	orphans map[string]time.Time
+	now     func() time.Time
+}
+
+func newGarbageCollector(c *cloud, cfg garbageCollectionConfig, services corelisters.ServiceLister) *garbageCollector {
+	return &garbageCollector{
+		cloud:    c,
+		config:   cfg,
+		services: services,
+		orphans:  map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// startGarbageCollector runs the sweeper until stop is closed, once the
+// service cache has synced. Nothing runs unless the cloud config has a
+// garbageCollection section.
+func (c *cloud) startGarbageCollector(stop <-chan struct{}) {
+	if c.config.GarbageCollection == nil {
+		return
+	}
+	klog.V(4).Info("startGarbageCollector called")
+	informer := c.informerFactory.Core().V1().Services()
+	cfg := *c.config.GarbageCollection
+	if cfg.ClusterName == "" {
+		cfg.ClusterName = c.config.clusterName()
+	}
+	gc := newGarbageCollector(c, cfg, informer.Lister())
+	synced := informer.Informer().HasSynced
+	go func() {
+		if !cache.WaitForCacheSync(stop, synced) {
+			return
+		}
+		ctx := wait.ContextForChannel(stop)
+		wait.Until(func() { gc.sweep(ctx) }, gc.config.interval(), stop)
+	}()
+}
+
+// liveServices holds the names the services that should have a load
+// balancer may be using, by service UID
+type liveServices struct {
+	names map[types.UID]sets.Set[string]
+}
+
+// sweep finds the orphaned server groups and collects any that have
+// passed the grace period
+func (gc *garbageCollector) sweep(ctx context.Context) {
+	klog.V(4).Info("garbage collection sweep")
+	live, err := gc.liveServices(ctx)
+	if err != nil {
+		klog.Errorf("Garbage collection skipped, failed to list services: %v", err)
+		return
+	}
+	groups, err := gc.cloud.GetServerGroups(ctx)
+	if err != nil {
+		klog.Errorf("Garbage collection skipped, failed to list server groups: %v", err)
+		return
+	}
+	orphaned := make(map[string]*brightbox.ServerGroup)
+	for i := range groups {
+		if gc.isOrphaned(&groups[i], live) {
+			orphaned[groups[i].ID] = &groups[i]
+		}
+	}
+	for id := range gc.orphans {
+		if _, ok := orphaned[id]; !ok {
+			klog.V(4).Infof("%q is no longer orphaned", id)
+			delete(gc.orphans, id)
+		}
+	}
+	now := gc.now()
+	for _, id := range slices.Sorted(maps.Keys(orphaned)) {
+		group := orphaned[id]
+		since, ok := gc.orphans[id]
+		if !ok {
+			klog.Infof("Found orphaned server group %s for load balancer %q", id, group.Name)
+			gc.orphans[id] = now
+			continue
+		}
+		if now.Sub(since) < gc.config.gracePeriod() {
+			continue
+		}
+		if gc.config.DryRun {
+			klog.Infof("Dry run: would remove orphaned resources for load balancer %q", group.Name)
+			continue
+		}
+		if err := gc.collect(ctx, group); err != nil {
+			klog.Errorf("Failed to remove orphaned resources for load balancer %q: %v", group.Name, err)
+			continue
+		}
+		klog.Infof("Removed orphaned resources for load balancer %q", group.Name)
+		delete(gc.orphans, id)
+		orphansCollected.Inc()
+	}
+	orphanedLoadBalancers.Set(float64(len(gc.orphans)))
+}
+
+// liveServices returns the services that should have a load balancer
+func (gc *garbageCollector) liveServices(ctx context.Context) (*liveServices, error) {
+	services, err := gc.services.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := &liveServices{names: map[types.UID]sets.Set[string]{}}
+	for _, service := range services {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		// Services that have not had their name recorded yet may still
+		// be using a long name from an earlier release
+		names := sets.New(gc.cloud.GetLoadBalancerName(ctx, gc.config.clusterName(), service))
+		if service.Annotations[serviceAnnotationLoadBalancerName] == "" {
+			names.Insert(legacyLoadBalancerName(gc.config.clusterName(), service))
+		}
+		result.names[service.UID] = names
+	}
+	return result, nil
+}
+
+// isOrphaned reports whether the server group belongs to a service in
+// this cluster that no longer exists, or that has moved to another name.
+// Groups without a ledger are never orphans, as nothing shows they were
+// built by the controller.
+func (gc *garbageCollector) isOrphaned(group *brightbox.ServerGroup, live *liveServices) bool {
+	ledger, ok := parseOwnershipLedger(group.Description)
+	return ok && ledger.clusterName == gc.config.clusterName() && !live.names[ledger.serviceUID].Has(group.Name)
+}
+
+// collect removes everything in the orphaned server group's ledger
+func (gc *garbageCollector) collect(ctx context.Context, group *brightbox.ServerGroup) error {
+	ledger, ok := parseOwnershipLedger(group.Description)
+	if !ok {
+		return fmt.Errorf("server group %s has no ownership ledger", group.ID)
+	}
+	return gc.cloud.deleteLoadBalancerResources(ctx, &ownedResources{group: group, ledger: ledger})
+}
@@ -0,0 +1,162 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testServiceLister(t *testing.T, services ...*v1.Service) corelisters.ServiceLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, service := range services {
+		if err := indexer.Add(service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return corelisters.NewServiceLister(indexer)
+}
+
+func TestGarbageCollectorSweep(t *testing.T) {
+	registerMetrics()
+	services := testServiceLister(t,
+		testLedgerService("web", "uid-web"),
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "other", UID: "uid-api"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeClusterIP},
+		},
+	)
+	collected := func(names ...string) []string {
+		var result []string
+		for _, name := range names {
+			result = append(result,
+				"destroy lba-"+name,
+				"destroy cip-"+name,
+				"update grp-"+name,
+				"destroy fwp-"+name,
+				"destroy grp-"+name,
+			)
+		}
+		return result
+	}
+	testCases := map[string]struct {
+		services corelisters.ServiceLister
+		sweeps   []time.Duration
+		dryRun   bool
+		expected []string
+	}{
+		"first sighting": {
+			services: services,
+			sweeps:   []time.Duration{0},
+		},
+		"within grace period": {
+			services: services,
+			sweeps:   []time.Duration{0, 30 * time.Minute},
+		},
+		"after grace period": {
+			services: services,
+			sweeps:   []time.Duration{0, 30 * time.Minute, 61 * time.Minute},
+			expected: collected("api", "old"),
+		},
+		"dry run": {
+			services: services,
+			sweeps:   []time.Duration{0, 2 * time.Hour},
+			dryRun:   true,
+		},
+		"renamed load balancer": {
+			services: testServiceLister(t,
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "web",
+						Namespace:   "default",
+						UID:         "uid-web",
+						Annotations: map[string]string{serviceAnnotationLoadBalancerName: "web.default.renamed"},
+					},
+					Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+				},
+				testLedgerService("old", "uid-old"),
+				testLedgerService("legacy", "uid-legacy"),
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "other", UID: "uid-api"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+				},
+			),
+			sweeps:   []time.Duration{0, 2 * time.Hour},
+			expected: collected("web"),
+		},
+		"recreated service": {
+			services: testServiceLister(t,
+				testLedgerService("web", "uid-new-web"),
+				testLedgerService("old", "uid-old"),
+				testLedgerService("legacy", "uid-legacy"),
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "other", UID: "uid-api"},
+					Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+				},
+			),
+			sweeps:   []time.Duration{0, 2 * time.Hour},
+			expected: collected("web"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeLedgerCloud()
+			client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+			gc := newGarbageCollector(client, garbageCollectionConfig{DryRun: tc.dryRun}, tc.services)
+			start := time.Now()
+			for _, offset := range tc.sweeps {
+				gc.now = func() time.Time { return start.Add(offset) }
+				gc.sweep(context.TODO())
+			}
+			if diff := deep.Equal(fake.calls, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestGarbageCollectorForgetsAdopted(t *testing.T) {
+	registerMetrics()
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	gc := newGarbageCollector(client, garbageCollectionConfig{}, testServiceLister(t))
+	start := time.Now()
+	gc.now = func() time.Time { return start }
+	gc.sweep(context.TODO())
+	// The service comes back before the grace period is up
+	gc.services = testServiceLister(t, testLedgerService("old", "uid-old"))
+	gc.now = func() time.Time { return start.Add(30 * time.Minute) }
+	gc.sweep(context.TODO())
+	if _, ok := gc.orphans["grp-old"]; ok {
+		t.Errorf("expected the service's resources to no longer be orphaned")
+	}
+	if _, ok := gc.orphans["grp-web"]; !ok {
+		t.Errorf("expected the deleted service's resources to stay orphaned")
+	}
+	for _, id := range []string{"grp-manual", "grp-prod"} {
+		if _, ok := gc.orphans[id]; ok {
+			t.Errorf("expected %s to be ignored", id)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net"
 
+	brightbox "github.com/brightbox/gobrightbox/v2"
 	"github.com/brightbox/gobrightbox/v2/enums/serverstatus"
 	"github.com/brightbox/k8ssdk/v2"
 
@@ -40,7 +41,7 @@ func (c *cloud) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.No
 	if err != nil {
 		return nil, err
 	}
-	return nodeAddressesFromServer(srv)
+	return nodeAddressesFromServer(srv, c.config.NodeAddresses)
 }
 
 // NodeAddressesByProviderID returns the addresses of the specified instance.
@@ -123,7 +124,7 @@ func (c *cloud) InstanceExistsByProviderID(ctx context.Context, providerID strin
 	if err := logAction(ctx, "InstanceExistsByProviderID (%q)", providerID); err != nil {
 		return false, err
 	}
-	srv, err := c.GetServer(ctx, k8ssdk.MapProviderIDToServerID(providerID), cloudprovider.InstanceNotFound)
+	srv, err := c.lifecycleServer(ctx, k8ssdk.MapProviderIDToServerID(providerID))
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
 			return false, nil
@@ -152,7 +153,7 @@ func (c *cloud) InstanceShutdownByProviderID(ctx context.Context, providerID str
 	if err := logAction(ctx, "InstanceShutdownByProviderID (%q)", providerID); err != nil {
 		return false, err
 	}
-	srv, err := c.GetServer(ctx, k8ssdk.MapProviderIDToServerID(providerID), cloudprovider.InstanceNotFound)
+	srv, err := c.lifecycleServer(ctx, k8ssdk.MapProviderIDToServerID(providerID))
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
 			return false, nil
@@ -184,3 +185,25 @@ func parseIPString(ipString string, ipType string, objectID string,
 	}
 	return &v1.NodeAddress{Type: nodeType, Address: ip.String()}, nil
 }
+
+// lifecycleServer finds the server for a node lifecycle check. With bulk
+// server lookups on, it comes from the list of every server in the
+// account, which is shared by all the nodes until the cache expires.
+// Servers missing from the list, or any server if listing fails, are
+// looked up on their own.
+func (c *cloud) lifecycleServer(ctx context.Context, id string) (*brightbox.Server, error) {
+	if c.config.BulkServerLookups {
+		servers, err := c.listServers(ctx)
+		if err != nil {
+			klog.Warningf("Failed to list servers, looking up %q on its own: %v", id, err)
+			return c.GetServer(ctx, id, cloudprovider.InstanceNotFound)
+		}
+		for i := range servers {
+			if servers[i].ID == id {
+				return &servers[i], nil
+			}
+		}
+		klog.V(4).Infof("Server %q missing from the server list, looking it up", id)
+	}
+	return c.GetServer(ctx, id, cloudprovider.InstanceNotFound)
+}
@@ -17,11 +17,13 @@ package brightbox
 import (
 	"context"
 	"testing"
+	"time"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
 	"github.com/brightbox/gobrightbox/v2/enums/serverstatus"
 	"github.com/brightbox/k8ssdk/v2"
 	"github.com/brightbox/k8ssdk/v2/mocks"
+	"github.com/go-test/deep"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	cloudprovider "k8s.io/cloud-provider"
@@ -65,7 +67,7 @@ var (
 			Address: serverExist + "." + domain,
 		},
 		{
-			Type:    v1.NodeExternalIP,
+			Type:    v1.NodeInternalIP,
 			Address: serverExistIP,
 		},
 		{
@@ -83,7 +85,7 @@ var (
 			Address: serverShutdown + "." + domain,
 		},
 		{
-			Type:    v1.NodeExternalIP,
+			Type:    v1.NodeInternalIP,
 			Address: serverShutdownIP,
 		},
 		{
@@ -371,6 +373,7 @@ func (f *fakeInstanceCloud) Server(_ context.Context, identifier string) (*brigh
 	case serverExist:
 		return &brightbox.Server{
 			ID:       identifier,
+			Name:     "worker 1",
 			Status:   serverstatus.Active,
 			Hostname: serverExist,
 			Fqdn:     serverExist + "." + domain,
@@ -378,9 +381,26 @@ func (f *fakeInstanceCloud) Server(_ context.Context, identifier string) (*brigh
 				ID:     "zon-testy",
 				Handle: zoneHandle,
 			},
+			Image: &brightbox.Image{
+				ID:   "img-ubu24",
+				Name: "ubuntu-noble-24.04-amd64-server",
+			},
 			ServerType: &brightbox.ServerType{
 				ID:     "typ-8985i",
 				Handle: typeHandle,
+				Cores:  2,
+				RAM:    4096,
+			},
+			ServerGroups: []brightbox.ServerGroup{
+				{
+					ID:   "grp-wrkrs",
+					Name: "workers.k8s-fnord",
+				},
+				{
+					ID:          "grp-web12",
+					Name:        "web.default.kubernetes",
+					Description: "k8s-brightbox-ccm cluster=kubernetes",
+				},
 			},
 			Interfaces: []brightbox.Interface{
 				{
@@ -556,17 +576,65 @@ func containsNodeAddress(list []v1.NodeAddress, item v1.NodeAddress) bool {
 	return false
 }
 
+func TestBulkServerLookups(t *testing.T) {
+	testCases := map[string]struct {
+		listFail bool
+		calls    []string
+	}{
+		"listed": {
+			calls: []string{"servers", "server srv-other"},
+		},
+		"listing fails": {
+			listFail: true,
+			calls:    []string{"servers", "server srv-list1", "servers", "server srv-list2", "servers", "server srv-other"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeCachedCloud{listFail: tc.listFail}
+			cache := newAPICache(time.Minute)
+			cached, err := cacheCloud(k8ssdk.MakeTestClient(fake, nil), cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &cloud{
+				Cloud:  cached,
+				config: cloudConfig{BulkServerLookups: true},
+				cache:  cache,
+			}
+			exists, err := client.InstanceExistsByProviderID(context.TODO(), k8ssdk.MapServerIDToProviderID("srv-list1"))
+			if err != nil || !exists {
+				t.Errorf("expected srv-list1 to exist, got %v (%v)", exists, err)
+			}
+			shutdown, err := client.InstanceShutdownByProviderID(context.TODO(), k8ssdk.MapServerIDToProviderID("srv-list2"))
+			if err != nil || shutdown != !tc.listFail {
+				t.Errorf("expected srv-list2 shutdown to be %v, got %v (%v)", !tc.listFail, shutdown, err)
+			}
+			exists, err = client.InstanceExistsByProviderID(context.TODO(), k8ssdk.MapServerIDToProviderID("srv-other"))
+			if err != nil || !exists {
+				t.Errorf("expected srv-other to exist, got %v (%v)", exists, err)
+			}
+			if diff := deep.Equal(fake.calls, tc.calls); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
 func makeFakeInstanceCloudClient() *cloud {
 	return &cloud{
-		k8ssdk.MakeTestClient(
+		Cloud: k8ssdk.MakeTestClient(
 			fakeInstanceCloudClient(context.TODO()),
 			nil,
 		),
+		// The fake's resources were built before ownership ledgers
+		config:   cloudConfig{AdoptLegacyResources: true},
+		resolver: testResolver,
 	}
 }
 
 func makeFakeCloudClient() *cloud {
 	return &cloud{
-		k8ssdk.MakeTestClient(nil, nil),
+		Cloud: k8ssdk.MakeTestClient(nil, nil),
 	}
 }
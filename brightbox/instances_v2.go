@@ -53,7 +53,7 @@ func (c *cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 	if err != nil {
 		return nil, err
 	}
-	addresses, err := nodeAddressesFromServer(srv)
+	addresses, err := nodeAddressesFromServer(srv, c.config.NodeAddresses)
 	if err != nil {
 		return nil, err
 	}
@@ -62,10 +62,11 @@ func (c *cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 		return nil, err
 	}
 	return &cloudprovider.InstanceMetadata{
-		ProviderID:    k8ssdk.MapServerIDToProviderID(srv.ID),
-		InstanceType:  srv.ServerType.Handle,
-		NodeAddresses: addresses,
-		Zone:          srv.Zone.Handle,
-		Region:        region,
+		ProviderID:       k8ssdk.MapServerIDToProviderID(srv.ID),
+		InstanceType:     srv.ServerType.Handle,
+		NodeAddresses:    addresses,
+		Zone:             srv.Zone.Handle,
+		Region:           region,
+		AdditionalLabels: nodeLabelsFromServer(srv),
 	}, nil
 }
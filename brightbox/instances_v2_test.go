@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 )
@@ -175,6 +176,7 @@ func TestInstanceMetadata(t *testing.T) {
 		expectedNodeAddresses []v1.NodeAddress
 		zone                  string
 		region                string
+		labels                map[string]string
 	}{
 		{
 			server:                serverExist,
@@ -182,6 +184,14 @@ func TestInstanceMetadata(t *testing.T) {
 			expectedNodeAddresses: expectedExistNodeAddresses,
 			zone:                  zoneHandle,
 			region:                region,
+			labels: map[string]string{
+				"brightbox.com/server-name":            "worker-1",
+				"brightbox.com/image-id":               "img-ubu24",
+				"brightbox.com/image-name":             "ubuntu-noble-24.04-amd64-server",
+				"brightbox.com/server-type-cores":      "2",
+				"brightbox.com/server-type-ram":        "4096",
+				"brightbox.com/server-group-grp-wrkrs": "workers.k8s-fnord",
+			},
 		},
 		{
 			server:                serverShutdown,
@@ -189,6 +199,7 @@ func TestInstanceMetadata(t *testing.T) {
 			expectedNodeAddresses: expectedShutdownNodeAddresses,
 			zone:                  zoneHandle2,
 			region:                region,
+			labels:                map[string]string{},
 		},
 	}
 	for _, example := range instanceTests {
@@ -214,6 +225,9 @@ func TestInstanceMetadata(t *testing.T) {
 				if metadata.Region != example.region {
 					t.Errorf("Expected Region %s, got %s", example.region, metadata.Region)
 				}
+				if diff := deep.Equal(metadata.AdditionalLabels, example.labels); diff != nil {
+					t.Error(diff)
+				}
 				addresses := metadata.NodeAddresses
 				lenExpected := len(example.expectedNodeAddresses)
 				lenAddresses := len(addresses)
@@ -0,0 +1,88 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"fmt"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/clientcredentials"
+	"github.com/brightbox/gobrightbox/v2/endpoint"
+	"github.com/brightbox/gobrightbox/v2/passwordcredentials"
+	"github.com/brightbox/k8ssdk/v2"
+)
+
+// k8ssdk only builds a Cloud from the environment, with its own HTTP
+// client and token source, and MakeTestClient is the only way to hand
+// it any other API client. The controller needs its own client to honour
+// Retry-After, rotate credentials, record metrics and cache lookups, so
+// everything that copies or works around k8ssdk is kept in this file.
+// Check it against k8ssdk's brightbox_auth.go when upgrading k8ssdk.
+
+// The API client k8ssdk falls back to, which signs in with a user's
+// credentials
+const (
+	defaultAPIClientID     = "app-dkmch"
+	defaultAPIClientSecret = "uogoelzgt0nwawb"
+)
+
+// wrapAPIClient returns a Cloud that makes its calls with the client.
+// Despite its name, MakeTestClient is k8ssdk's only constructor that
+// takes a client.
+func wrapAPIClient(client k8ssdk.CloudAccess) *k8ssdk.Cloud {
+	return k8ssdk.MakeTestClient(client, nil)
+}
+
+// oauthConfig returns the password credentials of a user if the
+// default API client is used, and the client credentials of an API
+// client otherwise, as k8ssdk does
+func oauthConfig(creds apiCredentials) (brightbox.Oauth2, error) {
+	clientID := creds.clientID
+	if clientID == "" {
+		clientID = defaultAPIClientID
+	}
+	clientSecret := creds.clientSecret
+	if clientSecret == "" {
+		clientSecret = defaultAPIClientSecret
+	}
+	if clientID == defaultAPIClientID && clientSecret == defaultAPIClientSecret {
+		if creds.account == "" {
+			return nil, fmt.Errorf("must specify Account with User Credentials")
+		}
+	} else if creds.userName != "" || creds.password != "" {
+		return nil, fmt.Errorf("User Credentials not used with API Client")
+	}
+	if creds.userName != "" || creds.password != "" {
+		return &passwordcredentials.Config{
+			UserName: creds.userName,
+			Password: creds.password,
+			ID:       clientID,
+			Secret:   clientSecret,
+			Config: endpoint.Config{
+				BaseURL: creds.apiURL,
+				Account: creds.account,
+				Scopes:  endpoint.FullScope,
+			},
+		}, nil
+	}
+	return &clientcredentials.Config{
+		ID:     clientID,
+		Secret: clientSecret,
+		Config: endpoint.Config{
+			BaseURL: creds.apiURL,
+			Scopes:  endpoint.FullScope,
+		},
+	}, nil
+}
@@ -30,11 +30,12 @@ var (
 	falsevar = false
 )
 
-// Remove load balancer by name
-func (c *cloud) ensureLoadBalancerDeletedByName(ctx context.Context, name string) (*brightbox.LoadBalancer, error) {
-	lb, err := c.GetLoadBalancerByName(ctx, name)
+// Remove the load balancer in the ledger, returning it if it was
+// destroyed
+func (c *cloud) ensureLoadBalancerDestroyed(ctx context.Context, owned *ownedResources) (*brightbox.LoadBalancer, error) {
+	lb, err := c.ownedLoadBalancer(ctx, owned)
 	if err != nil {
-		klog.V(4).Infof("Error looking for Load Balancer %q", name)
+		klog.V(4).Infof("Error looking for Load Balancer %q", owned.ledger.loadBalancer)
 		return nil, err
 	}
 	if lb != nil {
@@ -46,6 +47,22 @@ func (c *cloud) ensureLoadBalancerDeletedByName(ctx context.Context, name string
 	return lb, nil
 }
 
+// ensureLoadBalancerErased returns an error until the load balancer in
+// the ledger has gone, along with any Cloud IPs mapped to it
+func (c *cloud) ensureLoadBalancerErased(ctx context.Context, owned *ownedResources) error {
+	if owned.ledger.loadBalancer == "" {
+		return nil
+	}
+	lb, err := c.GetLoadBalancerByID(ctx, owned.ledger.loadBalancer)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return k8ssdk.ErrorIfNotErased(lb)
+}
+
 func buildLoadBalancerOptions(name string, domains []string, apiservice *v1.Service, nodes []*v1.Node) *brightbox.LoadBalancerOptions {
 	klog.V(4).Infof("buildLoadBalancerOptions(%v)", name)
 	result := &brightbox.LoadBalancerOptions{
@@ -62,6 +79,9 @@ func buildLoadBalancerOptions(name string, domains []string, apiservice *v1.Serv
 			klog.V(4).Infof("Unexpected balancing policy %q", policy)
 		}
 	}
+	if apiservice.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		result.Policy = balancingpolicy.SourceAddress
+	}
 	for _, listener := range result.Listeners {
 		if listener.Protocol == listenerprotocol.Https {
 			result.Domains = &domains
@@ -93,16 +113,21 @@ func buildLoadBalancerListeners(apiservice *v1.Service) []brightbox.LoadBalancer
 		return nil
 	}
 	sslPortSet := getPortSets(apiservice.Annotations[serviceAnnotationLoadBalancerSSLPorts])
+	//Validate has already checked these so there should be no errors!
+	protocols, _ := getPortMap(apiservice.Annotations[serviceAnnotationLoadBalancerListenerPortProtocols])
+	proxyProtocols, _ := getPortMap(apiservice.Annotations[serviceAnnotationLoadBalancerListenerPortProxyProtocols])
+	timeouts, _ := getPortMap(apiservice.Annotations[serviceAnnotationLoadBalancerListenerPortIdleTimeouts])
 	result := make([]brightbox.LoadBalancerListener, len(apiservice.Spec.Ports))
 	for i := range apiservice.Spec.Ports {
-		result[i].Protocol = getListenerProtocol(apiservice)
-		result[i].ProxyProtocol = getListenerProxyProtocol(apiservice)
-		if result[i].Protocol != listenerprotocol.Tcp && isSSLPort(&apiservice.Spec.Ports[i], sslPortSet) {
+		port := &apiservice.Spec.Ports[i]
+		result[i].Protocol = getPortListenerProtocol(apiservice, port, protocols)
+		result[i].ProxyProtocol = getPortListenerProxyProtocol(apiservice, port, proxyProtocols)
+		if result[i].Protocol != listenerprotocol.Tcp && isSSLPort(port, sslPortSet) {
 			result[i].Protocol = listenerprotocol.Https
 		}
-		result[i].In = uint16(apiservice.Spec.Ports[i].Port)
-		result[i].Out = uint16(apiservice.Spec.Ports[i].NodePort)
-		result[i].Timeout, _ = parseUintAnnotation(apiservice.Annotations, serviceAnnotationLoadBalancerListenerIdleTimeout)
+		result[i].In = uint16(port.Port)
+		result[i].Out = uint16(port.NodePort)
+		result[i].Timeout = getPortListenerIdleTimeout(apiservice, port, timeouts)
 	}
 	return result
 }
@@ -126,7 +151,33 @@ func buildLoadBalancerHealthCheck(apiservice *v1.Service) *brightbox.LoadBalance
 	}
 }
 
-func toLoadBalancerStatus(lb *brightbox.LoadBalancer) *v1.LoadBalancerStatus {
+// Load balancer ingress status modes
+const (
+	statusModeHostname = "hostname"
+	statusModeIP       = "ip"
+	statusModeBoth     = "both"
+)
+
+var (
+	validStatusModes = []string{statusModeHostname, statusModeIP, statusModeBoth}
+	proxyIPMode      = v1.LoadBalancerIPModeProxy
+)
+
+// getStatusMode returns the status mode from the service annotation,
+// falling back to the cluster default
+func (c *cloud) getStatusMode(apiservice *v1.Service) string {
+	if mode, ok := apiservice.Annotations[serviceAnnotationLoadBalancerStatusMode]; ok {
+		return mode
+	}
+	if c.config.StatusMode != "" {
+		return c.config.StatusMode
+	}
+	return statusModeHostname
+}
+
+// Brightbox load balancers proxy connections rather than forward
+// packets, so IP entries are published with the Proxy IP mode.
+func toLoadBalancerStatus(lb *brightbox.LoadBalancer, mode string) *v1.LoadBalancerStatus {
 	status := v1.LoadBalancerStatus{}
 	if lb == nil {
 		return &status
@@ -134,16 +185,21 @@ func toLoadBalancerStatus(lb *brightbox.LoadBalancer) *v1.LoadBalancerStatus {
 	if len(lb.CloudIPs) > 0 {
 		status.Ingress = make([]v1.LoadBalancerIngress, 0, len(lb.CloudIPs)*4)
 		for _, v := range lb.CloudIPs {
-			/*
-				status.Ingress = append(status.Ingress,
-					v1.LoadBalancerIngress{
-						IP: v.PublicIPv4,
-					},
-					v1.LoadBalancerIngress{
-						IP: v.PublicIPv6,
-					},
-				)
-			*/
+			if mode != statusModeHostname {
+				for _, ip := range []string{v.PublicIPv4, v.PublicIPv6} {
+					if ip != "" {
+						status.Ingress = append(status.Ingress,
+							v1.LoadBalancerIngress{
+								IP:     ip,
+								IPMode: &proxyIPMode,
+							},
+						)
+					}
+				}
+			}
+			if mode == statusModeIP {
+				continue
+			}
 			if v.ReverseDNS != "" {
 				status.Ingress = append(status.Ingress,
 					v1.LoadBalancerIngress{
@@ -163,23 +219,49 @@ func toLoadBalancerStatus(lb *brightbox.LoadBalancer) *v1.LoadBalancerStatus {
 	return &status
 }
 
-func (c *cloud) ensureLoadBalancerFromService(ctx context.Context, name string, domains []string, apiservice *v1.Service, nodes []*v1.Node) (*brightbox.LoadBalancer, error) {
+func (c *cloud) ensureLoadBalancerFromService(ctx context.Context, owned *ownedResources, domains []string, cert *serviceCertificate, apiservice *v1.Service, nodes []*v1.Node) (*brightbox.LoadBalancer, error) {
+	name := owned.group.Name
 	klog.V(4).Infof("ensureLoadBalancerFromService(%v)", name)
-	currentLb, err := c.GetLoadBalancerByName(ctx, name)
+	currentLb, err := c.ownedLoadBalancer(ctx, owned)
 	if err != nil {
 		return nil, err
 	}
-	err = c.ensureFirewallOpenForService(ctx, name, apiservice, nodes)
+	err = c.ensureFirewallOpenForService(ctx, owned, apiservice, nodes, "")
 	if err != nil {
-		return nil, err
+		return nil, c.recordWarning(apiservice, eventFirewallUpdateFailed, err)
 	}
 	newLB := buildLoadBalancerOptions(name, domains, apiservice, nodes)
+	cert.apply(newLB)
 	if currentLb == nil {
-		return c.Cloud.CreateLoadBalancer(ctx, *newLB)
-	} else if k8ssdk.IsUpdateLoadBalancerRequired(currentLb, *newLB) {
+		lb, err := c.Cloud.CreateLoadBalancer(ctx, *newLB)
+		if err != nil {
+			return nil, c.recordWarning(apiservice, eventLoadBalancerUpdateFailed, err)
+		}
+		c.recordEvent(apiservice, eventCreatedLoadBalancer, "Created load balancer %s", lb.ID)
+		owned.ledger.loadBalancer = lb.ID
+		return lb, c.saveLedger(ctx, owned)
+	} else if k8ssdk.IsUpdateLoadBalancerRequired(currentLb, *newLB) || cert.isUpdateRequired(currentLb) {
 		newLB.ID = currentLb.ID
-		return c.Cloud.UpdateLoadBalancer(ctx, *newLB)
+		lb, err := c.Cloud.UpdateLoadBalancer(ctx, *newLB)
+		if err != nil {
+			return nil, c.recordWarning(apiservice, eventLoadBalancerUpdateFailed, err)
+		}
+		c.recordEvent(apiservice, eventUpdatedLoadBalancer, "Updated load balancer %s", lb.ID)
+		return lb, nil
 	}
 	klog.V(4).Infof("No Load Balancer update required for %q, skipping", currentLb.ID)
 	return currentLb, nil
 }
+
+// recordPendingAcmeDomains records an event for each domain Let's
+// Encrypt has yet to validate
+func (c *cloud) recordPendingAcmeDomains(apiservice *v1.Service, lb *brightbox.LoadBalancer) {
+	if lb.Acme == nil {
+		return
+	}
+	for _, domain := range lb.Acme.Domains {
+		if domain.Status != k8ssdk.ValidAcmeDomainStatus {
+			c.recordEvent(apiservice, eventWaitingForACME, "Waiting for ACME validation of domain %s (%s: %s)", domain.Identifier, domain.Status, domain.LastMessage)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// serviceCertificate is a certificate and key read from a TLS Secret
+type serviceCertificate struct {
+	certificatePem string
+	privateKey     string
+	leaf           *x509.Certificate
+}
+
+// getServiceCertificate reads the TLS Secret named in the service
+// annotation. Returns nil if the service doesn't name a Secret.
+func (c *cloud) getServiceCertificate(ctx context.Context, apiservice *v1.Service) (*serviceCertificate, error) {
+	secretName, ok := apiservice.Annotations[serviceAnnotationLoadBalancerSSLCertificateSecret]
+	if !ok {
+		return nil, nil
+	}
+	klog.V(4).Infof("getServiceCertificate (%s/%s)", apiservice.Namespace, secretName)
+	if c.kubeClient == nil {
+		return nil, fmt.Errorf("No Kubernetes client available to read certificate secret %q", secretName)
+	}
+	secret, err := c.kubeClient.CoreV1().Secrets(apiservice.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read certificate secret %q: %w", secretName, err)
+	}
+	return parseTLSSecret(secret)
+}
+
+// parseTLSSecret checks the certificate and key in the Secret belong
+// together before they are sent to the API
+func parseTLSSecret(secret *v1.Secret) (*serviceCertificate, error) {
+	if secret.Type != v1.SecretTypeTLS {
+		return nil, fmt.Errorf("Secret %q has type %q, needs to be %q", secret.Name, secret.Type, v1.SecretTypeTLS)
+	}
+	certPem := secret.Data[v1.TLSCertKey]
+	keyPem := secret.Data[v1.TLSPrivateKeyKey]
+	pair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("Secret %q does not hold a valid certificate and key: %w", secret.Name, err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Secret %q does not hold a valid certificate: %w", secret.Name, err)
+	}
+	return &serviceCertificate{
+		certificatePem: string(certPem),
+		privateKey:     string(keyPem),
+		leaf:           leaf,
+	}, nil
+}
+
+// apply replaces Let's Encrypt with the certificate on load balancers
+// with an https listener
+func (cert *serviceCertificate) apply(lbOptions *brightbox.LoadBalancerOptions) {
+	if cert == nil || lbOptions.Domains == nil {
+		return
+	}
+	lbOptions.Domains = nil
+	lbOptions.CertificatePem = &cert.certificatePem
+	lbOptions.CertificatePrivateKey = &cert.privateKey
+}
+
+// isUpdateRequired compares the validity period of the certificate with
+// the one on the load balancer. The API doesn't return the certificate
+// itself, and a renewed certificate always has a new validity period.
+func (cert *serviceCertificate) isUpdateRequired(lb *brightbox.LoadBalancer) bool {
+	if cert == nil {
+		return false
+	}
+	if lb.Certificate == nil {
+		return true
+	}
+	return lb.Certificate.IssuedAt.Unix() != cert.leaf.NotBefore.Unix() ||
+		lb.Certificate.ExpiresAt.Unix() != cert.leaf.NotAfter.Unix()
+}
+
+// certificateSecretPollPeriod is how often the Secrets named by
+// services are read to spot a renewed certificate
+const certificateSecretPollPeriod = 5 * time.Minute
+
+// pollCertificateSecrets re-syncs load balancers when the TLS Secret
+// they use changes, queueing the services using it whenever the
+// Secret's resource version moves on. Only the Secrets services name
+// are read, so the controller needs no list or watch on Secrets and
+// holds no private keys between syncs.
+func (c *cloud) pollCertificateSecrets(stop <-chan struct{}) {
+	klog.V(4).Info("pollCertificateSecrets called")
+	informer := c.informerFactory.Core().V1().Services()
+	services := informer.Lister()
+	synced := informer.Informer().HasSynced
+	go func() {
+		if !cache.WaitForCacheSync(stop, synced) {
+			return
+		}
+		ctx := wait.ContextForChannel(stop)
+		wait.Until(func() {
+			list, err := services.List(labels.Everything())
+			if err != nil {
+				klog.Errorf("Failed to list services using certificate secrets: %v", err)
+				return
+			}
+			c.resyncServicesUsingSecrets(ctx, list)
+		}, certificateSecretPollPeriod, stop)
+	}()
+}
+
+// resyncServicesUsingSecrets reads the Secret named by each load
+// balancer service and queues the service if it has changed
+func (c *cloud) resyncServicesUsingSecrets(ctx context.Context, services []*v1.Service) {
+	for _, service := range services {
+		secretName, ok := service.Annotations[serviceAnnotationLoadBalancerSSLCertificateSecret]
+		if !ok || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		secret, err := c.kubeClient.CoreV1().Secrets(service.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("Failed to read certificate secret %s/%s: %v", service.Namespace, secretName, err)
+			continue
+		}
+		c.queueServiceResync(service, resyncCertificate, secret.ResourceVersion)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	certNotBefore = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	certNotAfter  = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// testCertificate returns a self signed certificate and key in PEM form
+func testCertificate(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.example.com"},
+		DNSNames:     []string{"*.example.com"},
+		NotBefore:    certNotBefore,
+		NotAfter:     certNotAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestParseTLSSecret(t *testing.T) {
+	certPem, keyPem := testCertificate(t)
+	_, otherKeyPem := testCertificate(t)
+	testCases := map[string]struct {
+		secret *v1.Secret
+		status string
+	}{
+		"valid": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+				Type:       v1.SecretTypeTLS,
+				Data: map[string][]byte{
+					v1.TLSCertKey:       certPem,
+					v1.TLSPrivateKeyKey: keyPem,
+				},
+			},
+		},
+		"opaque": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+				Type:       v1.SecretTypeOpaque,
+				Data: map[string][]byte{
+					v1.TLSCertKey:       certPem,
+					v1.TLSPrivateKeyKey: keyPem,
+				},
+			},
+			status: `Secret "tls" has type "Opaque", needs to be "kubernetes.io/tls"`,
+		},
+		"mismatched-key": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+				Type:       v1.SecretTypeTLS,
+				Data: map[string][]byte{
+					v1.TLSCertKey:       certPem,
+					v1.TLSPrivateKeyKey: otherKeyPem,
+				},
+			},
+			status: `Secret "tls" does not hold a valid certificate and key: tls: private key does not match public key`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cert, err := parseTLSSecret(tc.secret)
+			if tc.status != "" {
+				if err == nil {
+					t.Fatalf("expected error %q", tc.status)
+				}
+				if diff := deep.Equal(err.Error(), tc.status); diff != nil {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(cert.certificatePem, string(certPem)); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(cert.leaf.NotAfter, certNotAfter); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCertificateApply(t *testing.T) {
+	certPem, keyPem := testCertificate(t)
+	cert, err := parseTLSSecret(&v1.Secret{
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       certPem,
+			v1.TLSPrivateKeyKey: keyPem,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lbname := "test"
+	https := buildLoadBalancerOptions(lbname, nil, &v1.Service{
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443, NodePort: 31443}},
+		},
+	}, nil)
+	cert.apply(https)
+	if https.Domains != nil {
+		t.Errorf("expected no domains, got %v", *https.Domains)
+	}
+	if https.CertificatePem == nil || *https.CertificatePem != string(certPem) {
+		t.Errorf("expected certificate to be set")
+	}
+	if https.CertificatePrivateKey == nil || *https.CertificatePrivateKey != string(keyPem) {
+		t.Errorf("expected private key to be set")
+	}
+	http := buildLoadBalancerOptions(lbname, nil, &v1.Service{
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 31080}},
+		},
+	}, nil)
+	cert.apply(http)
+	if http.CertificatePem != nil {
+		t.Errorf("expected no certificate without an https listener")
+	}
+}
+
+func TestCertificateUpdateRequired(t *testing.T) {
+	certPem, keyPem := testCertificate(t)
+	cert, err := parseTLSSecret(&v1.Secret{
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       certPem,
+			v1.TLSPrivateKeyKey: keyPem,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		cert     *serviceCertificate
+		lb       *brightbox.LoadBalancer
+		expected bool
+	}{
+		"no-secret": {
+			lb: &brightbox.LoadBalancer{},
+		},
+		"no-certificate": {
+			cert:     cert,
+			lb:       &brightbox.LoadBalancer{},
+			expected: true,
+		},
+		"same": {
+			cert: cert,
+			lb: &brightbox.LoadBalancer{
+				Certificate: &brightbox.LoadBalancerAcmeCertificate{
+					IssuedAt:  certNotBefore,
+					ExpiresAt: certNotAfter,
+				},
+			},
+		},
+		"renewed": {
+			cert: cert,
+			lb: &brightbox.LoadBalancer{
+				Certificate: &brightbox.LoadBalancerAcmeCertificate{
+					IssuedAt:  certNotBefore.AddDate(-1, 0, 0),
+					ExpiresAt: certNotAfter.AddDate(-1, 0, 0),
+				},
+			},
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(tc.cert.isUpdateRequired(tc.lb), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestGetServiceCertificate(t *testing.T) {
+	certPem, keyPem := testCertificate(t)
+	client := &cloud{
+		kubeClient: fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"},
+			Type:       v1.SecretTypeTLS,
+			Data: map[string][]byte{
+				v1.TLSCertKey:       certPem,
+				v1.TLSPrivateKeyKey: keyPem,
+			},
+		}),
+	}
+	testCases := map[string]struct {
+		annotations map[string]string
+		present     bool
+		status      string
+	}{
+		"none": {},
+		"found": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerSSLCertificateSecret: "tls",
+			},
+			present: true,
+		},
+		"missing": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerSSLCertificateSecret: "missing",
+			},
+			status: `Failed to read certificate secret "missing": secrets "missing" not found`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web",
+					Namespace:   "default",
+					Annotations: tc.annotations,
+				},
+			}
+			cert, err := client.getServiceCertificate(context.TODO(), service)
+			if tc.status != "" {
+				if err == nil {
+					t.Fatalf("expected error %q", tc.status)
+				}
+				if diff := deep.Equal(err.Error(), tc.status); diff != nil {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(cert != nil, tc.present); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestResyncServicesUsingSecrets(t *testing.T) {
+	services := []*v1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "uses-secret",
+				Namespace: "default",
+				Annotations: map[string]string{
+					serviceAnnotationLoadBalancerSSLCertificateSecret: "tls",
+				},
+			},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "missing-secret",
+				Namespace: "default",
+				Annotations: map[string]string{
+					serviceAnnotationLoadBalancerSSLCertificateSecret: "missing",
+				},
+			},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "no-secret",
+				Namespace: "default",
+			},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "tls",
+			Namespace:       "default",
+			ResourceVersion: "42",
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(secret)
+	client := &cloud{resync: testServiceResyncController(t), kubeClient: kubeClient}
+	client.resyncServicesUsingSecrets(context.TODO(), services)
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string{"default/uses-secret"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// The same version again is ignored
+	client.resyncServicesUsingSecrets(context.TODO(), services)
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string(nil)); diff != nil {
+		t.Error(diff)
+	}
+
+	// A renewed certificate queues the service again
+	secret.ResourceVersion = "43"
+	if _, err := kubeClient.CoreV1().Secrets("default").Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	client.resyncServicesUsingSecrets(context.TODO(), services)
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string{"default/uses-secret"}); diff != nil {
+		t.Error(diff)
+	}
+	if len(services[0].Annotations) != 1 {
+		t.Errorf("expected the service to be left alone, got %v", services[0].Annotations)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultLoadBalancerClass = "brightbox.com/load-balancer"
+	defaultCloudIPClass      = "brightbox.com/cloud-ip"
+
+	// loadBalancerClassFinalizer holds on to services with a Brightbox
+	// class until their resources have been removed
+	loadBalancerClassFinalizer = "brightbox.com/load-balancer-cleanup"
+
+	// Services with a Brightbox class are re-synced this often, which
+	// picks up node changes the watches miss
+	loadBalancerClassResyncPeriod = 5 * time.Minute
+
+	// Set by the cluster autoscaler on nodes it is about to remove
+	toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+)
+
+// serviceMode is how the controller gives a service its external
+// address
+type serviceMode int
+
+const (
+	// The service belongs to another load balancer implementation
+	modeNone serviceMode = iota
+	// A Brightbox load balancer with a Cloud IP mapped to it
+	modeLoadBalancer
+	// A Cloud IP mapped straight to a node
+	modeCloudIP
+)
+
+// Values of the mode annotation
+const (
+	modeNameLoadBalancer = "load-balancer"
+	modeNameCloudIP      = "cloud-ip"
+)
+
+var validModeNames = []string{modeNameLoadBalancer, modeNameCloudIP}
+
+// serviceMode returns how the service's load balancer is provided.
+// Services without a class, or with the load balancer class, can ask
+// for Cloud IP mode with the mode annotation.
+func (c *cloud) serviceMode(apiservice *v1.Service) serviceMode {
+	if apiservice.Spec.LoadBalancerClass != nil {
+		switch *apiservice.Spec.LoadBalancerClass {
+		case c.config.cloudIPClass():
+			return modeCloudIP
+		case c.config.loadBalancerClass():
+		default:
+			return modeNone
+		}
+	}
+	if apiservice.Annotations[serviceAnnotationLoadBalancerMode] == modeNameCloudIP {
+		return modeCloudIP
+	}
+	return modeLoadBalancer
+}
+
+// hasBrightboxClass reports whether the service names one of the
+// controller's load balancer classes
+func (c *cloud) hasBrightboxClass(apiservice *v1.Service) bool {
+	return apiservice.Spec.LoadBalancerClass != nil && c.serviceMode(apiservice) != modeNone
+}
+
+// loadBalancerClassController provides load balancers for services
+// that name a Brightbox class. The service controller ignores every
+// service with a class, so these are watched here and passed to the
+// same EnsureLoadBalancer and EnsureLoadBalancerDeleted calls the
+// service controller makes. The controller keeps the service's status
+// and finalizer up to date as the service controller would.
+type loadBalancerClassController struct {
+	cloud    *cloud
+	services corelisters.ServiceLister
+	nodes    corelisters.NodeLister
+	queue    workqueue.TypedRateLimitingInterface[string]
+}
+
+// startLoadBalancerClassController runs the class controller until stop
+// is closed, once the service and node caches have synced
+func (c *cloud) startLoadBalancerClassController(stop <-chan struct{}) {
+	klog.V(4).Info("startLoadBalancerClassController called")
+	serviceInformer := c.informerFactory.Core().V1().Services()
+	nodeInformer := c.informerFactory.Core().V1().Nodes()
+	lc := &loadBalancerClassController{
+		cloud:    c,
+		services: serviceInformer.Lister(),
+		nodes:    nodeInformer.Lister(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "brightbox-load-balancer-class"},
+		),
+	}
+	c.classQueue = lc.queue
+	_, err := serviceInformer.Informer().AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
+		AddFunc:    lc.enqueueService,
+		UpdateFunc: func(_, obj interface{}) { lc.enqueueService(obj) },
+		DeleteFunc: lc.enqueueService,
+	}, loadBalancerClassResyncPeriod)
+	if err != nil {
+		klog.Errorf("Failed to watch services for load balancer classes: %v", err)
+		return
+	}
+	_, err = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { lc.enqueueAll() },
+		DeleteFunc: func(interface{}) { lc.enqueueAll() },
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch nodes for load balancer classes: %v", err)
+		return
+	}
+	synced := []cache.InformerSynced{serviceInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced}
+	go func() {
+		defer lc.queue.ShutDown()
+		if !cache.WaitForCacheSync(stop, synced...) {
+			return
+		}
+		ctx := wait.ContextForChannel(stop)
+		go wait.Until(func() {
+			for lc.processNextItem(ctx) {
+			}
+		}, time.Second, stop)
+		<-stop
+	}()
+}
+
+// handles reports whether the service is, or was, the class
+// controller's to look after
+func (lc *loadBalancerClassController) handles(service *v1.Service) bool {
+	return lc.cloud.hasBrightboxClass(service) || slices.Contains(service.Finalizers, loadBalancerClassFinalizer)
+}
+
+func (lc *loadBalancerClassController) enqueueService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	service, ok := obj.(*v1.Service)
+	if !ok || !lc.handles(service) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(service)
+	if err != nil {
+		klog.Errorf("Failed to queue service %s/%s: %v", service.Namespace, service.Name, err)
+		return
+	}
+	lc.queue.Add(key)
+}
+
+// enqueueAll queues every service with a Brightbox class, so their load
+// balancers pick up a change of nodes
+func (lc *loadBalancerClassController) enqueueAll() {
+	services, err := lc.services.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list services: %v", err)
+		return
+	}
+	for _, service := range services {
+		lc.enqueueService(service)
+	}
+}
+
+func (lc *loadBalancerClassController) processNextItem(ctx context.Context) bool {
+	key, quit := lc.queue.Get()
+	if quit {
+		return false
+	}
+	defer lc.queue.Done(key)
+	if err := lc.sync(ctx, key); err != nil {
+		klog.Errorf("Failed to sync load balancer for service %s: %v", key, err)
+		lc.queue.AddRateLimited(key)
+		return true
+	}
+	lc.queue.Forget(key)
+	return true
+}
+
+// sync builds or removes the load balancer of a single service
+func (lc *loadBalancerClassController) sync(ctx context.Context, key string) error {
+	klog.V(4).Infof("loadBalancerClassController sync (%q)", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := lc.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if service.DeletionTimestamp != nil || !lc.wantsLoadBalancer(service) {
+		return lc.deleteLoadBalancer(ctx, service)
+	}
+	if err := lc.addFinalizer(service); err != nil {
+		return err
+	}
+	nodes, err := loadBalancerNodes(lc.nodes)
+	if err != nil {
+		return err
+	}
+	status, err := lc.cloud.EnsureLoadBalancer(ctx, lc.cloud.ClusterName(), service, nodes)
+	if err != nil {
+		return err
+	}
+	return lc.cloud.updateLoadBalancerStatus(service, status)
+}
+
+func (lc *loadBalancerClassController) wantsLoadBalancer(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer && lc.cloud.hasBrightboxClass(service)
+}
+
+// deleteLoadBalancer removes the resources of a service that is going,
+// or no longer wants a Brightbox load balancer, then lets it go
+func (lc *loadBalancerClassController) deleteLoadBalancer(ctx context.Context, service *v1.Service) error {
+	if !slices.Contains(service.Finalizers, loadBalancerClassFinalizer) {
+		return nil
+	}
+	if err := lc.cloud.EnsureLoadBalancerDeleted(ctx, lc.cloud.ClusterName(), service); err != nil {
+		return err
+	}
+	if service.DeletionTimestamp == nil {
+		if err := lc.cloud.updateLoadBalancerStatus(service, &v1.LoadBalancerStatus{}); err != nil {
+			return err
+		}
+	}
+	return lc.removeFinalizer(service)
+}
+
+// loadBalancerNodes returns the nodes the service controller would pass
+// to a load balancer: those not excluded by label and not about to be
+// removed
+func loadBalancerNodes(lister corelisters.NodeLister) ([]*v1.Node, error) {
+	nodes, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.DeletionTimestamp != nil || isExcludedFromLoadBalancers(node) {
+			continue
+		}
+		result = append(result, node)
+	}
+	return result, nil
+}
+
+func isExcludedFromLoadBalancers(node *v1.Node) bool {
+	if value, ok := node.Labels[v1.LabelNodeExcludeBalancers]; ok {
+		excluded, err := strconv.ParseBool(value)
+		return err != nil || excluded
+	}
+	return slices.ContainsFunc(node.Spec.Taints, func(taint v1.Taint) bool {
+		return taint.Key == toBeDeletedTaint
+	})
+}
+
+func (lc *loadBalancerClassController) addFinalizer(service *v1.Service) error {
+	if slices.Contains(service.Finalizers, loadBalancerClassFinalizer) {
+		return nil
+	}
+	updated := service.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, loadBalancerClassFinalizer)
+	_, err := servicehelper.PatchService(lc.cloud.kubeClient.CoreV1(), service, updated)
+	return err
+}
+
+func (lc *loadBalancerClassController) removeFinalizer(service *v1.Service) error {
+	updated := service.DeepCopy()
+	updated.Finalizers = slices.DeleteFunc(updated.Finalizers, func(finalizer string) bool {
+		return finalizer == loadBalancerClassFinalizer
+	})
+	_, err := servicehelper.PatchService(lc.cloud.kubeClient.CoreV1(), service, updated)
+	return err
+}
+
+// updateLoadBalancerStatus publishes the load balancer's status on the
+// service as the service controller would
+func (c *cloud) updateLoadBalancerStatus(service *v1.Service, status *v1.LoadBalancerStatus) error {
+	if servicehelper.LoadBalancerStatusEqual(&service.Status.LoadBalancer, status) {
+		return nil
+	}
+	updated := service.DeepCopy()
+	updated.Status.LoadBalancer = *status
+	_, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+	return err
+}
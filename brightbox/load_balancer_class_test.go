@@ -0,0 +1,223 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestServiceMode(t *testing.T) {
+	class := func(name string) *string { return &name }
+	testCases := map[string]struct {
+		config      cloudConfig
+		class       *string
+		annotations map[string]string
+		expected    serviceMode
+		claimed     bool
+	}{
+		"no class": {
+			expected: modeLoadBalancer,
+		},
+		"load balancer class": {
+			class:    class(defaultLoadBalancerClass),
+			expected: modeLoadBalancer,
+			claimed:  true,
+		},
+		"cloud ip class": {
+			class:    class(defaultCloudIPClass),
+			expected: modeCloudIP,
+			claimed:  true,
+		},
+		"mode annotation": {
+			annotations: map[string]string{serviceAnnotationLoadBalancerMode: modeNameCloudIP},
+			expected:    modeCloudIP,
+		},
+		"mode annotation with load balancer class": {
+			class:       class(defaultLoadBalancerClass),
+			annotations: map[string]string{serviceAnnotationLoadBalancerMode: modeNameCloudIP},
+			expected:    modeCloudIP,
+			claimed:     true,
+		},
+		"mode annotation with other class": {
+			class:       class("metallb.io/metallb"),
+			annotations: map[string]string{serviceAnnotationLoadBalancerMode: modeNameCloudIP},
+			expected:    modeNone,
+		},
+		"other class": {
+			class:    class("metallb.io/metallb"),
+			expected: modeNone,
+		},
+		"configured class": {
+			config:   cloudConfig{LoadBalancerClass: "example.com/public"},
+			class:    class("example.com/public"),
+			expected: modeLoadBalancer,
+			claimed:  true,
+		},
+		"default class replaced": {
+			config:   cloudConfig{LoadBalancerClass: "example.com/public"},
+			class:    class(defaultLoadBalancerClass),
+			expected: modeNone,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &cloud{config: tc.config}
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       v1.ServiceSpec{LoadBalancerClass: tc.class},
+			}
+			if diff := deep.Equal(client.serviceMode(service), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(client.hasBrightboxClass(service), tc.claimed); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestOtherClassImplementedElsewhere(t *testing.T) {
+	class := "metallb.io/metallb"
+	service := testLedgerService("other", "uid-other")
+	service.Spec.LoadBalancerClass = &class
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(newFakeLedgerCloud(), nil)}
+	_, err := client.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nil)
+	if !errors.Is(err, cloudprovider.ImplementedElsewhere) {
+		t.Errorf("EnsureLoadBalancer: expected ImplementedElsewhere, got %v", err)
+	}
+	err = client.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", service)
+	if !errors.Is(err, cloudprovider.ImplementedElsewhere) {
+		t.Errorf("EnsureLoadBalancerDeleted: expected ImplementedElsewhere, got %v", err)
+	}
+}
+
+func TestIsExcludedFromLoadBalancers(t *testing.T) {
+	testCases := map[string]struct {
+		labels   map[string]string
+		taints   []v1.Taint
+		expected bool
+	}{
+		"plain": {},
+		"excluded": {
+			labels:   map[string]string{v1.LabelNodeExcludeBalancers: "true"},
+			expected: true,
+		},
+		"not excluded": {
+			labels: map[string]string{v1.LabelNodeExcludeBalancers: "false"},
+		},
+		"garbled label": {
+			labels:   map[string]string{v1.LabelNodeExcludeBalancers: "maybe"},
+			expected: true,
+		},
+		"being removed": {
+			taints:   []v1.Taint{{Key: toBeDeletedTaint, Effect: v1.TaintEffectNoSchedule}},
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: tc.labels},
+				Spec:       v1.NodeSpec{Taints: tc.taints},
+			}
+			if diff := deep.Equal(isExcludedFromLoadBalancers(node), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerClassSync(t *testing.T) {
+	service := testCloudIPService()
+	kubeClient := fake.NewSimpleClientset(service)
+	fake := newFakeCloudIPCloud()
+	client := &cloud{
+		Cloud:      k8ssdk.MakeTestClient(fake, nil),
+		kubeClient: kubeClient,
+	}
+	excluded := testNode("node-a", "srv-aaaaa")
+	excluded.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "true"}
+	lc := &loadBalancerClassController{
+		cloud:    client,
+		services: testServiceLister(t, service),
+		nodes:    testNodeLister(t, excluded, testNode("node-b", "srv-bbbbb")),
+	}
+	if err := lc.sync(context.TODO(), "default/smtp"); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	result, err := kubeClient.CoreV1().Services("default").Get(context.TODO(), "smtp", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(result.Finalizers, []string{loadBalancerClassFinalizer}); diff != nil {
+		t.Error(diff)
+	}
+	expectedStatus := v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{IP: "109.107.50.1", IPMode: &vipIPMode}},
+	}
+	if diff := deep.Equal(result.Status.LoadBalancer, expectedStatus); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(fake.cloudIPs[len(fake.cloudIPs)-1].Server.ID, "srv-bbbbb"); diff != nil {
+		t.Error(diff)
+	}
+
+	// The service is deleted
+	fake.calls = nil
+	deleted := result.DeepCopy()
+	deleted.DeletionTimestamp = &metav1.Time{}
+	lc.services = testServiceLister(t, deleted)
+	if err := lc.sync(context.TODO(), "default/smtp"); err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	result, err = kubeClient.CoreV1().Services("default").Get(context.TODO(), "smtp", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Finalizers) != 0 {
+		t.Errorf("expected the finalizer to be removed, got %v", result.Finalizers)
+	}
+	if diff := deep.Equal(fake.calls[:2], []string{"unmap cip-new", "destroy cip-new"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Gone services are left alone
+	lc.services = testServiceLister(t)
+	if err := lc.sync(context.TODO(), "default/smtp"); err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	}
+}
+
+func testNodeLister(t *testing.T, nodes ...*v1.Node) corelisters.NodeLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return corelisters.NewNodeLister(indexer)
+}
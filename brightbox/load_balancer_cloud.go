@@ -17,15 +17,36 @@ package brightbox
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
 
 	"github.com/brightbox/k8ssdk/v2"
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 )
 
+const (
+	// Longer names are shortened to fit every Brightbox resource type
+	maxLoadBalancerNameLength = 64
+	// Hex digits of the hash that ends a shortened name
+	loadBalancerNameHashLength = 8
+)
+
+// Return the name recorded in the service's name annotation. Otherwise
+// return a name that is 'name'.'namespace'.'clusterName', shortened if
+// it is too long.
+func (c *cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	if name := service.Annotations[serviceAnnotationLoadBalancerName]; name != "" {
+		return name
+	}
+	return truncateLoadBalancerName(legacyLoadBalancerName(clusterName, service))
+}
+
 // Return a name that is 'name'.'namespace'.'clusterName'
 // Use the default name derived from the UID if no name field is set
-func (c *cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+func legacyLoadBalancerName(clusterName string, service *v1.Service) string {
 	namespace := service.Namespace
 	if namespace == "" {
 		namespace = "default"
@@ -43,54 +64,186 @@ func (c *cloud) GetLoadBalancerName(ctx context.Context, clusterName string, ser
 	return buffer.String()
 }
 
+// truncateLoadBalancerName shortens a name that is too long, replacing
+// the end with a hash of the whole name so that names sharing a long
+// prefix stay distinct
+func truncateLoadBalancerName(name string) string {
+	if len(name) <= maxLoadBalancerNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:loadBalancerNameHashLength]
+	prefix := strings.ToValidUTF8(name[:maxLoadBalancerNameLength-loadBalancerNameHashLength-1], "")
+	return prefix + "-" + suffix
+}
+
+// loadBalancerName returns the name of the service's resources. Load
+// balancers built before names were shortened and recorded keep their
+// long name for as long as its server group exists.
+func (c *cloud) loadBalancerName(ctx context.Context, clusterName string, service *v1.Service) (string, error) {
+	name := c.GetLoadBalancerName(ctx, clusterName, service)
+	legacy := legacyLoadBalancerName(clusterName, service)
+	if service.Annotations[serviceAnnotationLoadBalancerName] != "" || legacy == name {
+		return name, nil
+	}
+	group, err := c.GetServerGroupByName(ctx, legacy)
+	if err != nil {
+		return "", err
+	}
+	if group != nil {
+		return legacy, nil
+	}
+	return name, nil
+}
+
+// recordLoadBalancerName stores the name in the service's name
+// annotation, so it stays the same if the cluster name changes
+func (c *cloud) recordLoadBalancerName(ctx context.Context, service *v1.Service, name string) error {
+	if c.kubeClient == nil {
+		return nil
+	}
+	return c.setServiceAnnotation(ctx, service, serviceAnnotationLoadBalancerName, name)
+}
+
 func (c *cloud) GetLoadBalancer(ctx context.Context, clusterName string, apiservice *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
-	name := c.GetLoadBalancerName(ctx, clusterName, apiservice)
+	mode := c.serviceMode(apiservice)
+	if mode == modeNone {
+		return nil, false, cloudprovider.ImplementedElsewhere
+	}
+	name, err := c.loadBalancerName(ctx, clusterName, apiservice)
+	if err != nil {
+		return nil, false, err
+	}
 	if err := logAction(ctx, "GetLoadBalancer(%v)", name); err != nil {
 		return nil, false, err
 	}
-	lb, err := c.GetLoadBalancerByName(ctx, name)
-	return toLoadBalancerStatus(lb), err == nil && lb != nil, err
+	owned, err := c.findOwnedResources(ctx, name, clusterName, apiservice.UID)
+	if err != nil {
+		return nil, false, err
+	}
+	if mode == modeCloudIP {
+		status, err := c.getCloudIPServiceStatus(ctx, owned, apiservice)
+		return status, err == nil && status != nil, err
+	}
+	lb, err := c.ownedLoadBalancer(ctx, owned)
+	return toLoadBalancerStatus(lb, c.getStatusMode(apiservice)), err == nil && lb != nil, err
 }
 
 // Make sure we have a cloud ip before asking for a load balancer. Try
 // to get one matching the LoadBalancerIP spec in the service, and error
 // if that isn't in the cloudip list.
 func (c *cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, apiservice *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
-	name := c.GetLoadBalancerName(ctx, clusterName, apiservice)
+	mode := c.serviceMode(apiservice)
+	if mode == modeNone {
+		return nil, cloudprovider.ImplementedElsewhere
+	}
+	name, err := c.loadBalancerName(ctx, clusterName, apiservice)
+	if err != nil {
+		return nil, err
+	}
 	if err := logAction(ctx, "EnsureLoadBalancer(%v, %v, %v, %v)", name, apiservice.Spec.LoadBalancerIP, apiservice.Spec.Ports, apiservice.Annotations); err != nil {
 		return nil, err
 	}
-	if err := validateServiceSpec(apiservice); err != nil {
+	defer c.lockService(apiservice)()
+	// Defaults are validated when the config is read, and must not
+	// conflict with what the service asks for explicitly.
+	validate := validateServiceSpec
+	if mode == modeCloudIP {
+		validate = validateCloudIPServiceSpec
+	}
+	if err := validate(apiservice); err != nil {
 		return nil, err
 	}
-	cip, err := c.ensureAllocatedCloudIP(ctx, name, apiservice)
+	apiservice = c.config.withDefaultAnnotations(apiservice)
+	nodes = c.filterLocalTrafficNodes(apiservice, nodes)
+	if mode == modeCloudIP {
+		return c.ensureCloudIPService(ctx, name, clusterName, apiservice, nodes)
+	}
+	start := time.Now()
+	owned, err := c.ensureOwnedResources(ctx, name, clusterName, apiservice)
+	observeStep(stepOwnership, start)
 	if err != nil {
+		return nil, c.recordWarning(apiservice, eventOwnershipConflict, err)
+	}
+	if err := c.recordLoadBalancerName(ctx, apiservice, name); err != nil {
 		return nil, err
 	}
-	domains, err := ensureLoadBalancerDomainResolution(apiservice.Annotations, cip)
+	start = time.Now()
+	cips, err := c.ensureAllocatedCloudIPs(ctx, owned, apiservice)
+	observeStep(stepCloudIP, start)
 	if err != nil {
-		return nil, err
+		return nil, c.recordWarning(apiservice, eventCloudIPAllocationFailed, err)
 	}
-	lb, err := c.ensureLoadBalancerFromService(ctx, name, domains, apiservice, nodes)
+	start = time.Now()
+	err = c.ensureDNSRecords(ctx, owned, apiservice, cips)
+	observeStep(stepDNSRecords, start)
 	if err != nil {
-		return nil, err
+		return nil, c.recordWarning(apiservice, eventDNSUpdateFailed, err)
 	}
-	err = c.EnsureMappedCloudIP(ctx, lb, cip)
+	start = time.Now()
+	cips, err = c.ensureCloudIPReverseDNS(ctx, apiservice, cips)
+	observeStep(stepReverseDNS, start)
 	if err != nil {
-		return nil, err
+		return nil, c.recordWarning(apiservice, eventReverseDNSFailed, err)
 	}
-	err = c.EnsureOldCloudIPsDeposed(ctx, lb.CloudIPs, cip.ID)
+	start = time.Now()
+	cert, err := c.getServiceCertificate(ctx, apiservice)
+	observeStep(stepCertificate, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventCertificateSecretInvalid, err)
+	}
+	// A certificate from a Secret replaces Let's Encrypt, so the
+	// domains don't need to resolve to the load balancer.
+	var domains []string
+	if cert == nil {
+		start = time.Now()
+		domains, err = ensureLoadBalancerDomainResolution(ctx, c.domainResolver(apiservice), apiservice.Annotations, cips...)
+		observeStep(stepDomainResolution, start)
+		if err != nil {
+			return nil, c.recordWarning(apiservice, eventDomainResolutionFailed, err)
+		}
+	}
+	start = time.Now()
+	lb, err := c.ensureLoadBalancerFromService(ctx, owned, domains, cert, apiservice, nodes)
+	observeStep(stepLoadBalancer, start)
 	if err != nil {
 		return nil, err
 	}
-	if err := c.ensureCloudIPsDeleted(ctx, cip.ID, name); err != nil {
+	start = time.Now()
+	for _, cip := range cips {
+		if err = c.ensureMappedCloudIP(ctx, owned, apiservice, lb, cip); err != nil {
+			break
+		}
+	}
+	observeStep(stepMapCloudIP, start)
+	if err != nil {
+		return nil, c.recordWarning(apiservice, eventCloudIPMappingFailed, err)
+	}
+	start = time.Now()
+	wanted := cloudIPIDs(cips)
+	err = c.ensureOtherCloudIPsDeposed(ctx, lb.CloudIPs, wanted)
+	if err == nil {
+		err = c.ensureCloudIPsDeleted(ctx, wanted, owned)
+	}
+	observeStep(stepReleaseCloudIPs, start)
+	if err != nil {
 		return nil, err
 	}
+	start = time.Now()
 	lb, err = c.GetLoadBalancerByID(ctx, lb.ID)
+	observeStep(stepStatus, start)
 	if err != nil {
 		return nil, err
 	}
-	return toLoadBalancerStatus(lb), k8ssdk.ErrorIfNotComplete(lb, cip.ID, name)
+	c.resources.set(apiservice.UID, serviceResources{
+		loadBalancers:    1,
+		cloudIPs:         len(lb.CloudIPs),
+		serverGroups:     1,
+		firewallPolicies: 1,
+	})
+	c.resources.acmeStatus(apiservice.UID, k8ssdk.ErrorIfAcmeNotComplete(lb.Acme) != nil)
+	c.recordPendingAcmeDomains(apiservice, lb)
+	return toLoadBalancerStatus(lb, c.getStatusMode(apiservice)), errorIfNotComplete(lb, wanted, name)
 }
 
 func (c *cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, apiservice *v1.Service, nodes []*v1.Node) error {
@@ -102,28 +255,78 @@ func (c *cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, apis
 }
 
 func (c *cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, apiservice *v1.Service) error {
-	name := c.GetLoadBalancerName(ctx, clusterName, apiservice)
+	if c.serviceMode(apiservice) == modeNone {
+		return cloudprovider.ImplementedElsewhere
+	}
+	name, err := c.loadBalancerName(ctx, clusterName, apiservice)
+	if err != nil {
+		return err
+	}
 	if err := logAction(ctx, "EnsureLoadBalancerDeleted(%v, %v)", name, apiservice.Spec.LoadBalancerIP); err != nil {
 		return err
 	}
-	if err := c.ensureServerGroupDeleted(ctx, name); err != nil {
+	defer c.lockService(apiservice)()
+	owned, err := c.findOwnedResources(ctx, name, clusterName, apiservice.UID)
+	if err != nil {
 		return err
 	}
-	if err := c.ensureFirewallClosed(ctx, name); err != nil {
+	if owned != nil {
+		lb, err := c.ensureLoadBalancerDestroyed(ctx, owned)
+		if err != nil {
+			return c.recordWarning(apiservice, eventLoadBalancerDeletionFailed, err)
+		}
+		if lb != nil {
+			c.recordEvent(apiservice, eventDeletedLoadBalancer, "Deleted load balancer %s", lb.ID)
+		}
+		if err := c.ensureAllocatedCloudIPReleased(ctx, apiservice); err != nil {
+			return err
+		}
+		if err := c.ensureOwnedResourcesDeleted(ctx, owned, c.retainsCloudIPs(apiservice)); err != nil {
+			return err
+		}
+	}
+	c.resources.remove(apiservice.UID)
+	return nil
+}
+
+// ensureOwnedResourcesDeleted removes the Cloud IPs, firewall policy and
+// server group in the ledger, and the DNS records of the ledger's names,
+// once the load balancer has gone. Retained Cloud IPs are released
+// rather than removed. The server group holds
+// the ledger so it is removed last, leaving the ledger in place for a
+// retry if anything fails.
+func (c *cloud) ensureOwnedResourcesDeleted(ctx context.Context, owned *ownedResources, retain bool) error {
+	if err := c.ensureDNSRecordsRemoved(ctx, owned); err != nil {
 		return err
 	}
-	lb, err := c.ensureLoadBalancerDeletedByName(ctx, name)
+	var err error
+	if retain {
+		err = c.ensureCloudIPsReleased(ctx, owned)
+	} else {
+		err = c.ensureCloudIPsDeleted(ctx, nil, owned)
+	}
 	if err != nil {
 		return err
 	}
-	if err := c.ensureCloudIPsDeleted(ctx, "", name); err != nil {
+	if err := c.ensureLoadBalancerErased(ctx, owned); err != nil {
 		return err
 	}
-	if lb != nil {
-		lb, err = c.GetLoadBalancerByID(ctx, lb.ID)
-		if err != nil {
-			return err
-		}
+	if err := c.ensureFirewallClosed(ctx, owned); err != nil {
+		return err
+	}
+	return c.ensureServerGroupDeleted(ctx, owned)
+}
+
+// deleteLoadBalancerResources removes everything in the ledger in the
+// same order as EnsureLoadBalancerDeleted, for when there is no service
+// left to report against. Cloud IPs are retained if the cluster default
+// says so.
+func (c *cloud) deleteLoadBalancerResources(ctx context.Context, owned *ownedResources) error {
+	if err := logAction(ctx, "deleteLoadBalancerResources(%v)", owned.group.Name); err != nil {
+		return err
+	}
+	if _, err := c.ensureLoadBalancerDestroyed(ctx, owned); err != nil {
+		return err
 	}
-	return k8ssdk.ErrorIfNotErased(lb)
+	return c.ensureOwnedResourcesDeleted(ctx, owned, c.retainsCloudIPs(&v1.Service{}))
 }
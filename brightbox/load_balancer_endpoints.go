@@ -0,0 +1,135 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// usesLocalTraffic reports whether the load balancer should only send
+// traffic to nodes running the service's pods
+func usesLocalTraffic(apiservice *v1.Service) bool {
+	return apiservice.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		apiservice.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal
+}
+
+// readyEndpointNodes returns the names of the nodes hosting a ready
+// endpoint of the service. Endpoints with an unknown ready state count
+// as ready.
+func (c *cloud) readyEndpointNodes(apiservice *v1.Service) (sets.Set[string], error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: apiservice.Name})
+	endpointSlices, err := c.endpointSlices.EndpointSlices(apiservice.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	result := sets.New[string]()
+	for _, slice := range endpointSlices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.NodeName == nil {
+				continue
+			}
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			result.Insert(*endpoint.NodeName)
+		}
+	}
+	return result, nil
+}
+
+// filterLocalTrafficNodes removes nodes without a ready endpoint from
+// services with a Local external traffic policy. If no node has a
+// ready endpoint all the nodes are kept, and the health check on the
+// health check node port keeps traffic away from them. That avoids
+// emptying the load balancer while pods are rescheduled.
+func (c *cloud) filterLocalTrafficNodes(apiservice *v1.Service, nodes []*v1.Node) []*v1.Node {
+	if !usesLocalTraffic(apiservice) || c.endpointSlices == nil {
+		return nodes
+	}
+	ready, err := c.readyEndpointNodes(apiservice)
+	if err != nil {
+		klog.Warningf("Failed to read endpoints of %s/%s, using all nodes: %v", apiservice.Namespace, apiservice.Name, err)
+		return nodes
+	}
+	result := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if ready.Has(node.Name) {
+			result = append(result, node)
+		}
+	}
+	if len(result) == 0 {
+		klog.V(4).Infof("No ready endpoints for %s/%s, using all nodes", apiservice.Namespace, apiservice.Name)
+		return nodes
+	}
+	return result
+}
+
+// endpointNodesHash summarises a set of node names, so a re-sync is
+// only queued when they change
+func endpointNodesHash(nodes sets.Set[string]) string {
+	sum := sha256.Sum256([]byte(strings.Join(sets.List(nodes), ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// watchEndpointSlices re-syncs load balancers of services with a Local
+// external traffic policy when the nodes hosting their ready endpoints
+// change, queueing the service whenever a hash of those nodes moves on.
+func (c *cloud) watchEndpointSlices() {
+	klog.V(4).Info("watchEndpointSlices called")
+	informer := c.informerFactory.Discovery().V1().EndpointSlices()
+	services := c.informerFactory.Core().V1().Services().Lister()
+	c.endpointSlices = informer.Lister()
+	handler := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			return
+		}
+		serviceName := slice.Labels[discoveryv1.LabelServiceName]
+		if serviceName == "" {
+			return
+		}
+		service, err := services.Services(slice.Namespace).Get(serviceName)
+		if err != nil || !usesLocalTraffic(service) {
+			return
+		}
+		ready, err := c.readyEndpointNodes(service)
+		if err != nil {
+			klog.Errorf("Failed to read endpoints of %s/%s: %v", service.Namespace, service.Name, err)
+			return
+		}
+		c.queueServiceResync(service, resyncEndpointNodes, endpointNodesHash(ready))
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handler,
+		UpdateFunc: func(_, obj interface{}) { handler(obj) },
+		DeleteFunc: handler,
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch endpoint slices: %v", err)
+		c.endpointSlices = nil
+	}
+}
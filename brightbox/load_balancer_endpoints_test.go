@@ -0,0 +1,185 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testEndpoint(node string, ready *bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{"10.0.0.1"},
+		NodeName:   &node,
+		Conditions: discoveryv1.EndpointConditions{Ready: ready},
+	}
+}
+
+func testEndpointSliceLister(t *testing.T, endpointSlices ...*discoveryv1.EndpointSlice) discoverylisters.EndpointSliceLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, slice := range endpointSlices {
+		if err := indexer.Add(slice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return discoverylisters.NewEndpointSliceLister(indexer)
+}
+
+func TestReadyEndpointNodes(t *testing.T) {
+	client := &cloud{
+		endpointSlices: testEndpointSliceLister(t,
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-abcde",
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+				},
+				Endpoints: []discoveryv1.Endpoint{
+					testEndpoint("srv-aaaaa", &truevar),
+					testEndpoint("srv-bbbbb", &falsevar),
+					testEndpoint("srv-ccccc", nil),
+				},
+			},
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-fghij",
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+				},
+				Endpoints: []discoveryv1.Endpoint{
+					testEndpoint("srv-ddddd", &truevar),
+					{Addresses: []string{"10.0.0.2"}},
+				},
+			},
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "db-abcde",
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1.LabelServiceName: "db"},
+				},
+				Endpoints: []discoveryv1.Endpoint{
+					testEndpoint("srv-eeeee", &truevar),
+				},
+			},
+		),
+	}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	result, err := client.readyEndpointNodes(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(sets.List(result), []string{"srv-aaaaa", "srv-ccccc", "srv-ddddd"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestFilterLocalTrafficNodes(t *testing.T) {
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "srv-aaaaa"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "srv-bbbbb"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "srv-ccccc"}},
+	}
+	lister := testEndpointSliceLister(t,
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "local-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "local"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				testEndpoint("srv-bbbbb", &truevar),
+				testEndpoint("srv-ccccc", &falsevar),
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "unready-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "unready"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				testEndpoint("srv-ccccc", &falsevar),
+			},
+		},
+	)
+	testCases := map[string]struct {
+		name     string
+		policy   v1.ServiceExternalTrafficPolicy
+		lister   discoverylisters.EndpointSliceLister
+		expected []string
+	}{
+		"cluster": {
+			name:     "local",
+			policy:   v1.ServiceExternalTrafficPolicyCluster,
+			lister:   lister,
+			expected: []string{"srv-aaaaa", "srv-bbbbb", "srv-ccccc"},
+		},
+		"local": {
+			name:     "local",
+			policy:   v1.ServiceExternalTrafficPolicyLocal,
+			lister:   lister,
+			expected: []string{"srv-bbbbb"},
+		},
+		"no-ready-endpoints": {
+			name:     "unready",
+			policy:   v1.ServiceExternalTrafficPolicyLocal,
+			lister:   lister,
+			expected: []string{"srv-aaaaa", "srv-bbbbb", "srv-ccccc"},
+		},
+		"no-informer": {
+			name:     "local",
+			policy:   v1.ServiceExternalTrafficPolicyLocal,
+			expected: []string{"srv-aaaaa", "srv-bbbbb", "srv-ccccc"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &cloud{endpointSlices: tc.lister}
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.name, Namespace: "default"},
+				Spec: v1.ServiceSpec{
+					Type:                  v1.ServiceTypeLoadBalancer,
+					ExternalTrafficPolicy: tc.policy,
+				},
+			}
+			result := client.filterLocalTrafficNodes(service, nodes)
+			names := make([]string, len(result))
+			for i := range result {
+				names[i] = result[i].Name
+			}
+			if diff := deep.Equal(names, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestEndpointNodesHash(t *testing.T) {
+	first := endpointNodesHash(sets.New("srv-aaaaa", "srv-bbbbb"))
+	if diff := deep.Equal(first, endpointNodesHash(sets.New("srv-bbbbb", "srv-aaaaa"))); diff != nil {
+		t.Error(diff)
+	}
+	if first == endpointNodesHash(sets.New("srv-aaaaa")) {
+		t.Errorf("expected different node sets to have different hashes")
+	}
+}
@@ -15,12 +15,15 @@
 package brightbox
 
 import (
-	"bytes"
 	"context"
+	"net"
+	"slices"
 	"strconv"
+	"strings"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
 	"github.com/brightbox/gobrightbox/v2/enums/listenerprotocol"
+	"github.com/brightbox/gobrightbox/v2/enums/transportprotocol"
 	"github.com/brightbox/k8ssdk/v2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
@@ -29,102 +32,216 @@ import (
 var defaultRegionCidr = "10.0.0.0/8"
 var defaultIPv6RegionCidr = "2a02:1348:0140::/42"
 var defaultRuleProtocol = listenerprotocol.Tcp.String()
+var udpRuleProtocol = transportprotocol.Udp.String()
 
 // The approach is to create a separate server group, firewall policy
-// and firewall rule for each loadbalancer primarily to avoid any
+// and set of firewall rules for each loadbalancer primarily to avoid any
 // potential race conditions in the driver.
 // It also allows k8s to select subsets of nodes for each loadbalancer
-// created if it wants to.
-func (c *cloud) ensureFirewallOpenForService(ctx context.Context, name string, apiservice *v1.Service, nodes []*v1.Node) error {
-	klog.V(4).Infof("ensureFireWallOpen(%v)", name)
+// created if it wants to. A non-empty destination limits the rules to
+// traffic for that server or server group.
+func (c *cloud) ensureFirewallOpenForService(ctx context.Context, owned *ownedResources, apiservice *v1.Service, nodes []*v1.Node, destination string) error {
+	klog.V(4).Infof("ensureFireWallOpen(%v)", owned.group.Name)
 	if len(apiservice.Spec.Ports) <= 0 {
 		klog.V(4).Infof("no ports to open")
 		return nil
 	}
-	serverGroup, err := c.ensureServerGroup(ctx, name, nodes)
-	if err != nil {
+	if _, err := c.SyncServerGroup(ctx, owned.group, mapNodesToServerIDs(nodes)); err != nil {
 		return err
 	}
-	firewallPolicy, err := c.ensureFirewallPolicy(ctx, serverGroup)
+	firewallPolicy, err := c.ensureFirewallPolicy(ctx, owned)
 	if err != nil {
 		return err
 	}
-	return c.ensureFirewallRules(ctx, apiservice, firewallPolicy)
+	return c.ensureFirewallRules(ctx, apiservice, firewallPolicy, destination)
 }
 
-func (c *cloud) ensureServerGroup(ctx context.Context, name string, nodes []*v1.Node) (*brightbox.ServerGroup, error) {
-	klog.V(4).Infof("ensureServerGroup(%v)", name)
-	group, err := c.GetServerGroupByName(ctx, name)
+// ensureFirewallPolicy returns the policy applied to the service's
+// server group, creating it if need be. A policy built before ledgers
+// existed is given the owner's marker.
+func (c *cloud) ensureFirewallPolicy(ctx context.Context, owned *ownedResources) (*brightbox.FirewallPolicy, error) {
+	klog.V(4).Infof("ensureFireWallPolicy (%q)", owned.group.Name)
+	fp, err := c.ownedFirewallPolicy(ctx, owned)
 	if err != nil {
 		return nil, err
 	}
-	if group == nil {
-		group, err = c.CreateServerGroup(ctx, name)
-	}
+	description, err := owned.ledger.owner().description()
 	if err != nil {
 		return nil, err
 	}
-	group, err = c.SyncServerGroup(ctx, group, mapNodesToServerIDs(nodes))
-	if err == nil {
-		return group, nil
+	if fp == nil {
+		client, err := c.CloudClient()
+		if err != nil {
+			return nil, err
+		}
+		return client.CreateFirewallPolicy(ctx, brightbox.FirewallPolicyOptions{
+			Name:                     &owned.group.Name,
+			Description:              &description,
+			FirewallPolicyAttachment: &brightbox.FirewallPolicyAttachment{ServerGroup: owned.group.ID},
+		})
+	}
+	if fp.Description != description {
+		if _, err := c.updateFirewallPolicy(ctx, brightbox.FirewallPolicyOptions{ID: fp.ID, Description: &description}); err != nil {
+			return nil, err
+		}
+		fp.Description = description
 	}
-	return nil, err
+	return fp, nil
 }
 
-func (c *cloud) ensureFirewallPolicy(ctx context.Context, group *brightbox.ServerGroup) (*brightbox.FirewallPolicy, error) {
-	klog.V(4).Infof("ensureFireWallPolicy (%q)", group.Name)
-	fp, err := c.GetFirewallPolicyByName(ctx, group.Name)
-	if err != nil {
-		return nil, err
+// Each allowed source gets its own firewall rule for each protocol.
+// Existing rules with a matching source and protocol are kept, stale
+// rules are reused for any new ones and whatever is left over is
+// removed. The API can't clear a rule's destination, so rules with one
+// are only reused while a destination is wanted.
+func (c *cloud) ensureFirewallRules(ctx context.Context, apiservice *v1.Service, fp *brightbox.FirewallPolicy, destination string) error {
+	klog.V(4).Infof("ensureFireWallRules (%q, %q)", fp.Name, destination)
+	portLists := map[string]string{
+		defaultRuleProtocol: createPortListString(apiservice),
+		udpRuleProtocol:     createUDPPortListString(apiservice),
 	}
-	if fp == nil {
-		return c.CreateFirewallPolicy(ctx, *group)
+	var stale, unusable []brightbox.FirewallRule
+	for _, rule := range fp.Rules {
+		if rule.Destination != "" && destination == "" {
+			unusable = append(unusable, rule)
+		} else {
+			stale = append(stale, rule)
+		}
 	}
-	return fp, nil
+	var ruleDestination *string
+	if destination != "" {
+		ruleDestination = &destination
+	}
+	var pending []brightbox.FirewallRuleOptions
+	for _, source := range c.firewallRuleSources(apiservice) {
+		for _, protocol := range []string{defaultRuleProtocol, udpRuleProtocol} {
+			portListStr := portLists[protocol]
+			if portListStr == "" {
+				continue
+			}
+			newRule := brightbox.FirewallRuleOptions{
+				FirewallPolicy:  fp.ID,
+				Protocol:        &protocol,
+				Source:          &source,
+				Destination:     ruleDestination,
+				DestinationPort: &portListStr,
+				Description:     &fp.Name,
+			}
+			i := slices.IndexFunc(stale, func(rule brightbox.FirewallRule) bool {
+				return rule.Source == source && rule.Protocol == protocol
+			})
+			if i == -1 {
+				pending = append(pending, newRule)
+				continue
+			}
+			if isUpdateFirewallRuleRequired(stale[i], newRule) {
+				newRule.ID = stale[i].ID
+				if _, err := c.UpdateFirewallRule(ctx, newRule); err != nil {
+					return err
+				}
+				c.recordEvent(apiservice, eventFirewallRuleUpdated, "Updated firewall rule %s for %s", newRule.ID, source)
+			} else {
+				klog.V(4).Infof("No rule update required for %q, skipping", stale[i].ID)
+			}
+			stale = slices.Delete(stale, i, i+1)
+		}
+	}
+	for _, newRule := range pending {
+		if len(stale) > 0 {
+			newRule.ID = stale[0].ID
+			stale = stale[1:]
+			if _, err := c.UpdateFirewallRule(ctx, newRule); err != nil {
+				return err
+			}
+			c.recordEvent(apiservice, eventFirewallRuleUpdated, "Updated firewall rule %s for %s", newRule.ID, *newRule.Source)
+			continue
+		}
+		rule, err := c.CreateFirewallRule(ctx, newRule)
+		if err != nil {
+			return err
+		}
+		c.recordEvent(apiservice, eventFirewallRuleCreated, "Created firewall rule %s for %s", rule.ID, *newRule.Source)
+	}
+	for _, rule := range append(stale, unusable...) {
+		if err := c.destroyFirewallRule(ctx, rule.ID); err != nil {
+			klog.V(4).Infof("Error destroying Firewall Rule %q", rule.ID)
+			return err
+		}
+		c.recordEvent(apiservice, eventFirewallRuleDeleted, "Deleted firewall rule %s for %s", rule.ID, rule.Source)
+	}
+	return nil
 }
 
-func (c *cloud) ensureFirewallRules(ctx context.Context, apiservice *v1.Service, fp *brightbox.FirewallPolicy) error {
-	klog.V(4).Infof("ensureFireWallRules (%q)", fp.Name)
-	portListStr := createPortListString(apiservice)
-	newRule := brightbox.FirewallRuleOptions{
-		FirewallPolicy:  fp.ID,
-		Protocol:        &defaultRuleProtocol,
-		Source:          &defaultRegionCidr,
-		DestinationPort: &portListStr,
-		Description:     &fp.Name,
-	}
-	if len(fp.Rules) == 0 {
-		_, err := c.CreateFirewallRule(ctx, newRule)
-		return err
-	} else if isUpdateFirewallRuleRequired(fp.Rules[0], newRule) {
-		newRule.ID = fp.Rules[0].ID
-		_, err := c.UpdateFirewallRule(ctx, newRule)
-		return err
+// firewallRuleSources returns the ranges allowed to reach the node
+// ports. A load balancer reaches the nodes from the region's private
+// network, and its clients never reach the nodes at all, so in load
+// balancer mode the rule always covers the region. Validation rejects
+// source ranges there. Only Cloud IP mode, where clients reach the node
+// directly, narrows the rule to the service's source ranges, and there
+// the rule only covers the Cloud IP's target.
+func (c *cloud) firewallRuleSources(apiservice *v1.Service) []string {
+	if c.serviceMode(apiservice) == modeCloudIP {
+		return getFirewallRuleSources(apiservice, publicSourceCIDRs)
 	}
-	klog.V(4).Infof("No rule update required for %q, skipping", fp.Rules[0].ID)
-	return nil
+	return c.config.regionCIDRs()
+}
+
+// getFirewallRuleSources returns the canonical form of the source
+// ranges listed in the service, or the default ranges if there are
+// none. Validation has already rejected any invalid entries.
+func getFirewallRuleSources(apiservice *v1.Service, defaultSources []string) []string {
+	result := make([]string, 0, len(apiservice.Spec.LoadBalancerSourceRanges))
+	for _, sourceRange := range apiservice.Spec.LoadBalancerSourceRanges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(sourceRange))
+		if err != nil {
+			klog.V(4).Infof("Unexpected source range %q", sourceRange)
+			continue
+		}
+		result = append(result, ipNet.String())
+	}
+	if len(result) == 0 {
+		return defaultSources
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
 }
 
 func isUpdateFirewallRuleRequired(old brightbox.FirewallRule, new brightbox.FirewallRuleOptions) bool {
 	return (new.Protocol != nil && *new.Protocol != old.Protocol) ||
 		(new.Source != nil && *new.Source != old.Source) ||
+		(new.Destination != nil && *new.Destination != old.Destination) ||
 		(new.DestinationPort != nil && *new.DestinationPort != old.DestinationPort) ||
 		(new.Description != nil && *new.Description != old.Description)
 }
 
+// createPortListString lists the TCP node ports of the service,
+// including the health check node port
 func createPortListString(apiservice *v1.Service) string {
-	var buffer bytes.Buffer
-	ports := apiservice.Spec.Ports
-	buffer.WriteString(strconv.Itoa(int(ports[0].NodePort)))
-	for i := range ports[1:] {
-		buffer.WriteString(",")
-		buffer.WriteString(strconv.Itoa(int(ports[i+1].NodePort)))
-	}
+	ports := nodePortList(apiservice, func(protocol v1.Protocol) bool {
+		return protocol != v1.ProtocolUDP
+	})
 	if apiservice.Spec.HealthCheckNodePort != 0 {
-		buffer.WriteString(",")
-		buffer.WriteString(strconv.Itoa(int(apiservice.Spec.HealthCheckNodePort)))
+		ports = append(ports, strconv.Itoa(int(apiservice.Spec.HealthCheckNodePort)))
+	}
+	return strings.Join(ports, ",")
+}
+
+// createUDPPortListString lists the UDP node ports of the service,
+// which only services in Cloud IP mode have
+func createUDPPortListString(apiservice *v1.Service) string {
+	return strings.Join(nodePortList(apiservice, func(protocol v1.Protocol) bool {
+		return protocol == v1.ProtocolUDP
+	}), ",")
+}
+
+func nodePortList(apiservice *v1.Service, match func(v1.Protocol) bool) []string {
+	result := make([]string, 0, len(apiservice.Spec.Ports))
+	for _, port := range apiservice.Spec.Ports {
+		if match(port.Protocol) {
+			result = append(result, strconv.Itoa(int(port.NodePort)))
+		}
 	}
-	return buffer.String()
+	return result
 }
 
 func mapNodesToServerIDs(nodes []*v1.Node) []string {
@@ -139,20 +256,13 @@ func mapNodesToServerIDs(nodes []*v1.Node) []string {
 	return result
 }
 
-// Take all the servers out of the server group and remove it
-func (c *cloud) ensureServerGroupDeleted(ctx context.Context, name string) error {
-	klog.V(4).Infof("ensureServerGroupDeleted (%q)", name)
-	group, err := c.GetServerGroupByName(ctx, name)
-	if err != nil {
-		klog.V(4).Infof("Error looking for Server Group for %q", name)
-		return err
-	}
-	if group == nil {
-		return nil
-	}
-	group, err = c.SyncServerGroup(ctx, group, nil)
+// Take all the servers out of the server group and remove it. The
+// group holds the ledger, so it goes last.
+func (c *cloud) ensureServerGroupDeleted(ctx context.Context, owned *ownedResources) error {
+	klog.V(4).Infof("ensureServerGroupDeleted (%q)", owned.group.Name)
+	group, err := c.SyncServerGroup(ctx, owned.group, nil)
 	if err != nil {
-		klog.V(4).Infof("Error removing servers from %q", group.ID)
+		klog.V(4).Infof("Error removing servers from %q", owned.group.ID)
 		return err
 	}
 	if err := c.DestroyServerGroup(ctx, group.ID); err != nil {
@@ -163,11 +273,11 @@ func (c *cloud) ensureServerGroupDeleted(ctx context.Context, name string) error
 }
 
 // Remove the firewall policy
-func (c *cloud) ensureFirewallClosed(ctx context.Context, name string) error {
-	klog.V(4).Infof("ensureFirewallClosed (%q)", name)
-	fp, err := c.GetFirewallPolicyByName(ctx, name)
+func (c *cloud) ensureFirewallClosed(ctx context.Context, owned *ownedResources) error {
+	klog.V(4).Infof("ensureFirewallClosed (%q)", owned.group.Name)
+	fp, err := c.ownedFirewallPolicy(ctx, owned)
 	if err != nil {
-		klog.V(4).Infof("Error looking for Firewall Policy %q", name)
+		klog.V(4).Infof("Error looking for Firewall Policy %q", owned.group.Name)
 		return err
 	}
 	if fp == nil {
@@ -29,11 +29,15 @@ import (
 	"github.com/brightbox/gobrightbox/v2/enums/loadbalancerstatus"
 	"github.com/brightbox/gobrightbox/v2/enums/proxyprotocol"
 	"github.com/brightbox/k8ssdk/v2"
+	"github.com/brightbox/k8ssdk/v2/mocks"
 	"github.com/go-test/deep"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -84,12 +88,34 @@ var (
 )
 
 func TestLoadBalancerStatus(t *testing.T) {
+	twoCloudIPs := &brightbox.LoadBalancer{
+		CloudIPs: []brightbox.CloudIP{
+			brightbox.CloudIP{
+				ID:         publicCipID2,
+				PublicIPv4: publicIP2,
+				PublicIPv6: publicIPv62,
+				ReverseDNS: "",
+				Fqdn:       fqdn2,
+				Name:       "manually allocated",
+			},
+			brightbox.CloudIP{
+				ID:         publicCipID,
+				PublicIPv4: publicIP,
+				PublicIPv6: publicIPv6,
+				ReverseDNS: reverseDNS,
+				Fqdn:       fqdn,
+			},
+		},
+	}
+	proxy := v1.LoadBalancerIPModeProxy
 	testCases := map[string]struct {
 		lb     *brightbox.LoadBalancer
+		mode   string
 		status *v1.LoadBalancerStatus
 	}{
 		"no-cloudip": {
 			lb:     &brightbox.LoadBalancer{},
+			mode:   statusModeBoth,
 			status: &v1.LoadBalancerStatus{},
 		},
 		"one-cloudip": {
@@ -104,16 +130,9 @@ func TestLoadBalancerStatus(t *testing.T) {
 					},
 				},
 			},
+			mode: statusModeHostname,
 			status: &v1.LoadBalancerStatus{
 				Ingress: []v1.LoadBalancerIngress{
-					/*
-						v1.LoadBalancerIngress{
-							IP: publicIP,
-						},
-						v1.LoadBalancerIngress{
-							IP: publicIPv6,
-						},
-					*/
 					v1.LoadBalancerIngress{
 						Hostname: reverseDNS,
 					},
@@ -124,46 +143,70 @@ func TestLoadBalancerStatus(t *testing.T) {
 			},
 		},
 		"two-cloudips": {
-			lb: &brightbox.LoadBalancer{
-				CloudIPs: []brightbox.CloudIP{
-					brightbox.CloudIP{
-						ID:         publicCipID2,
-						PublicIPv4: publicIP2,
-						PublicIPv6: publicIPv62,
-						ReverseDNS: "",
-						Fqdn:       fqdn2,
-						Name:       "manually allocated",
+			lb:   twoCloudIPs,
+			mode: statusModeHostname,
+			status: &v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{
+					v1.LoadBalancerIngress{
+						Hostname: fqdn2,
 					},
-					brightbox.CloudIP{
-						ID:         publicCipID,
-						PublicIPv4: publicIP,
-						PublicIPv6: publicIPv6,
-						ReverseDNS: reverseDNS,
-						Fqdn:       fqdn,
+					v1.LoadBalancerIngress{
+						Hostname: reverseDNS,
+					},
+					v1.LoadBalancerIngress{
+						Hostname: fqdn,
 					},
 				},
 			},
+		},
+		"two-cloudips-ip": {
+			lb:   twoCloudIPs,
+			mode: statusModeIP,
 			status: &v1.LoadBalancerStatus{
 				Ingress: []v1.LoadBalancerIngress{
-					/*
-						v1.LoadBalancerIngress{
-							IP: publicIP2,
-						},
-						v1.LoadBalancerIngress{
-							IP: publicIPv62,
-						},
-					*/
+					v1.LoadBalancerIngress{
+						IP:     publicIP2,
+						IPMode: &proxy,
+					},
+					v1.LoadBalancerIngress{
+						IP:     publicIPv62,
+						IPMode: &proxy,
+					},
+					v1.LoadBalancerIngress{
+						IP:     publicIP,
+						IPMode: &proxy,
+					},
+					v1.LoadBalancerIngress{
+						IP:     publicIPv6,
+						IPMode: &proxy,
+					},
+				},
+			},
+		},
+		"two-cloudips-both": {
+			lb:   twoCloudIPs,
+			mode: statusModeBoth,
+			status: &v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{
+					v1.LoadBalancerIngress{
+						IP:     publicIP2,
+						IPMode: &proxy,
+					},
+					v1.LoadBalancerIngress{
+						IP:     publicIPv62,
+						IPMode: &proxy,
+					},
 					v1.LoadBalancerIngress{
 						Hostname: fqdn2,
 					},
-					/*
-						v1.LoadBalancerIngress{
-							IP: publicIP,
-						},
-						v1.LoadBalancerIngress{
-							IP: publicIPv6,
-						},
-					*/
+					v1.LoadBalancerIngress{
+						IP:     publicIP,
+						IPMode: &proxy,
+					},
+					v1.LoadBalancerIngress{
+						IP:     publicIPv6,
+						IPMode: &proxy,
+					},
 					v1.LoadBalancerIngress{
 						Hostname: reverseDNS,
 					},
@@ -176,7 +219,7 @@ func TestLoadBalancerStatus(t *testing.T) {
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			result := toLoadBalancerStatus(tc.lb)
+			result := toLoadBalancerStatus(tc.lb, tc.mode)
 			if diff := deep.Equal(result, tc.status); diff != nil {
 				t.Error(diff)
 			}
@@ -185,6 +228,43 @@ func TestLoadBalancerStatus(t *testing.T) {
 
 }
 
+func TestGetStatusMode(t *testing.T) {
+	testCases := map[string]struct {
+		annotations map[string]string
+		config      string
+		mode        string
+	}{
+		"default": {
+			mode: statusModeHostname,
+		},
+		"config": {
+			config: statusModeBoth,
+			mode:   statusModeBoth,
+		},
+		"annotation": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerStatusMode: statusModeIP,
+			},
+			config: statusModeBoth,
+			mode:   statusModeIP,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := makeFakeInstanceCloudClient()
+			client.config.StatusMode = tc.config
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tc.annotations,
+				},
+			}
+			if mode := client.getStatusMode(service); mode != tc.mode {
+				t.Errorf("Expected %q, got %q", tc.mode, mode)
+			}
+		})
+	}
+}
+
 func TestErrorIfAcmeNotComplete(t *testing.T) {
 	testCases := map[string]struct {
 		acme   *brightbox.LoadBalancerAcme
@@ -200,53 +280,351 @@ func TestErrorIfAcmeNotComplete(t *testing.T) {
 					},
 				},
 			},
-			status: "Domain \"" + missingDomain + "\" has not yet been validated for SSL use (\"invalid\":\"failed to resolve\")",
+			status: "Domain \"" + missingDomain + "\" has not yet been validated for SSL use (\"invalid\":\"failed to resolve\")",
+		},
+		"just_one_domain_invalid": {
+			acme: &brightbox.LoadBalancerAcme{
+				Domains: []brightbox.LoadBalancerAcmeDomain{
+					{
+						Identifier: resolvedDomain,
+						Status:     k8ssdk.ValidAcmeDomainStatus,
+					},
+					{
+						Identifier:  missingDomain,
+						Status:      "invalid",
+						LastMessage: "failed to resolve",
+					},
+				},
+			},
+			status: "Domain \"" + missingDomain + "\" has not yet been validated for SSL use (\"invalid\":\"failed to resolve\")",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := k8ssdk.ErrorIfAcmeNotComplete(tc.acme)
+			if err == nil {
+				t.Errorf("Expected error %q got nil", tc.status)
+			} else if err.Error() != tc.status {
+				t.Errorf("Expected %q, got %q", tc.status, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	testCases := map[string]struct {
+		service *v1.Service
+		status  string
+	}{
+		"session affinity": {
+			service: &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						v1.ServicePort{},
+					},
+					SessionAffinity: "Sticky",
+				},
+			},
+			status: "unsupported load balancer affinity: Sticky",
+		},
+		"client-ip-affinity": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerPolicy: balancingpolicy.SourceAddress.String(),
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityClientIP,
+				},
+			},
+			status: "",
+		},
+		"client-ip-affinity-policy-conflict": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerPolicy: balancingpolicy.RoundRobin.String(),
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						v1.ServicePort{},
+					},
+					SessionAffinity: v1.ServiceAffinityClientIP,
+				},
+			},
+			status: fmt.Sprintf("ClientIP session affinity requires the \"source-address\" load balancer policy, but %q is set to \"round-robin\"", serviceAnnotationLoadBalancerPolicy),
+		},
+		"certificate-secret": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerSSLCertificateSecret: "tls-secret",
+						serviceAnnotationLoadBalancerSSLPorts:             "443,8443",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "",
+		},
+		"certificate-secret-no-ssl-port": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerSSLCertificateSecret: "tls-secret",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "SSL support requires a Port definition for 443",
+		},
+		"certificate-secret-and-domains": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerSSLCertificateSecret: "tls-secret",
+						serviceAnnotationLoadBalancerSslDomains:           "example.com",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: fmt.Sprintf("%q cannot be used with %q", serviceAnnotationLoadBalancerSSLCertificateSecret, serviceAnnotationLoadBalancerSslDomains),
+		},
+		"certificate-secret-tcp": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerSSLCertificateSecret: "tls-secret",
+						serviceAnnotationLoadBalancerListenerProtocol:     listenerprotocol.Tcp.String(),
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "SSL Certificates are not supported with the tcp protocol",
+		},
+		"invalid-certificate-secret": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerSSLCertificateSecret: "TLS_Secret",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: fmt.Sprintf("%q needs to be a valid Secret name: %s", serviceAnnotationLoadBalancerSSLCertificateSecret, strings.Join(validation.IsDNS1123Subdomain("TLS_Secret"), ", ")),
+		},
+		"per-port-listeners": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProtocols:      "postgres=tcp",
+						serviceAnnotationLoadBalancerListenerPortProxyProtocols: "5432=v2",
+						serviceAnnotationLoadBalancerListenerPortIdleTimeouts:   "postgres=60000",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "",
+		},
+		"per-port-unknown-port": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProtocols: "mysql=tcp",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: fmt.Sprintf("%q refers to port \"mysql\", which the service does not define", serviceAnnotationLoadBalancerListenerPortProtocols),
+		},
+		"per-port-malformed": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortIdleTimeouts: "postgres",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: fmt.Sprintf("%q is invalid: \"postgres\" needs to be in the form port=value", serviceAnnotationLoadBalancerListenerPortIdleTimeouts),
+		},
+		"per-port-invalid-protocol": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProtocols: "postgres=udp",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: fmt.Sprintf("%q is invalid: udp is not a valid listenerprotocol.Enum", serviceAnnotationLoadBalancerListenerPortProtocols),
 		},
-		"just_one_domain_invalid": {
-			acme: &brightbox.LoadBalancerAcme{
-				Domains: []brightbox.LoadBalancerAcmeDomain{
-					{
-						Identifier: resolvedDomain,
-						Status:     k8ssdk.ValidAcmeDomainStatus,
+		"per-port-invalid-proxy-protocol": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProxyProtocols: "postgres=v3",
 					},
-					{
-						Identifier:  missingDomain,
-						Status:      "invalid",
-						LastMessage: "failed to resolve",
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
 					},
+					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "Domain \"" + missingDomain + "\" has not yet been validated for SSL use (\"invalid\":\"failed to resolve\")",
+			status: fmt.Sprintf("%q is invalid: v3 is not a valid proxyprotocol.Enum", serviceAnnotationLoadBalancerListenerPortProxyProtocols),
 		},
-	}
-	for name, tc := range testCases {
-		t.Run(name, func(t *testing.T) {
-			err := k8ssdk.ErrorIfAcmeNotComplete(tc.acme)
-			if err == nil {
-				t.Errorf("Expected error %q got nil", tc.status)
-			} else if err.Error() != tc.status {
-				t.Errorf("Expected %q, got %q", tc.status, err.Error())
-			}
-		})
-	}
-}
-
-func TestValidateService(t *testing.T) {
-	testCases := map[string]struct {
-		service *v1.Service
-		status  string
-	}{
-		"session affinity": {
+		"per-port-invalid-timeout": {
 			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortIdleTimeouts: "5432=-1",
+					},
+				},
 				Spec: v1.ServiceSpec{
 					Type: v1.ServiceTypeLoadBalancer,
 					Ports: []v1.ServicePort{
-						v1.ServicePort{},
+						{
+							Name:       "postgres",
+							Protocol:   v1.ProtocolTCP,
+							Port:       5432,
+							TargetPort: intstr.FromInt(5432),
+							NodePort:   31347,
+						},
 					},
-					SessionAffinity: v1.ServiceAffinityClientIP,
+					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "unsupported load balancer affinity: ClientIP",
+			status: fmt.Sprintf("%q is invalid: strconv.ParseUint: parsing \"-1\": invalid syntax", serviceAnnotationLoadBalancerListenerPortIdleTimeouts),
 		},
 		"empty ports": {
 			service: &v1.Service{
@@ -566,6 +944,90 @@ func TestValidateService(t *testing.T) {
 			},
 			status: "SSL support requires a Port definition for 443",
 		},
+		"domains with a tcp listener on port 443": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProtocols: "https=tcp",
+						serviceAnnotationLoadBalancerSslDomains:            resolvedDomain,
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+						{
+							Name:       "https",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8443),
+							NodePort:   31348,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "SSL support requires a Port definition for 443",
+		},
+		"domains with an http listener on a tcp service": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerProtocol:      listenerprotocol.Tcp.String(),
+						serviceAnnotationLoadBalancerListenerPortProtocols: "http=http",
+						serviceAnnotationLoadBalancerSslDomains:            resolvedDomain,
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "SSL support requires a Port definition for 443",
+		},
+		"ssl ports on a service with only tcp listeners": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerPortProtocols: "http=tcp",
+						serviceAnnotationLoadBalancerSSLPorts:              "http",
+						serviceAnnotationLoadBalancerSslDomains:            resolvedDomain,
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "",
+		},
 		"invalid-listener-protocol": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
@@ -590,12 +1052,134 @@ func TestValidateService(t *testing.T) {
 			},
 			status: "Invalid Load Balancer Listener Protocol \"gopher\": gopher is not a valid listenerprotocol.Enum",
 		},
-		"invalid-healthcheck-request": {
+		"invalid-healthcheck-request": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerHCRequest: "fred",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "\"" + serviceAnnotationLoadBalancerHCRequest + "\" needs to be a valid Url request path",
+		},
+		"invalid-healthcheck-protocol": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerHCProtocol: listenerprotocol.Https.String(),
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "Invalid Load Balancer Healthcheck Protocol \"" + listenerprotocol.Https.String() + "\": " +
+				listenerprotocol.Https.String() + " is not a valid healthchecktype.Enum",
+		},
+		"https without domains": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerListenerProtocol: listenerprotocol.Http.String(),
+						serviceAnnotationLoadBalancerSSLPorts:         "443",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       443,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "SSL needs a list of domains to certify or a certificate secret. Add the \"" + serviceAnnotationLoadBalancerSslDomains + "\" or \"" + serviceAnnotationLoadBalancerSSLCertificateSecret + "\" annotation",
+		},
+		"invalid-uint-negative": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerHCInterval: "-1",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "\"" + serviceAnnotationLoadBalancerHCInterval + "\" needs to be a positive number (strconv.ParseUint: parsing \"-1\": invalid syntax)",
+		},
+		"invalid-uint-alpha": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: newUID,
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerHCInterval: "0x56",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{
+						{
+							Name:       "http",
+							Protocol:   v1.ProtocolTCP,
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+							NodePort:   31347,
+						},
+					},
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			},
+			status: "\"" + serviceAnnotationLoadBalancerHCInterval + "\" needs to be a positive number (strconv.ParseUint: parsing \"0x56\": invalid syntax)",
+		},
+		"invalid-uint-too-big": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerHCRequest: "fred",
+						serviceAnnotationLoadBalancerHCTimeout: strconv.Itoa(tooBigInt),
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -612,14 +1196,15 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "\"" + serviceAnnotationLoadBalancerHCRequest + "\" needs to be a valid Url request path",
+			status: "\"" + serviceAnnotationLoadBalancerHCTimeout + "\" needs to be a positive number (strconv.ParseUint: parsing \"" +
+				strconv.Itoa(tooBigInt) + "\": value out of range)",
 		},
-		"invalid-healthcheck-protocol": {
+		"invalid-cloudip-allocations-format": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerHCProtocol: listenerprotocol.Https.String(),
+						serviceAnnotationLoadBalancerCloudipAllocations: badCipID,
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -636,16 +1221,14 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "Invalid Load Balancer Healthcheck Protocol \"" + listenerprotocol.Https.String() + "\": " +
-				listenerprotocol.Https.String() + " is not a valid healthchecktype.Enum",
+			status: fmt.Sprintf("%q needs to match the pattern %q", serviceAnnotationLoadBalancerCloudipAllocations, cloudIPPattern),
 		},
-		"https without domains": {
+		"load-balancer-name-too-long": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerListenerProtocol: listenerprotocol.Http.String(),
-						serviceAnnotationLoadBalancerSSLPorts:         "443",
+						serviceAnnotationLoadBalancerName: strings.Repeat("a", maxLoadBalancerNameLength+1),
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -654,7 +1237,7 @@ func TestValidateService(t *testing.T) {
 						{
 							Name:       "http",
 							Protocol:   v1.ProtocolTCP,
-							Port:       443,
+							Port:       80,
 							TargetPort: intstr.FromInt(8080),
 							NodePort:   31347,
 						},
@@ -662,14 +1245,14 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "SSL needs a list of domains to certify. Add the \"" + serviceAnnotationLoadBalancerSslDomains + "\" annotation",
+			status: fmt.Sprintf("%q needs to be between 1 and %d characters long", serviceAnnotationLoadBalancerName, maxLoadBalancerNameLength),
 		},
-		"invalid-uint-negative": {
+		"bad-mode": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerHCInterval: "-1",
+						serviceAnnotationLoadBalancerMode: "nat",
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -686,14 +1269,14 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "\"" + serviceAnnotationLoadBalancerHCInterval + "\" needs to be a positive number (strconv.ParseUint: parsing \"-1\": invalid syntax)",
+			status: fmt.Sprintf("%q needs to be one of %v", serviceAnnotationLoadBalancerMode, validModeNames),
 		},
-		"invalid-uint-alpha": {
+		"bad-cloud-ip-target": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerHCInterval: "0x56",
+						serviceAnnotationLoadBalancerCloudIPTarget: "pool",
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -710,14 +1293,14 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: "\"" + serviceAnnotationLoadBalancerHCInterval + "\" needs to be a positive number (strconv.ParseUint: parsing \"0x56\": invalid syntax)",
+			status: fmt.Sprintf("%q needs to be one of %v", serviceAnnotationLoadBalancerCloudIPTarget, validCloudIPTargets),
 		},
-		"invalid-uint-too-big": {
+		"cloudip-allocation-conflict-spec-loadbalancerip": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerHCTimeout: strconv.Itoa(tooBigInt),
+						serviceAnnotationLoadBalancerCloudipAllocations: publicCipID,
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -732,17 +1315,17 @@ func TestValidateService(t *testing.T) {
 						},
 					},
 					SessionAffinity: v1.ServiceAffinityNone,
+					LoadBalancerIP:  publicIP,
 				},
 			},
-			status: "\"" + serviceAnnotationLoadBalancerHCTimeout + "\" needs to be a positive number (strconv.ParseUint: parsing \"" +
-				strconv.Itoa(tooBigInt) + "\": value out of range)",
+			status: "Remove obsolete field: spec.loadBalancerIP",
 		},
-		"invalid-cloudip-allocations-format": {
+		"invalid-status-mode": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
 					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerCloudipAllocations: badCipID,
+						serviceAnnotationLoadBalancerStatusMode: "hostnames",
 					},
 				},
 				Spec: v1.ServiceSpec{
@@ -759,15 +1342,12 @@ func TestValidateService(t *testing.T) {
 					SessionAffinity: v1.ServiceAffinityNone,
 				},
 			},
-			status: fmt.Sprintf("%q needs to match the pattern %q", serviceAnnotationLoadBalancerCloudipAllocations, cloudIPPattern),
+			status: fmt.Sprintf("%q needs to be one of [hostname ip both]", serviceAnnotationLoadBalancerStatusMode),
 		},
-		"cloudip-allocation-conflict-spec-loadbalancerip": {
+		"invalid-source-range": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: newUID,
-					Annotations: map[string]string{
-						serviceAnnotationLoadBalancerCloudipAllocations: publicCipID,
-					},
 				},
 				Spec: v1.ServiceSpec{
 					Type: v1.ServiceTypeLoadBalancer,
@@ -780,11 +1360,11 @@ func TestValidateService(t *testing.T) {
 							NodePort:   31347,
 						},
 					},
-					SessionAffinity: v1.ServiceAffinityNone,
-					LoadBalancerIP:  publicIP,
+					SessionAffinity:          v1.ServiceAffinityNone,
+					LoadBalancerSourceRanges: []string{"192.168.0.0/16"},
 				},
 			},
-			status: "Remove obsolete field: spec.loadBalancerIP",
+			status: fmt.Sprintf("Brightbox load balancers can't restrict clients. Remove spec.loadBalancerSourceRanges, or set %q to %q to use a Cloud IP that can", serviceAnnotationLoadBalancerMode, modeNameCloudIP),
 		},
 	}
 	for name, tc := range testCases {
@@ -838,7 +1418,7 @@ func TestEnsureDomainResolution(t *testing.T) {
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			_, err := ensureLoadBalancerDomainResolution(tc.annotations, tc.cloudIP)
+			_, err := ensureLoadBalancerDomainResolution(context.TODO(), testResolver, tc.annotations, tc.cloudIP)
 			if err == nil {
 				t.Errorf("Expected error %q got nil", tc.status)
 			} else if !strings.HasPrefix(err.Error(), tc.status) {
@@ -848,6 +1428,104 @@ func TestEnsureDomainResolution(t *testing.T) {
 	}
 }
 
+func TestGetLoadBalancerName(t *testing.T) {
+	longService := strings.Repeat("s", 63)
+	testCases := map[string]struct {
+		service  *v1.Service
+		expected string
+	}{
+		"short": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			},
+			expected: "web.default.kubernetes",
+		},
+		"recorded": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web",
+					Namespace:   "default",
+					Annotations: map[string]string{serviceAnnotationLoadBalancerName: "web.default.old-cluster"},
+				},
+			},
+			expected: "web.default.old-cluster",
+		},
+		"too long": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: longService, Namespace: strings.Repeat("n", 63)},
+			},
+			expected: longService[:55] + "-7b5ee58f",
+		},
+		"too long, same prefix": {
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: longService, Namespace: strings.Repeat("n", 62)},
+			},
+			expected: longService[:55] + "-8ee257a7",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &cloud{}
+			result := client.GetLoadBalancerName(context.TODO(), "kubernetes", tc.service)
+			if diff := deep.Equal(result, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerNameKeepsLegacyName(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("s", 63), Namespace: "default", UID: "uid-long"},
+	}
+	legacy := legacyLoadBalancerName("kubernetes", service)
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	name, err := client.loadBalancerName(context.TODO(), "kubernetes", service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(name, client.GetLoadBalancerName(context.TODO(), "kubernetes", service)); diff != nil {
+		t.Error(diff)
+	}
+	// A server group built with the long name by an earlier release
+	fake.groups = append(fake.groups, brightbox.ServerGroup{ID: "grp-long", Name: legacy})
+	name, err = client.loadBalancerName(context.TODO(), "kubernetes", service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(name, legacy); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRecordLoadBalancerName(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	kubeClient := fake.NewSimpleClientset(service)
+	client := &cloud{kubeClient: kubeClient}
+	if err := client.recordLoadBalancerName(context.TODO(), service, "web.default.kubernetes"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := kubeClient.CoreV1().Services("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(result.Annotations[serviceAnnotationLoadBalancerName], "web.default.kubernetes"); diff != nil {
+		t.Error(diff)
+	}
+	// Nothing is written once the name is recorded
+	kubeClient.ClearActions()
+	if err := client.recordLoadBalancerName(context.TODO(), result, "web.default.kubernetes"); err != nil {
+		t.Fatal(err)
+	}
+	if len(kubeClient.Actions()) != 0 {
+		t.Errorf("expected no patch, got %v", kubeClient.Actions())
+	}
+}
+
 func TestGetLoadBalancer(t *testing.T) {
 	testCases := map[string]struct {
 		service  *v1.Service
@@ -1518,7 +2196,7 @@ func TestBuildLoadBalancerOptions(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			client := makeFakeInstanceCloudClient()
 			desc := client.GetLoadBalancerName(context.TODO(), clusterName, tc.service)
-			domains, err := ensureLoadBalancerDomainResolution(tc.service.Annotations, &resolvCip)
+			domains, err := ensureLoadBalancerDomainResolution(context.TODO(), testResolver, tc.service.Annotations, &resolvCip)
 			if err != nil {
 				t.Errorf("Error when not expected: %q", err.Error())
 			}
@@ -1531,6 +2209,154 @@ func TestBuildLoadBalancerOptions(t *testing.T) {
 	}
 }
 
+func TestClientIPAffinityPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		affinity v1.ServiceAffinity
+		policy   balancingpolicy.Enum
+	}{
+		"none": {
+			affinity: v1.ServiceAffinityNone,
+			policy:   testPolicy,
+		},
+		"client-ip": {
+			affinity: v1.ServiceAffinityClientIP,
+			policy:   balancingpolicy.SourceAddress,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						serviceAnnotationLoadBalancerPolicy: testPolicy.String(),
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type:            v1.ServiceTypeLoadBalancer,
+					SessionAffinity: tc.affinity,
+				},
+			}
+			lbopts := buildLoadBalancerOptions(lbname, nil, service, nil)
+			if lbopts.Policy != tc.policy {
+				t.Errorf("Expected %q, got %q", tc.policy, lbopts.Policy)
+			}
+		})
+	}
+}
+
+func TestBuildLoadBalancerListeners(t *testing.T) {
+	ports := []v1.ServicePort{
+		{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 31080},
+		{Name: "postgres", Protocol: v1.ProtocolTCP, Port: 5432, NodePort: 31432},
+		{Name: "ws", Protocol: v1.ProtocolTCP, Port: 8080, NodePort: 31880},
+	}
+	testCases := map[string]struct {
+		annotations map[string]string
+		expected    []brightbox.LoadBalancerListener
+	}{
+		"service-wide": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerListenerIdleTimeout:   "5000",
+				serviceAnnotationLoadBalancerListenerProxyProtocol: proxyprotocol.V1.String(),
+			},
+			expected: []brightbox.LoadBalancerListener{
+				{Protocol: listenerprotocol.Http, In: 80, Out: 31080, Timeout: 5000, ProxyProtocol: proxyprotocol.V1},
+				{Protocol: listenerprotocol.Http, In: 5432, Out: 31432, Timeout: 5000, ProxyProtocol: proxyprotocol.V1},
+				{Protocol: listenerprotocol.Http, In: 8080, Out: 31880, Timeout: 5000, ProxyProtocol: proxyprotocol.V1},
+			},
+		},
+		"per-port": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerListenerIdleTimeout:        "5000",
+				serviceAnnotationLoadBalancerListenerPortProtocols:      "postgres=tcp",
+				serviceAnnotationLoadBalancerListenerPortProxyProtocols: "5432=v2",
+				serviceAnnotationLoadBalancerListenerPortIdleTimeouts:   "ws=3600000",
+			},
+			expected: []brightbox.LoadBalancerListener{
+				{Protocol: listenerprotocol.Http, In: 80, Out: 31080, Timeout: 5000},
+				{Protocol: listenerprotocol.Tcp, In: 5432, Out: 31432, Timeout: 5000, ProxyProtocol: proxyprotocol.V2},
+				{Protocol: listenerprotocol.Http, In: 8080, Out: 31880, Timeout: 3600000},
+			},
+		},
+		"number-beats-name": {
+			annotations: map[string]string{
+				serviceAnnotationLoadBalancerListenerProtocol:      listenerprotocol.Tcp.String(),
+				serviceAnnotationLoadBalancerListenerPortProtocols: "http=tcp, 80=http",
+			},
+			expected: []brightbox.LoadBalancerListener{
+				{Protocol: listenerprotocol.Http, In: 80, Out: 31080},
+				{Protocol: listenerprotocol.Tcp, In: 5432, Out: 31432},
+				{Protocol: listenerprotocol.Tcp, In: 8080, Out: 31880},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tc.annotations,
+				},
+				Spec: v1.ServiceSpec{
+					Type:            v1.ServiceTypeLoadBalancer,
+					Ports:           ports,
+					SessionAffinity: v1.ServiceAffinityNone,
+				},
+			}
+			if err := validateServiceSpec(service); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(buildLoadBalancerListeners(service), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestGetPortMap(t *testing.T) {
+	testCases := map[string]struct {
+		annotation string
+		expected   *portMap
+		status     string
+	}{
+		"empty": {},
+		"mixed": {
+			annotation: "80=http, postgres = tcp",
+			expected: &portMap{
+				names:   map[string]string{"postgres": "tcp"},
+				numbers: map[int64]string{80: "http"},
+			},
+		},
+		"missing-value": {
+			annotation: "80=http,5432",
+			status:     `"5432" needs to be in the form port=value`,
+		},
+		"duplicate": {
+			annotation: "80=http,80=tcp",
+			status:     `port "80" is listed more than once`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ports, err := getPortMap(tc.annotation)
+			if tc.status != "" {
+				if err == nil {
+					t.Fatalf("expected error %q", tc.status)
+				}
+				if diff := deep.Equal(err.Error(), tc.status); diff != nil {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(ports, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
 func TestEnsureAndUpdateLoadBalancer(t *testing.T) {
 	testCases := map[string]struct {
 		service *v1.Service
@@ -1834,7 +2660,11 @@ func TestBuildEnsureLoadBalancer(t *testing.T) {
 
 			ctx := context.Background()
 			desc := client.GetLoadBalancerName(ctx, clusterName, tc.service)
-			lbopts, err := client.ensureLoadBalancerFromService(ctx, desc, nil, tc.service, tc.nodes)
+			owned, err := client.ensureOwnedResources(ctx, desc, clusterName, tc.service)
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			lbopts, err := client.ensureLoadBalancerFromService(ctx, owned, nil, nil, tc.service, tc.nodes)
 			if err != nil {
 				t.Errorf("Error when not expected")
 			} else if diff := deep.Equal(lbopts, tc.lbopts); diff != nil {
@@ -3184,7 +4014,7 @@ func TestEnsureAllocatedCloudIP(t *testing.T) {
 			},
 			cip: nil,
 		},
-		"No LBIP, no name, but ip mapped": {
+		"No LBIP, ip mapped to an unowned load balancer": {
 			service: &v1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					UID: premappedUID,
@@ -3206,12 +4036,10 @@ func TestEnsureAllocatedCloudIP(t *testing.T) {
 				},
 			},
 			cip: &brightbox.CloudIP{
-				ID:         premappedCipID,
-				PublicIPv4: premappedIP,
-				LoadBalancer: &brightbox.LoadBalancer{
-					ID:   "lba-12345",
-					Name: premappedName,
-				},
+				ID:         "cip-67890",
+				Name:       premappedName,
+				PublicIPv4: publicIP2,
+				PublicIPv6: publicIPv62,
 			},
 		},
 	}
@@ -3221,7 +4049,11 @@ func TestEnsureAllocatedCloudIP(t *testing.T) {
 			ctx := context.Background()
 
 			desc := client.GetLoadBalancerName(ctx, clusterName, tc.service)
-			cip, err := client.ensureAllocatedCloudIP(ctx, desc, tc.service)
+			owned, err := client.ensureOwnedResources(ctx, desc, clusterName, tc.service)
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			cip, err := client.ensureAllocatedCloudIP(ctx, owned, tc.service)
 			if err != nil && tc.cip != nil {
 				t.Errorf("Error when not expected %q", err.Error())
 			} else if diff := deep.Equal(cip, tc.cip); diff != nil {
@@ -3386,7 +4218,7 @@ func TestDeposeCloudIPFunctions(t *testing.T) {
 	}
 }
 
-func TestDeletionByNameFunctions(t *testing.T) {
+func TestDeletionFunctions(t *testing.T) {
 	testCases := []string{
 		lbname,
 		"not-found",
@@ -3397,10 +4229,18 @@ func TestDeletionByNameFunctions(t *testing.T) {
 			client := makeFakeInstanceCloudClient()
 			ctx := context.Background()
 
-			client.ensureServerGroupDeleted(ctx, name)
-			client.ensureLoadBalancerDeletedByName(ctx, name)
-			client.ensureFirewallClosed(ctx, name)
-			client.ensureCloudIPsDeleted(ctx, "", name)
+			owned, err := client.findOwnedResources(ctx, name, clusterName, lbuid)
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			if owned == nil {
+				return
+			}
+			client.ensureLoadBalancerDestroyed(ctx, owned)
+			client.ensureCloudIPsDeleted(ctx, nil, owned)
+			client.ensureLoadBalancerErased(ctx, owned)
+			client.ensureFirewallClosed(ctx, owned)
+			client.ensureServerGroupDeleted(ctx, owned)
 		})
 	}
 }
@@ -3480,6 +4320,203 @@ func TestPortListString(t *testing.T) {
 	}
 }
 
+func TestEnsureFirewallRules(t *testing.T) {
+	ports := []v1.ServicePort{
+		{
+			Name:       "http",
+			Protocol:   v1.ProtocolTCP,
+			Port:       80,
+			TargetPort: intstr.FromInt(8080),
+			NodePort:   31348,
+		},
+	}
+	testCases := map[string]struct {
+		cloudIP      bool
+		destination  string
+		sourceRanges []string
+		rules        []brightbox.FirewallRule
+		calls        []string
+		events       []string
+	}{
+		"default-new-policy": {
+			calls: []string{
+				"create 10.0.0.0/8",
+			},
+			events: []string{
+				"Normal FirewallRuleCreated Created firewall rule fwr-testy for 10.0.0.0/8",
+			},
+		},
+		"default-replaces-old-rule": {
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-found", Description: lbname},
+			},
+			calls: []string{
+				"update fwr-found 10.0.0.0/8",
+			},
+			events: []string{
+				"Normal FirewallRuleUpdated Updated firewall rule fwr-found for 10.0.0.0/8",
+			},
+		},
+		"default-unchanged": {
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-found", Source: defaultRegionCidr, Protocol: defaultRuleProtocol, DestinationPort: "31348", Description: lbname},
+			},
+		},
+		"source-ranges": {
+			cloudIP:      true,
+			sourceRanges: []string{" 2a02:1348:ffff::/48", "203.0.113.7/24", "203.0.113.0/24"},
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-found", Source: defaultRegionCidr, Protocol: defaultRuleProtocol, DestinationPort: "31348", Description: lbname},
+			},
+			calls: []string{
+				"update fwr-found 203.0.113.0/24",
+				"create 2a02:1348:ffff::/48",
+			},
+			events: []string{
+				"Normal FirewallRuleUpdated Updated firewall rule fwr-found for 203.0.113.0/24",
+				"Normal FirewallRuleCreated Created firewall rule fwr-testy for 2a02:1348:ffff::/48",
+			},
+		},
+		"source-range-removed": {
+			cloudIP:      true,
+			sourceRanges: []string{"203.0.113.0/24"},
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-ipv6", Source: "2a02:1348:ffff::/48", Protocol: defaultRuleProtocol, DestinationPort: "31348", Description: lbname},
+				{ID: "fwr-ipv4", Source: "203.0.113.0/24", Protocol: defaultRuleProtocol, DestinationPort: "31347", Description: lbname},
+			},
+			calls: []string{
+				"update fwr-ipv4 203.0.113.0/24",
+				"destroy fwr-ipv6",
+			},
+			events: []string{
+				"Normal FirewallRuleUpdated Updated firewall rule fwr-ipv4 for 203.0.113.0/24",
+				"Normal FirewallRuleDeleted Deleted firewall rule fwr-ipv6 for 2a02:1348:ffff::/48",
+			},
+		},
+		"cloud-ip-target-only": {
+			cloudIP:     true,
+			destination: "srv-testy",
+			calls: []string{
+				"create 0.0.0.0/0 to srv-testy",
+				"create ::/0 to srv-testy",
+			},
+			events: []string{
+				"Normal FirewallRuleCreated Created firewall rule fwr-testy for 0.0.0.0/0",
+				"Normal FirewallRuleCreated Created firewall rule fwr-testy for ::/0",
+			},
+		},
+		"cloud-ip-target-moved": {
+			cloudIP:      true,
+			destination:  "srv-other",
+			sourceRanges: []string{"203.0.113.0/24"},
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-found", Source: "203.0.113.0/24", Destination: "srv-testy", Protocol: defaultRuleProtocol, DestinationPort: "31348", Description: lbname},
+			},
+			calls: []string{
+				"update fwr-found 203.0.113.0/24 to srv-other",
+			},
+			events: []string{
+				"Normal FirewallRuleUpdated Updated firewall rule fwr-found for 203.0.113.0/24",
+			},
+		},
+		"cloud-ip-target-dropped": {
+			rules: []brightbox.FirewallRule{
+				{ID: "fwr-found", Source: defaultRegionCidr, Destination: "srv-testy", Protocol: defaultRuleProtocol, DestinationPort: "31348", Description: lbname},
+			},
+			calls: []string{
+				"create 10.0.0.0/8",
+				"destroy fwr-found",
+			},
+			events: []string{
+				"Normal FirewallRuleCreated Created firewall rule fwr-testy for 10.0.0.0/8",
+				"Normal FirewallRuleDeleted Deleted firewall rule fwr-found for 10.0.0.0/8",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeFirewallCloud{}
+			recorder := record.NewFakeRecorder(10)
+			client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil), recorder: recorder}
+			service := &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type:                     v1.ServiceTypeLoadBalancer,
+					Ports:                    ports,
+					LoadBalancerSourceRanges: tc.sourceRanges,
+				},
+			}
+			fp := &brightbox.FirewallPolicy{
+				ID:    "fwp-found",
+				Name:  lbname,
+				Rules: tc.rules,
+			}
+			if tc.cloudIP {
+				service.Annotations = map[string]string{serviceAnnotationLoadBalancerMode: modeNameCloudIP}
+			}
+			if err := client.ensureFirewallRules(context.TODO(), service, fp, tc.destination); err != nil {
+				t.Errorf("Error when not expected: %q", err.Error())
+			}
+			if diff := deep.Equal(fake.calls, tc.calls); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(recordedEvents(recorder), tc.events); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// recordedEvents drains the events recorded so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var result []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			result = append(result, event)
+		default:
+			return result
+		}
+	}
+}
+
+func TestRecordPendingAcmeDomains(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	client := &cloud{recorder: recorder}
+	lb := &brightbox.LoadBalancer{
+		Acme: &brightbox.LoadBalancerAcme{
+			Domains: []brightbox.LoadBalancerAcmeDomain{
+				{Identifier: "valid.example.com", Status: k8ssdk.ValidAcmeDomainStatus},
+				{Identifier: "pending.example.com", Status: "pending", LastMessage: "Awaiting DNS"},
+			},
+		},
+	}
+	client.recordPendingAcmeDomains(&v1.Service{}, lb)
+	expected := []string{"Normal WaitingForACME Waiting for ACME validation of domain pending.example.com (pending: Awaiting DNS)"}
+	if diff := deep.Equal(recordedEvents(recorder), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRecordWarning(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	client := &cloud{recorder: recorder}
+	err := fmt.Errorf("Cloud IP quota exceeded")
+	if diff := deep.Equal(client.recordWarning(&v1.Service{}, eventCloudIPAllocationFailed, err), err); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(client.recordWarning(&v1.Service{}, eventCloudIPAllocationFailed, nil), nil); diff != nil {
+		t.Error(diff)
+	}
+	expected := []string{"Warning CloudIPAllocationFailed Cloud IP quota exceeded"}
+	if diff := deep.Equal(recordedEvents(recorder), expected); diff != nil {
+		t.Error(diff)
+	}
+	// No recorder is a no-op
+	if diff := deep.Equal((&cloud{}).recordWarning(&v1.Service{}, eventCloudIPAllocationFailed, err), err); diff != nil {
+		t.Error(diff)
+	}
+}
+
 func TestEnsureLoadBalancerDeleted(t *testing.T) {
 	testCases := map[string]struct {
 		service *v1.Service
@@ -3531,6 +4568,34 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 	}
 }
 
+// fakeFirewallCloud records the firewall rule changes requested
+type fakeFirewallCloud struct {
+	mocks.CloudAccess
+	calls []string
+}
+
+func (f *fakeFirewallCloud) CreateFirewallRule(_ context.Context, ruleOptions brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	f.calls = append(f.calls, "create "+*ruleOptions.Source+ruleDestination(ruleOptions))
+	return &brightbox.FirewallRule{ID: "fwr-testy"}, nil
+}
+
+func (f *fakeFirewallCloud) UpdateFirewallRule(_ context.Context, ruleOptions brightbox.FirewallRuleOptions) (*brightbox.FirewallRule, error) {
+	f.calls = append(f.calls, "update "+ruleOptions.ID+" "+*ruleOptions.Source+ruleDestination(ruleOptions))
+	return &brightbox.FirewallRule{ID: ruleOptions.ID}, nil
+}
+
+func ruleDestination(ruleOptions brightbox.FirewallRuleOptions) string {
+	if ruleOptions.Destination == nil {
+		return ""
+	}
+	return " to " + *ruleOptions.Destination
+}
+
+func (f *fakeFirewallCloud) DestroyFirewallRule(_ context.Context, identifier string) (*brightbox.FirewallRule, error) {
+	f.calls = append(f.calls, "destroy "+identifier)
+	return nil, nil
+}
+
 func (f *fakeInstanceCloud) MapCloudIP(_ context.Context, identifier string, destination brightbox.CloudIPAttachment) (*brightbox.CloudIP, error) {
 	return nil, nil
 }
@@ -3736,6 +4801,20 @@ func (f *fakeInstanceCloud) CreateServerGroup(_ context.Context, newServerGroup
 	return result, nil
 }
 
+func (f *fakeInstanceCloud) UpdateServerGroup(ctx context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	if options.ID == "grp-testy" {
+		return &brightbox.ServerGroup{ID: options.ID, Description: *options.Description}, nil
+	}
+	groups, _ := f.ServerGroups(ctx)
+	for _, group := range groups {
+		if group.ID == options.ID {
+			group.Description = *options.Description
+			return &group, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to UpdateServerGroup", options.ID)
+}
+
 func (f *fakeInstanceCloud) CreateFirewallPolicy(_ context.Context, policyOptions brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
 	result := &brightbox.FirewallPolicy{
 		ID:   "fwp-testy",
@@ -3772,6 +4851,17 @@ func (f *fakeInstanceCloud) UpdateFirewallRule(_ context.Context, ruleOptions br
 	return result, nil
 }
 
+func (f *fakeInstanceCloud) UpdateFirewallPolicy(ctx context.Context, options brightbox.FirewallPolicyOptions) (*brightbox.FirewallPolicy, error) {
+	policies, _ := f.FirewallPolicies(ctx)
+	for _, policy := range policies {
+		if policy.ID == options.ID {
+			policy.Description = *options.Description
+			return &policy, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to UpdateFirewallPolicy", options.ID)
+}
+
 func mapServerIDsToServers(serverIDs brightbox.ServerGroupMemberList) []brightbox.Server {
 	result := make([]brightbox.Server, len(serverIDs.Servers))
 	for i, server := range serverIDs.Servers {
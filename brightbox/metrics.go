@@ -0,0 +1,272 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const metricsSubsystem = "brightbox"
+
+// Load balancer reconciliation steps timed by EnsureLoadBalancer
+const (
+	stepOwnership        = "ownership"
+	stepCloudIP          = "cloud_ip"
+	stepDNSRecords       = "dns_records"
+	stepReverseDNS       = "reverse_dns"
+	stepCertificate      = "certificate"
+	stepDomainResolution = "domain_resolution"
+	stepLoadBalancer     = "load_balancer"
+	stepMapCloudIP       = "map_cloud_ip"
+	stepReleaseCloudIPs  = "release_cloud_ips"
+	stepStatus           = "status"
+)
+
+var (
+	apiRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "api_requests_total",
+			Help:           "Number of Brightbox API calls by operation and result code. The code is \"ok\", the HTTP status code of an API error, or \"error\".",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "code"},
+	)
+
+	apiRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "api_request_duration_seconds",
+			Help:           "Latency of Brightbox API calls by operation.",
+			Buckets:        metrics.ExponentialBuckets(0.05, 2, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+
+	apiRetries = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "api_retries_total",
+			Help:           "Number of Brightbox API calls tried again after a rate limit, server or network error, by operation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+
+	apiCircuitBreakerOpen = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "api_circuit_breaker_open",
+			Help:           "1 while Brightbox API calls are stopped after too many failures in a row, otherwise 0.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	apiCacheLookups = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "api_cache_lookups_total",
+			Help:           "Number of server and Cloud IP lookups by resource and whether the API cache held them (\"hit\" or \"miss\").",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "result"},
+	)
+
+	managedResources = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "managed_resources",
+			Help:           "Number of Brightbox resources managed for load balancer services, by resource type.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource"},
+	)
+
+	reconcileStepDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "load_balancer_reconcile_step_duration_seconds",
+			Help:           "Latency of each step of a load balancer reconciliation.",
+			Buckets:        metrics.ExponentialBuckets(0.05, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"step"},
+	)
+
+	acmePendingLoadBalancers = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "acme_pending_load_balancers",
+			Help:           "Number of load balancers waiting for Let's Encrypt to issue a certificate.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	acmeIssueDuration = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "acme_issue_duration_seconds",
+			Help:           "Time from a load balancer first waiting for a Let's Encrypt certificate until all its domains are validated.",
+			Buckets:        metrics.ExponentialBuckets(15, 2, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	orphanedLoadBalancers = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "orphaned_load_balancers",
+			Help:           "Number of load balancer names with Brightbox resources but no matching service, as found by the last garbage collection sweep.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	orphansCollected = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "orphaned_load_balancers_collected_total",
+			Help:           "Number of orphaned load balancer names whose Brightbox resources have been removed by the garbage collector.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	registerOnce sync.Once
+)
+
+// registerMetrics adds the provider metrics to the registry served by
+// the controller manager
+func registerMetrics() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(
+			apiRequests,
+			apiRequestDuration,
+			apiRetries,
+			apiCircuitBreakerOpen,
+			apiCacheLookups,
+			managedResources,
+			reconcileStepDuration,
+			acmePendingLoadBalancers,
+			acmeIssueDuration,
+			orphanedLoadBalancers,
+			orphansCollected,
+		)
+	})
+}
+
+// observeStep records the time taken by a reconciliation step
+func observeStep(step string, start time.Time) {
+	reconcileStepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// Resource types counted by the managed resources gauge
+const (
+	resourceLoadBalancers    = "load_balancers"
+	resourceCloudIPs         = "cloud_ips"
+	resourceServerGroups     = "server_groups"
+	resourceFirewallPolicies = "firewall_policies"
+)
+
+// serviceResources counts the Brightbox resources built for a service
+type serviceResources struct {
+	loadBalancers    int
+	cloudIPs         int
+	serverGroups     int
+	firewallPolicies int
+}
+
+// resourceTracker keeps the managed resource gauges up to date with
+// what each service was last reconciled to. It is rebuilt from scratch
+// after a restart as the service controller syncs every service. A nil
+// tracker records nothing.
+type resourceTracker struct {
+	mu          sync.Mutex
+	services    map[types.UID]serviceResources
+	acmePending map[types.UID]time.Time
+}
+
+func newResourceTracker() *resourceTracker {
+	return &resourceTracker{
+		services:    map[types.UID]serviceResources{},
+		acmePending: map[types.UID]time.Time{},
+	}
+}
+
+// set records the resources built for a service
+func (t *resourceTracker) set(uid types.UID, resources serviceResources) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.services[uid] = resources
+	t.update()
+}
+
+// remove forgets a deleted service
+func (t *resourceTracker) remove(uid types.UID) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.services, uid)
+	delete(t.acmePending, uid)
+	t.update()
+}
+
+// acmeStatus records whether a service is waiting for a certificate,
+// and observes the wait once the certificate is issued
+func (t *resourceTracker) acmeStatus(uid types.UID, pending bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since, waiting := t.acmePending[uid]
+	switch {
+	case pending && !waiting:
+		t.acmePending[uid] = time.Now()
+	case !pending && waiting:
+		acmeIssueDuration.Observe(time.Since(since).Seconds())
+		delete(t.acmePending, uid)
+	}
+	acmePendingLoadBalancers.Set(float64(len(t.acmePending)))
+}
+
+func (t *resourceTracker) totals() serviceResources {
+	var result serviceResources
+	for _, resources := range t.services {
+		result.loadBalancers += resources.loadBalancers
+		result.cloudIPs += resources.cloudIPs
+		result.serverGroups += resources.serverGroups
+		result.firewallPolicies += resources.firewallPolicies
+	}
+	return result
+}
+
+func (t *resourceTracker) update() {
+	totals := t.totals()
+	managedResources.WithLabelValues(resourceLoadBalancers).Set(float64(totals.loadBalancers))
+	managedResources.WithLabelValues(resourceCloudIPs).Set(float64(totals.cloudIPs))
+	managedResources.WithLabelValues(resourceServerGroups).Set(float64(totals.serverGroups))
+	managedResources.WithLabelValues(resourceFirewallPolicies).Set(float64(totals.firewallPolicies))
+	acmePendingLoadBalancers.Set(float64(len(t.acmePending)))
+}
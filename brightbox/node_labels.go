@@ -0,0 +1,144 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// Labels added to nodes from their server
+const (
+	nodeLabelPrefix          = "brightbox.com/"
+	nodeLabelServerName      = nodeLabelPrefix + "server-name"
+	nodeLabelImageID         = nodeLabelPrefix + "image-id"
+	nodeLabelImageName       = nodeLabelPrefix + "image-name"
+	nodeLabelServerTypeCores = nodeLabelPrefix + "server-type-cores"
+	nodeLabelServerTypeRAM   = nodeLabelPrefix + "server-type-ram"
+	// Followed by the server group ID, with the group name as the value
+	nodeLabelServerGroupPrefix = nodeLabelPrefix + "server-group-"
+)
+
+// serverGroupLabelsMarker starts a server group description that lists
+// labels for the group's servers. Brightbox servers have no tags, so
+// this is where user metadata goes, e.g.
+// "k8s-node-labels: pool=web, tier=frontend".
+const serverGroupLabelsMarker = "k8s-node-labels:"
+
+// nodeLabelsFromServer describes the server as node labels: its name,
+// image, server type size and server groups, and the labels listed in
+// its server groups' descriptions. The server groups the controller
+// builds for load balancers come and go with services, so they are
+// left out. Values that can't be made into a label value are dropped.
+//
+// The labels are only set when the node is initialised. The node
+// controller doesn't revisit them, so a change to the server or its
+// groups needs the node to re-register.
+func nodeLabelsFromServer(srv *brightbox.Server) map[string]string {
+	result := make(map[string]string)
+	add := func(key string, value string) {
+		if value = labelValue(value); value != "" {
+			result[key] = value
+		}
+	}
+	add(nodeLabelServerName, srv.Name)
+	if srv.Image != nil {
+		add(nodeLabelImageID, srv.Image.ID)
+		add(nodeLabelImageName, srv.Image.Name)
+	}
+	if srv.ServerType != nil && srv.ServerType.Cores > 0 {
+		add(nodeLabelServerTypeCores, strconv.FormatUint(uint64(srv.ServerType.Cores), 10))
+		add(nodeLabelServerTypeRAM, strconv.FormatUint(uint64(srv.ServerType.RAM), 10))
+	}
+	for _, group := range srv.ServerGroups {
+		if _, ours := parseOwnershipLedger(group.Description); ours {
+			continue
+		}
+		key := nodeLabelServerGroupPrefix + group.ID
+		if len(validation.IsQualifiedName(key)) > 0 {
+			continue
+		}
+		// Group membership is the label, so keep it even if the name
+		// can't be used
+		result[key] = labelValue(group.Name)
+	}
+	// The labels of the group with the lowest ID win, and none of them
+	// can replace the labels above
+	groups := slices.SortedFunc(slices.Values(srv.ServerGroups), func(a, b brightbox.ServerGroup) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	for _, group := range groups {
+		for key, value := range serverGroupLabels(group) {
+			if _, ok := result[key]; !ok {
+				result[key] = value
+			}
+		}
+	}
+	return result
+}
+
+// serverGroupLabels returns the labels listed in the group's
+// description, as comma separated key=value pairs after the marker.
+// Each key gets the brightbox.com/ prefix. Pairs that don't make a
+// valid label are logged and skipped.
+func serverGroupLabels(group brightbox.ServerGroup) map[string]string {
+	list, ok := strings.CutPrefix(strings.TrimSpace(group.Description), serverGroupLabelsMarker)
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key = nodeLabelPrefix + strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...); len(errs) > 0 {
+			klog.Warningf("Ignoring label %q in the description of server group %s: %s", strings.TrimSpace(pair), group.ID, strings.Join(errs, ", "))
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// labelValue turns a name into a label value by replacing the
+// characters labels can't hold with dashes, shortening it to fit and
+// trimming the ends back to an alphanumeric. Returns "" if nothing is
+// left.
+func labelValue(name string) string {
+	value := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, name)
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	value = strings.Trim(value, "-_.")
+	if len(validation.IsValidLabelValue(value)) > 0 {
+		return ""
+	}
+	return value
+}
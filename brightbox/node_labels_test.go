@@ -0,0 +1,107 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"strings"
+	"testing"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/go-test/deep"
+)
+
+func TestNodeLabelsFromServer(t *testing.T) {
+	testCases := map[string]struct {
+		server   brightbox.Server
+		expected map[string]string
+	}{
+		"bare": {
+			server:   brightbox.Server{ID: "srv-bare1"},
+			expected: map[string]string{},
+		},
+		"full": {
+			server: brightbox.Server{
+				Name:       "k8s worker.1",
+				Image:      &brightbox.Image{ID: "img-ubu24", Name: "Ubuntu Noble 24.04 (amd64)"},
+				ServerType: &brightbox.ServerType{Cores: 4, RAM: 8192},
+				ServerGroups: []brightbox.ServerGroup{
+					{ID: "grp-pool1", Name: "pool one"},
+					{ID: "grp-anon1"},
+					{ID: "grp-lbweb", Name: "web.default.kubernetes", Description: "k8s-brightbox-ccm cluster=kubernetes service=uid-web"},
+				},
+			},
+			expected: map[string]string{
+				"brightbox.com/server-name":            "k8s-worker.1",
+				"brightbox.com/image-id":               "img-ubu24",
+				"brightbox.com/image-name":             "Ubuntu-Noble-24.04--amd64",
+				"brightbox.com/server-type-cores":      "4",
+				"brightbox.com/server-type-ram":        "8192",
+				"brightbox.com/server-group-grp-pool1": "pool-one",
+				"brightbox.com/server-group-grp-anon1": "",
+			},
+		},
+		"group labels": {
+			server: brightbox.Server{
+				Name: "worker",
+				ServerGroups: []brightbox.ServerGroup{
+					{ID: "grp-pool2", Name: "pool two", Description: "k8s-node-labels: pool=web, tier=frontend, server-name=sneaky"},
+					{ID: "grp-pool1", Name: "pool one", Description: "k8s-node-labels: pool=batch,bad key=x, gpu=, =empty"},
+					{ID: "grp-notes", Name: "notes", Description: "pool=ignored, not a label list"},
+				},
+			},
+			expected: map[string]string{
+				"brightbox.com/server-name":            "worker",
+				"brightbox.com/server-group-grp-pool1": "pool-one",
+				"brightbox.com/server-group-grp-pool2": "pool-two",
+				"brightbox.com/server-group-grp-notes": "notes",
+				"brightbox.com/pool":                   "batch",
+				"brightbox.com/gpu":                    "",
+				"brightbox.com/tier":                   "frontend",
+			},
+		},
+		"unusable name": {
+			server:   brightbox.Server{Name: "_!_"},
+			expected: map[string]string{},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(nodeLabelsFromServer(&tc.server), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	testCases := map[string]struct {
+		name     string
+		expected string
+	}{
+		"plain":      {name: "web-1", expected: "web-1"},
+		"spaces":     {name: " web 1 ", expected: "web-1"},
+		"unicode":    {name: "café", expected: "caf"},
+		"empty":      {name: "", expected: ""},
+		"long":       {name: strings.Repeat("a", 62) + ".b", expected: strings.Repeat("a", 62)},
+		"all symbol": {name: "***", expected: ""},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := deep.Equal(labelValue(tc.name), tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
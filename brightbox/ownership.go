@@ -0,0 +1,327 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/loadbalancerstatus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/version"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ownershipMarker starts the description of every server group and
+	// firewall policy built by the controller
+	ownershipMarker = "k8s-brightbox-ccm"
+
+	// maxLedgerLength is the longest description the API accepts for
+	// a server group or firewall policy
+	maxLedgerLength = 255
+)
+
+// ownershipLedger records which service owns the resources built for a
+// load balancer. Only server groups and firewall policies have a
+// description to keep it in, so the server group's copy also lists the
+// load balancer and Cloud IPs that belong to the service. DNS names are
+// claimed in the zone itself, so they don't use up the description. The
+// controller never updates or destroys anything that is not in a
+// ledger.
+type ownershipLedger struct {
+	clusterName       string
+	serviceUID        types.UID
+	controllerVersion string
+	loadBalancer      string
+	cloudIPs          []string
+}
+
+func newOwnershipLedger(clusterName string, uid types.UID) *ownershipLedger {
+	return &ownershipLedger{
+		clusterName:       clusterName,
+		serviceUID:        uid,
+		controllerVersion: version.Get().GitVersion,
+	}
+}
+
+// parseOwnershipLedger reads a ledger from a description, returning
+// false if the controller did not write it. Unknown fields are skipped.
+func parseOwnershipLedger(description string) (*ownershipLedger, bool) {
+	fields := strings.Fields(description)
+	if len(fields) == 0 || fields[0] != ownershipMarker {
+		return nil, false
+	}
+	result := &ownershipLedger{}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "cluster":
+			result.clusterName = value
+		case "service":
+			result.serviceUID = types.UID(value)
+		case "version":
+			result.controllerVersion = value
+		case "lb":
+			result.loadBalancer = value
+		case "cips":
+			result.cloudIPs = strings.Split(value, ",")
+		}
+	}
+	return result, true
+}
+
+// String formats the ledger as a description
+func (l *ownershipLedger) String() string {
+	fields := []string{
+		ownershipMarker,
+		"cluster=" + l.clusterName,
+		"service=" + string(l.serviceUID),
+		"version=" + l.controllerVersion,
+	}
+	if l.loadBalancer != "" {
+		fields = append(fields, "lb="+l.loadBalancer)
+	}
+	if len(l.cloudIPs) > 0 {
+		fields = append(fields, "cips="+strings.Join(l.cloudIPs, ","))
+	}
+	return strings.Join(fields, " ")
+}
+
+// description formats the ledger as a description, failing if it is
+// too long for the API rather than losing part of it
+func (l *ownershipLedger) description() (string, error) {
+	result := l.String()
+	if len(result) > maxLedgerLength {
+		return "", fmt.Errorf("Ownership ledger for service %s is %d characters long, more than the %d the API allows. List fewer Cloud IPs to continue", l.serviceUID, len(result), maxLedgerLength)
+	}
+	return result, nil
+}
+
+// owner returns the ledger without any resources, as written on the
+// firewall policy
+func (l *ownershipLedger) owner() *ownershipLedger {
+	return &ownershipLedger{
+		clusterName:       l.clusterName,
+		serviceUID:        l.serviceUID,
+		controllerVersion: l.controllerVersion,
+	}
+}
+
+// ownedBy reports whether the ledger belongs to the service. Service UIDs
+// are unique across clusters, so a ledger survives a change of cluster
+// name.
+func (l *ownershipLedger) ownedBy(uid types.UID) bool {
+	return l.serviceUID == uid
+}
+
+func (l *ownershipLedger) ownsCloudIP(id string) bool {
+	return slices.Contains(l.cloudIPs, id)
+}
+
+// ownedResources is a service's ledger along with the server group
+// that stores it
+type ownedResources struct {
+	group  *brightbox.ServerGroup
+	ledger *ownershipLedger
+}
+
+func notOwnedError(kind string, id string, name string) error {
+	return fmt.Errorf("%s %s named %q is not owned by this service. Rename or remove it to continue", kind, id, name)
+}
+
+// isNotFound reports whether the API says the resource does not exist
+func isNotFound(err error) bool {
+	var apiErr *brightbox.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ensureOwnedResources returns the service's ledger, creating the server
+// group that holds it if need be. A server group with the same name
+// owned by anything else is an error. The cluster name of an existing
+// ledger is never changed.
+func (c *cloud) ensureOwnedResources(ctx context.Context, name string, clusterName string, apiservice *v1.Service) (*ownedResources, error) {
+	klog.V(4).Infof("ensureOwnedResources (%q)", name)
+	group, err := c.GetServerGroupByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		ledger := newOwnershipLedger(clusterName, apiservice.UID)
+		description, err := ledger.description()
+		if err != nil {
+			return nil, err
+		}
+		group, err = c.createServerGroup(ctx, name, description)
+		if err != nil {
+			return nil, err
+		}
+		return &ownedResources{group: group, ledger: ledger}, nil
+	}
+	owned, err := c.claimServerGroup(ctx, group, clusterName, apiservice.UID)
+	if err != nil {
+		return nil, err
+	}
+	if owned == nil {
+		return nil, notOwnedError("Server group", group.ID, name)
+	}
+	owned.ledger.controllerVersion = version.Get().GitVersion
+	return owned, c.saveLedger(ctx, owned)
+}
+
+// findOwnedResources returns the service's ledger, or nil if there is
+// no server group with the name owned by the service
+func (c *cloud) findOwnedResources(ctx context.Context, name string, clusterName string, uid types.UID) (*ownedResources, error) {
+	klog.V(4).Infof("findOwnedResources (%q)", name)
+	group, err := c.GetServerGroupByName(ctx, name)
+	if err != nil || group == nil {
+		return nil, err
+	}
+	owned, err := c.claimServerGroup(ctx, group, clusterName, uid)
+	if err == nil && owned == nil {
+		klog.Warningf("Server group %s named %q is not owned by service %s, ignoring it", group.ID, name, uid)
+	}
+	return owned, err
+}
+
+// claimServerGroup reads the ledger from the server group, returning nil
+// if the group belongs to someone else. Groups with no description may
+// have been built before ledgers existed, but may equally have been
+// built by hand, so they are only adopted when the cloud config asks
+// for it.
+func (c *cloud) claimServerGroup(ctx context.Context, group *brightbox.ServerGroup, clusterName string, uid types.UID) (*ownedResources, error) {
+	if ledger, ok := parseOwnershipLedger(group.Description); ok {
+		if !ledger.ownedBy(uid) {
+			return nil, nil
+		}
+		return &ownedResources{group: group, ledger: ledger}, nil
+	}
+	if group.Description != "" {
+		return nil, nil
+	}
+	if !c.config.AdoptLegacyResources {
+		klog.Warningf("Server group %s named %q has no ownership ledger. Set adoptLegacyResources in the cloud config to adopt it", group.ID, group.Name)
+		return nil, nil
+	}
+	return c.adoptLegacyResources(ctx, group, clusterName, uid)
+}
+
+// adoptLegacyResources builds a ledger for a server group created before
+// ledgers existed. The load balancer and Cloud IPs are found by name, as
+// they were when they were built. Apart from reclaiming released Cloud
+// IPs, this is the only place resources are matched by name.
+func (c *cloud) adoptLegacyResources(ctx context.Context, group *brightbox.ServerGroup, clusterName string, uid types.UID) (*ownedResources, error) {
+	klog.V(4).Infof("adoptLegacyResources (%q)", group.Name)
+	ledger := newOwnershipLedger(clusterName, uid)
+	lb, err := c.GetLoadBalancerByName(ctx, group.Name)
+	if err != nil {
+		return nil, err
+	}
+	if lb != nil {
+		ledger.loadBalancer = lb.ID
+	}
+	cloudIPList, err := c.GetCloudIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, cip := range cloudIPList {
+		if cip.Name == group.Name {
+			ledger.cloudIPs = append(ledger.cloudIPs, cip.ID)
+		}
+	}
+	return &ownedResources{group: group, ledger: ledger}, nil
+}
+
+// saveLedger writes the ledger to the server group if it has changed
+func (c *cloud) saveLedger(ctx context.Context, owned *ownedResources) error {
+	description, err := owned.ledger.description()
+	if err != nil {
+		return err
+	}
+	if owned.group.Description == description {
+		return nil
+	}
+	klog.V(4).Infof("saveLedger (%q, %q)", owned.group.ID, description)
+	if _, err := c.updateServerGroup(ctx, brightbox.ServerGroupOptions{ID: owned.group.ID, Description: &description}); err != nil {
+		return err
+	}
+	owned.group.Description = description
+	return nil
+}
+
+func (c *cloud) createServerGroup(ctx context.Context, name string, description string) (*brightbox.ServerGroup, error) {
+	klog.V(4).Infof("createServerGroup (%q)", name)
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateServerGroup(ctx, brightbox.ServerGroupOptions{Name: &name, Description: &description})
+}
+
+// ownedLoadBalancer returns the load balancer in the ledger, or nil if
+// there isn't one or it has been deleted
+func (c *cloud) ownedLoadBalancer(ctx context.Context, owned *ownedResources) (*brightbox.LoadBalancer, error) {
+	if owned == nil || owned.ledger.loadBalancer == "" {
+		return nil, nil
+	}
+	lb, err := c.GetLoadBalancerByID(ctx, owned.ledger.loadBalancer)
+	switch {
+	case isNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case lb.Status != loadbalancerstatus.Active && lb.Status != loadbalancerstatus.Creating:
+		return nil, nil
+	}
+	return lb, nil
+}
+
+// ownedFirewallPolicy returns the firewall policy applied to the
+// service's server group, or nil if there isn't one. A policy carrying
+// someone else's marker is an error.
+func (c *cloud) ownedFirewallPolicy(ctx context.Context, owned *ownedResources) (*brightbox.FirewallPolicy, error) {
+	if owned.group.FirewallPolicy == nil {
+		return nil, nil
+	}
+	client, err := c.CloudClient()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := client.FirewallPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(policies, func(policy brightbox.FirewallPolicy) bool {
+		return policy.ID == owned.group.FirewallPolicy.ID
+	})
+	if i == -1 {
+		return nil, nil
+	}
+	fp := &policies[i]
+	if ledger, ok := parseOwnershipLedger(fp.Description); ok {
+		if !ledger.ownedBy(owned.ledger.serviceUID) {
+			return nil, notOwnedError("Firewall policy", fp.ID, fp.Name)
+		}
+	} else if fp.Description != "" {
+		return nil, notOwnedError("Firewall policy", fp.ID, fp.Name)
+	}
+	return fp, nil
+}
@@ -0,0 +1,464 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/brightbox/gobrightbox/v2/enums/loadbalancerstatus"
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/brightbox/k8ssdk/v2/mocks"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseOwnershipLedger(t *testing.T) {
+	testCases := map[string]struct {
+		description string
+		ledger      *ownershipLedger
+		ok          bool
+	}{
+		"full": {
+			description: "k8s-brightbox-ccm cluster=kubernetes service=uid-1 version=v1.2.3 lb=lba-testy cips=cip-aaaaa,cip-bbbbb",
+			ledger: &ownershipLedger{
+				clusterName:       "kubernetes",
+				serviceUID:        "uid-1",
+				controllerVersion: "v1.2.3",
+				loadBalancer:      "lba-testy",
+				cloudIPs:          []string{"cip-aaaaa", "cip-bbbbb"},
+			},
+			ok: true,
+		},
+		"dns names from an earlier release": {
+			description: "k8s-brightbox-ccm cluster=kubernetes service=uid-1 version=v1.2.3 lb=lba-testy dns=www.example.com,example.com",
+			ledger: &ownershipLedger{
+				clusterName:       "kubernetes",
+				serviceUID:        "uid-1",
+				controllerVersion: "v1.2.3",
+				loadBalancer:      "lba-testy",
+			},
+			ok: true,
+		},
+		"owner only": {
+			description: "k8s-brightbox-ccm cluster=kubernetes service=uid-1 version=v1.2.3",
+			ledger: &ownershipLedger{
+				clusterName:       "kubernetes",
+				serviceUID:        "uid-1",
+				controllerVersion: "v1.2.3",
+			},
+			ok: true,
+		},
+		"unknown field": {
+			description: "k8s-brightbox-ccm cluster=kubernetes service=uid-1 colour=blue",
+			ledger: &ownershipLedger{
+				clusterName: "kubernetes",
+				serviceUID:  "uid-1",
+			},
+			ok: true,
+		},
+		"empty": {
+			description: "",
+		},
+		"someone else's": {
+			description: "web servers for example.com",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ledger, ok := parseOwnershipLedger(tc.description)
+			if diff := deep.Equal(ok, tc.ok); diff != nil {
+				t.Error(diff)
+			}
+			// deep skips unexported fields, so compare them as text
+			if diff := deep.Equal(fmt.Sprintf("%+v", ledger), fmt.Sprintf("%+v", tc.ledger)); diff != nil {
+				t.Error(diff)
+			}
+			if ok && !strings.Contains(tc.description, "colour") && !strings.Contains(tc.description, "dns=") {
+				if diff := deep.Equal(ledger.String(), tc.description); diff != nil {
+					t.Error(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureOwnedResources(t *testing.T) {
+	testCases := map[string]struct {
+		service     *v1.Service
+		clusterName string
+		adopt       bool
+		ledger      *ownershipLedger
+		group       string
+		status      string
+		expected    []string
+	}{
+		"new": {
+			service: testLedgerService("new", "uid-new"),
+			ledger: &ownershipLedger{
+				clusterName: "kubernetes",
+				serviceUID:  "uid-new",
+			},
+			group:    "grp-new",
+			expected: []string{"create grp-new"},
+		},
+		"owned": {
+			service: testLedgerService("web", "uid-web"),
+			ledger: &ownershipLedger{
+				clusterName:  "kubernetes",
+				serviceUID:   "uid-web",
+				loadBalancer: "lba-web",
+				cloudIPs:     []string{"cip-web"},
+			},
+			group:    "grp-web",
+			expected: []string{"update grp-web"},
+		},
+		"owned under another cluster name": {
+			service:     testLedgerService("web", "uid-web"),
+			clusterName: "production",
+			ledger: &ownershipLedger{
+				clusterName:  "kubernetes",
+				serviceUID:   "uid-web",
+				loadBalancer: "lba-web",
+				cloudIPs:     []string{"cip-web"},
+			},
+			group:    "grp-web",
+			expected: []string{"update grp-web"},
+		},
+		"legacy": {
+			service: testLedgerService("legacy", "uid-legacy"),
+			adopt:   true,
+			ledger: &ownershipLedger{
+				clusterName:  "kubernetes",
+				serviceUID:   "uid-legacy",
+				loadBalancer: "lba-legacy",
+				cloudIPs:     []string{"cip-legacy"},
+			},
+			group:    "grp-legacy",
+			expected: []string{"update grp-legacy"},
+		},
+		"legacy not adopted": {
+			service: testLedgerService("legacy", "uid-legacy"),
+			status:  `Server group grp-legacy named "legacy.default.kubernetes" is not owned by this service`,
+		},
+		"recreated service": {
+			service: testLedgerService("web", "uid-other"),
+			status:  `Server group grp-web named "web.default.kubernetes" is not owned by this service`,
+		},
+		"someone else's group": {
+			service: testLedgerService("manual", "uid-manual"),
+			status:  `Server group grp-manual named "manual.default.kubernetes" is not owned by this service`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeLedgerCloud()
+			client := &cloud{
+				Cloud:  k8ssdk.MakeTestClient(fake, nil),
+				config: cloudConfig{AdoptLegacyResources: tc.adopt},
+			}
+			ctx := context.TODO()
+			clusterName := tc.clusterName
+			if clusterName == "" {
+				clusterName = "kubernetes"
+			}
+			lbName := client.GetLoadBalancerName(ctx, "kubernetes", tc.service)
+			owned, err := client.ensureOwnedResources(ctx, lbName, clusterName, tc.service)
+			if tc.status != "" {
+				if err == nil {
+					t.Fatalf("Expected error %q got nil", tc.status)
+				} else if !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("Expected %q, got %q", tc.status, err.Error())
+				}
+				if diff := deep.Equal(fake.calls, tc.expected); diff != nil {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			// The version depends on how the test binary was built
+			owned.ledger.controllerVersion = ""
+			// deep skips unexported fields, so compare them as text
+			if diff := deep.Equal(fmt.Sprintf("%+v", owned.ledger), fmt.Sprintf("%+v", tc.ledger)); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(owned.group.ID, tc.group); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(fake.calls, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSaveLedgerTooLong(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A service with several Cloud IPs still fits
+	owned.ledger.serviceUID = "3f1c9a52-7d4e-4b8a-9c61-2e5f0a7b8d93"
+	owned.ledger.controllerVersion = "v1.35.2-brightbox.1"
+	for i := range 10 {
+		owned.ledger.cloudIPs = append(owned.ledger.cloudIPs, fmt.Sprintf("cip-%05d", i))
+	}
+	if _, err := owned.ledger.description(); err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	}
+	owned.ledger.serviceUID = "uid-web"
+	for i := range 20 {
+		owned.ledger.cloudIPs = append(owned.ledger.cloudIPs, fmt.Sprintf("cip-%05d", i+10))
+	}
+	err = client.saveLedger(context.TODO(), owned)
+	if err == nil || !strings.HasPrefix(err.Error(), "Ownership ledger for service uid-web is") {
+		t.Errorf("expected the ledger to be too long, got %v", err)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("expected nothing to be saved, got %v", fake.calls)
+	}
+}
+
+func TestOwnedFirewallPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy string
+		status string
+	}{
+		"owned": {
+			policy: "fwp-web",
+		},
+		"legacy": {
+			policy: "fwp-legacy",
+		},
+		"someone else's": {
+			policy: "fwp-manual",
+			status: `Firewall policy fwp-manual named "manual.default.kubernetes" is not owned by this service`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeLedgerCloud()
+			client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+			owned := &ownedResources{
+				group: &brightbox.ServerGroup{
+					ID:             "grp-web",
+					FirewallPolicy: &brightbox.FirewallPolicy{ID: tc.policy},
+				},
+				ledger: newOwnershipLedger("kubernetes", "uid-web"),
+			}
+			fp, err := client.ownedFirewallPolicy(context.TODO(), owned)
+			if tc.status != "" {
+				if err == nil {
+					t.Fatalf("Expected error %q got nil", tc.status)
+				} else if !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("Expected %q, got %q", tc.status, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			if diff := deep.Equal(fp.ID, tc.policy); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestEnsureCloudIPsDeletedOnlyOwned(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A Cloud IP named like the service is left alone
+	fake.cloudIPs = append(fake.cloudIPs, brightbox.CloudIP{ID: "cip-named", Name: "web.default.kubernetes"})
+	if err := client.ensureCloudIPsDeleted(context.TODO(), nil, owned); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(fake.calls, []string{"destroy cip-web", "update grp-web"}); diff != nil {
+		t.Error(diff)
+	}
+	if owned.ledger.cloudIPs == nil || len(owned.ledger.cloudIPs) != 0 {
+		t.Errorf("expected the ledger to have no Cloud IPs, got %v", owned.ledger.cloudIPs)
+	}
+}
+
+func TestEnsureCloudIPsDeletedKeepsCache(t *testing.T) {
+	fake := newFakeLedgerCloud()
+	client := &cloud{Cloud: k8ssdk.MakeTestClient(fake, nil), cache: newAPICache(time.Minute)}
+	owned, err := client.findOwnedResources(context.TODO(), "web.default.kubernetes", "kubernetes", "uid-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.cache.storeServer(0, &brightbox.Server{ID: "srv-aaaaa"})
+	// Nothing to remove when every Cloud IP is kept
+	if err := client.ensureCloudIPsDeleted(context.TODO(), []string{"cip-web"}, owned); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("expected no calls, got %v", fake.calls)
+	}
+	if _, _, ok := client.cache.server("srv-aaaaa"); !ok {
+		t.Error("expected the cache to be kept")
+	}
+}
+
+func testLedgerService(name string, uid types.UID) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: uid},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+}
+
+func testLedger(uid types.UID, name string) string {
+	ledger := &ownershipLedger{
+		clusterName:       "kubernetes",
+		serviceUID:        uid,
+		controllerVersion: "v0.0.1",
+		loadBalancer:      "lba-" + name,
+		cloudIPs:          []string{"cip-" + name},
+	}
+	return ledger.String()
+}
+
+// fakeLedgerCloud holds the resources of the "web", "old" and "api"
+// services built with a ledger, those of the "legacy" service built
+// before ledgers, and a "manual" server group and firewall policy that
+// belong to nobody. It records the changes made.
+type fakeLedgerCloud struct {
+	mocks.CloudAccess
+	loadBalancers []brightbox.LoadBalancer
+	cloudIPs      []brightbox.CloudIP
+	groups        []brightbox.ServerGroup
+	policies      []brightbox.FirewallPolicy
+	calls         []string
+}
+
+func newFakeLedgerCloud() *fakeLedgerCloud {
+	result := &fakeLedgerCloud{}
+	add := func(name string, namespace string, description string) {
+		lbName := name + "." + namespace + ".kubernetes"
+		result.loadBalancers = append(result.loadBalancers, brightbox.LoadBalancer{ID: "lba-" + name, Name: lbName, Status: loadbalancerstatus.Active})
+		result.cloudIPs = append(result.cloudIPs, brightbox.CloudIP{ID: "cip-" + name, Name: lbName})
+		result.groups = append(result.groups, brightbox.ServerGroup{
+			ID:             "grp-" + name,
+			Name:           lbName,
+			Description:    description,
+			FirewallPolicy: &brightbox.FirewallPolicy{ID: "fwp-" + name},
+		})
+		if description != "" {
+			ledger, _ := parseOwnershipLedger(description)
+			description = ledger.owner().String()
+		}
+		result.policies = append(result.policies, brightbox.FirewallPolicy{ID: "fwp-" + name, Name: lbName, Description: description})
+	}
+	add("web", "default", testLedger("uid-web", "web"))
+	add("old", "default", testLedger("uid-old", "old"))
+	add("api", "other", testLedger("uid-api", "api"))
+	add("legacy", "default", "")
+	result.groups = append(result.groups, brightbox.ServerGroup{
+		ID:             "grp-manual",
+		Name:           "manual.default.kubernetes",
+		Description:    "hand built",
+		FirewallPolicy: &brightbox.FirewallPolicy{ID: "fwp-manual"},
+	})
+	result.policies = append(result.policies, brightbox.FirewallPolicy{ID: "fwp-manual", Name: "manual.default.kubernetes", Description: "hand built"})
+	result.groups = append(result.groups, brightbox.ServerGroup{
+		ID:          "grp-prod",
+		Name:        "web.default.production",
+		Description: strings.Replace(testLedger("uid-prod", "prod"), "cluster=kubernetes", "cluster=production", 1),
+	})
+	return result
+}
+
+func (f *fakeLedgerCloud) LoadBalancers(context.Context) ([]brightbox.LoadBalancer, error) {
+	return slices.Clone(f.loadBalancers), nil
+}
+
+func (f *fakeLedgerCloud) LoadBalancer(_ context.Context, identifier string) (*brightbox.LoadBalancer, error) {
+	for _, lb := range f.loadBalancers {
+		if lb.ID == identifier {
+			return &lb, nil
+		}
+	}
+	return &brightbox.LoadBalancer{ID: identifier, Status: loadbalancerstatus.Deleted}, nil
+}
+
+func (f *fakeLedgerCloud) CloudIPs(context.Context) ([]brightbox.CloudIP, error) {
+	return slices.Clone(f.cloudIPs), nil
+}
+
+func (f *fakeLedgerCloud) ServerGroups(context.Context) ([]brightbox.ServerGroup, error) {
+	return slices.Clone(f.groups), nil
+}
+
+func (f *fakeLedgerCloud) FirewallPolicies(context.Context) ([]brightbox.FirewallPolicy, error) {
+	return slices.Clone(f.policies), nil
+}
+
+func (f *fakeLedgerCloud) CreateServerGroup(_ context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	group := brightbox.ServerGroup{ID: "grp-new", Name: *options.Name, Description: *options.Description}
+	f.calls = append(f.calls, "create "+group.ID)
+	f.groups = append(f.groups, group)
+	return &group, nil
+}
+
+func (f *fakeLedgerCloud) UpdateServerGroup(_ context.Context, options brightbox.ServerGroupOptions) (*brightbox.ServerGroup, error) {
+	f.calls = append(f.calls, "update "+options.ID)
+	for i := range f.groups {
+		if f.groups[i].ID == options.ID {
+			f.groups[i].Description = *options.Description
+			return &f.groups[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected identifier %q sent to UpdateServerGroup", options.ID)
+}
+
+func (f *fakeLedgerCloud) DestroyLoadBalancer(_ context.Context, identifier string) (*brightbox.LoadBalancer, error) {
+	f.calls = append(f.calls, "destroy "+identifier)
+	f.loadBalancers = slices.DeleteFunc(f.loadBalancers, func(lb brightbox.LoadBalancer) bool { return lb.ID == identifier })
+	return nil, nil
+}
+
+func (f *fakeLedgerCloud) DestroyCloudIP(_ context.Context, identifier string) (*brightbox.CloudIP, error) {
+	f.calls = append(f.calls, "destroy "+identifier)
+	f.cloudIPs = slices.DeleteFunc(f.cloudIPs, func(cip brightbox.CloudIP) bool { return cip.ID == identifier })
+	return nil, nil
+}
+
+func (f *fakeLedgerCloud) DestroyServerGroup(_ context.Context, identifier string) (*brightbox.ServerGroup, error) {
+	f.calls = append(f.calls, "destroy "+identifier)
+	f.groups = slices.DeleteFunc(f.groups, func(group brightbox.ServerGroup) bool { return group.ID == identifier })
+	return nil, nil
+}
+
+func (f *fakeLedgerCloud) DestroyFirewallPolicy(_ context.Context, identifier string) (*brightbox.FirewallPolicy, error) {
+	f.calls = append(f.calls, "destroy "+identifier)
+	f.policies = slices.DeleteFunc(f.policies, func(policy brightbox.FirewallPolicy) bool { return policy.ID == identifier })
+	return nil, nil
+}
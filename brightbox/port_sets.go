@@ -15,9 +15,11 @@
 package brightbox
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -47,3 +49,98 @@ func getPortSets(annotation string) (ports *portSets) {
 	}
 	return
 }
+
+// portMap holds per-port values from an annotation of the form
+// "port=value,port=value", where each port is a name or a number
+type portMap struct {
+	names   map[string]string
+	numbers map[int64]string
+}
+
+// getPortMap parses a per-port annotation. An empty annotation returns
+// a nil pointer.
+func getPortMap(annotation string) (*portMap, error) {
+	if strings.TrimSpace(annotation) == "" {
+		return nil, nil
+	}
+	ports := &portMap{
+		names:   map[string]string{},
+		numbers: map[int64]string{},
+	}
+	for _, item := range strings.Split(annotation, ",") {
+		key, value, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !found || key == "" || value == "" {
+			return nil, fmt.Errorf("%q needs to be in the form port=value", item)
+		}
+		if port, err := strconv.Atoi(key); err == nil {
+			if _, ok := ports.numbers[int64(port)]; ok {
+				return nil, fmt.Errorf("port %q is listed more than once", key)
+			}
+			ports.numbers[int64(port)] = value
+		} else {
+			if _, ok := ports.names[key]; ok {
+				return nil, fmt.Errorf("port %q is listed more than once", key)
+			}
+			ports.names[key] = value
+		}
+	}
+	return ports, nil
+}
+
+// lookup returns the value for the service port, matching on number
+// first and then name
+func (p *portMap) lookup(port *v1.ServicePort) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if value, ok := p.numbers[int64(port.Port)]; ok {
+		return value, true
+	}
+	if port.Name == "" {
+		return "", false
+	}
+	value, ok := p.names[port.Name]
+	return value, ok
+}
+
+// values returns every value in the map
+func (p *portMap) values() []string {
+	if p == nil {
+		return nil
+	}
+	result := make([]string, 0, len(p.names)+len(p.numbers))
+	for _, value := range p.names {
+		result = append(result, value)
+	}
+	for _, value := range p.numbers {
+		result = append(result, value)
+	}
+	return result
+}
+
+// unknownPort returns a port in the map that the service doesn't
+// define, if there is one
+func (p *portMap) unknownPort(ports []v1.ServicePort) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	names := sets.NewString()
+	numbers := sets.NewInt64()
+	for i := range ports {
+		names.Insert(ports[i].Name)
+		numbers.Insert(int64(ports[i].Port))
+	}
+	for name := range p.names {
+		if !names.Has(name) {
+			return name, true
+		}
+	}
+	for number := range p.numbers {
+		if !numbers.Has(number) {
+			return strconv.FormatInt(number, 10), true
+		}
+	}
+	return "", false
+}
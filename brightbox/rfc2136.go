@@ -0,0 +1,312 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultTSIGAlgorithm = "hmac-sha256"
+	tsigFudge            = 300
+	rfc2136Timeout       = 10 * time.Second
+)
+
+// tsigAlgorithms are the TSIG algorithms the controller can sign with
+var tsigAlgorithms = map[string]string{
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// rfc2136Config is the rfc2136 section of the dnsUpdates config
+type rfc2136Config struct {
+	// Server is the address of the zone's primary DNS server, with an
+	// optional port which defaults to 53.
+	Server string `json:"server"`
+
+	// TSIGKeyName and TSIGSecret sign the updates. The secret is base64
+	// encoded, as in a BIND key file. Leave both out to send unsigned
+	// updates.
+	TSIGKeyName string `json:"tsigKeyName,omitempty"`
+	TSIGSecret  string `json:"tsigSecret,omitempty"`
+
+	// TSIGAlgorithm is "hmac-sha256" (default) or "hmac-sha512"
+	TSIGAlgorithm string `json:"tsigAlgorithm,omitempty"`
+}
+
+func (cfg *rfc2136Config) validate() error {
+	if _, err := dnsServerAddress(cfg.Server); err != nil {
+		return err
+	}
+	if (cfg.TSIGKeyName == "") != (cfg.TSIGSecret == "") {
+		return fmt.Errorf("tsigKeyName and tsigSecret need to be set together")
+	}
+	if cfg.TSIGKeyName == "" {
+		return nil
+	}
+	if _, ok := dns.IsDomainName(cfg.TSIGKeyName); !ok {
+		return fmt.Errorf("Invalid tsigKeyName %q", cfg.TSIGKeyName)
+	}
+	if _, err := base64.StdEncoding.DecodeString(cfg.TSIGSecret); err != nil {
+		return fmt.Errorf("tsigSecret needs to be base64 encoded")
+	}
+	if _, ok := tsigAlgorithms[cfg.tsigAlgorithm()]; !ok {
+		return fmt.Errorf("tsigAlgorithm needs to be one of %v", tsigAlgorithmNames())
+	}
+	return nil
+}
+
+func (cfg *rfc2136Config) tsigAlgorithm() string {
+	if cfg.TSIGAlgorithm == "" {
+		return defaultTSIGAlgorithm
+	}
+	return cfg.TSIGAlgorithm
+}
+
+func tsigAlgorithmNames() []string {
+	result := make([]string, 0, len(tsigAlgorithms))
+	for name := range tsigAlgorithms {
+		result = append(result, name)
+	}
+	slices.Sort(result)
+	return result
+}
+
+// rfc2136Provider sends RFC 2136 dynamic updates to a DNS server,
+// signed with TSIG if there is a key
+type rfc2136Provider struct {
+	server string
+	zone   string
+	key    *tsigKey
+}
+
+// tsigKey signs updates and checks the replies to them
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    string
+}
+
+func newRFC2136Provider(cfg *rfc2136Config, zone string) (*rfc2136Provider, error) {
+	server, err := dnsServerAddress(cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dns.IsDomainName(zone); !ok {
+		return nil, fmt.Errorf("Invalid zone %q", zone)
+	}
+	result := &rfc2136Provider{
+		server: server,
+		zone:   fullyQualified(zone),
+	}
+	if cfg.TSIGKeyName != "" {
+		result.key = &tsigKey{
+			name:      fullyQualified(cfg.TSIGKeyName),
+			algorithm: tsigAlgorithms[cfg.tsigAlgorithm()],
+			secret:    cfg.TSIGSecret,
+		}
+	}
+	return result, nil
+}
+
+// lookupRecords asks the zone's primary server, which sees updates
+// before any secondary
+func (p *rfc2136Provider) lookupRecords(ctx context.Context, name string) ([]dnsRecord, error) {
+	var result []dnsRecord
+	for _, rrType := range dnsRecordTypes {
+		records, err := p.query(ctx, name, rrType)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	registry, err := p.query(ctx, registryName(name), dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	return append(result, registry...), nil
+}
+
+// registryRecords transfers the zone from its primary server, which
+// has to allow zone transfers to the controller, signed with the TSIG
+// key if there is one
+func (p *rfc2136Provider) registryRecords(_ context.Context) ([]dnsRecord, error) {
+	msg := new(dns.Msg)
+	msg.SetAxfr(p.zone)
+	p.sign(msg)
+	transfer := &dns.Transfer{DialTimeout: rfc2136Timeout, ReadTimeout: rfc2136Timeout}
+	if p.key != nil {
+		transfer.TsigSecret = map[string]string{p.key.name: p.key.secret}
+	}
+	envelopes, err := transfer.In(msg, p.server)
+	if err != nil {
+		return nil, fmt.Errorf("DNS transfer of zone %q from %s failed: %w", p.zone, p.server, err)
+	}
+	var result []dnsRecord
+	// The transfer runs until the channel closes, even after an error
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			err = envelope.Error
+			continue
+		}
+		for _, rr := range envelope.RR {
+			txt, ok := rr.(*dns.TXT)
+			if !ok || !strings.HasPrefix(canonicalName(txt.Hdr.Name), dnsRegistryPrefix) {
+				continue
+			}
+			result = append(result, dnsRecord{name: canonicalName(txt.Hdr.Name), rrType: dns.TypeTXT, value: strings.Join(txt.Txt, ""), ttl: txt.Hdr.Ttl})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DNS transfer of zone %q from %s failed: %w", p.zone, p.server, err)
+	}
+	return result, nil
+}
+
+// query returns the records of the type held by the name
+func (p *rfc2136Provider) query(ctx context.Context, name string, rrType uint16) ([]dnsRecord, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(fullyQualified(name), rrType)
+	p.sign(msg)
+	reply, err := p.exchange(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("DNS lookup of %q via %s failed: %w", name, p.server, err)
+	}
+	if reply.Rcode == dns.RcodeNameError {
+		return nil, nil
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("DNS lookup of %q refused by %s: %s", name, p.server, dns.RcodeToString[reply.Rcode])
+	}
+	var result []dnsRecord
+	for _, rr := range reply.Answer {
+		header := rr.Header()
+		if header.Rrtype != rrType || canonicalName(header.Name) != canonicalName(name) {
+			continue
+		}
+		record := dnsRecord{name: canonicalName(header.Name), rrType: rrType, ttl: header.Ttl}
+		switch rr := rr.(type) {
+		case *dns.A:
+			record.value = rr.A.String()
+		case *dns.AAAA:
+			record.value = rr.AAAA.String()
+		case *dns.CNAME:
+			record.value = canonicalName(rr.Target)
+		case *dns.TXT:
+			record.value = strings.Join(rr.Txt, "")
+		}
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+func (p *rfc2136Provider) replaceRecords(ctx context.Context, names []string, records []dnsRecord) error {
+	klog.V(4).Infof("rfc2136 replaceRecords (%q, %v)", p.zone, names)
+	msg, err := p.buildUpdate(names, records)
+	if err != nil {
+		return fmt.Errorf("Failed to build DNS update for %v: %w", names, err)
+	}
+	return p.send(ctx, msg)
+}
+
+// buildUpdate builds an UPDATE message that deletes the managed record
+// sets of the names, then adds the records
+func (p *rfc2136Provider) buildUpdate(names []string, records []dnsRecord) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetUpdate(p.zone)
+	for _, name := range names {
+		for _, rrType := range dnsRecordTypes {
+			msg.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: fullyQualified(name), Rrtype: rrType}}})
+		}
+		msg.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: fullyQualified(registryName(name)), Rrtype: dns.TypeTXT}}})
+	}
+	for _, record := range records {
+		rr, err := p.resourceRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		msg.Insert([]dns.RR{rr})
+	}
+	p.sign(msg)
+	return msg, nil
+}
+
+// sign adds a TSIG record if there is a key
+func (p *rfc2136Provider) sign(msg *dns.Msg) {
+	if p.key != nil {
+		msg.SetTsig(p.key.name, p.key.algorithm, tsigFudge, time.Now().Unix())
+	}
+}
+
+func (p *rfc2136Provider) resourceRecord(record dnsRecord) (dns.RR, error) {
+	header := dns.RR_Header{Name: fullyQualified(record.name), Rrtype: record.rrType, Class: dns.ClassINET, Ttl: record.ttl}
+	switch record.rrType {
+	case dns.TypeA:
+		return &dns.A{Hdr: header, A: net.ParseIP(record.value).To4()}, nil
+	case dns.TypeAAAA:
+		return &dns.AAAA{Hdr: header, AAAA: net.ParseIP(record.value).To16()}, nil
+	case dns.TypeCNAME:
+		return &dns.CNAME{Hdr: header, Target: fullyQualified(record.value)}, nil
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: header, Txt: []string{record.value}}, nil
+	}
+	return nil, fmt.Errorf("Unsupported DNS record type %s", dns.TypeToString[record.rrType])
+}
+
+// send delivers the update and checks the server accepted it
+func (p *rfc2136Provider) send(ctx context.Context, msg *dns.Msg) error {
+	reply, err := p.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("DNS update of zone %q via %s failed: %w", p.zone, p.server, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("DNS update of zone %q refused by %s: %s", p.zone, p.server, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+// exchange sends a message over UDP, switching to TCP if the reply is
+// truncated. A signed message needs a signed reply, and the client
+// checks its signature.
+func (p *rfc2136Provider) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, rfc2136Timeout)
+	defer cancel()
+	client := &dns.Client{Net: "udp"}
+	if p.key != nil {
+		client.TsigSecret = map[string]string{p.key.name: p.key.secret}
+	}
+	reply, _, err := client.ExchangeContext(ctx, msg, p.server)
+	if err == nil && reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.ExchangeContext(ctx, msg, p.server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.key != nil && reply.IsTsig() == nil {
+		return nil, errors.New("the reply is not signed")
+	}
+	return reply, nil
+}
@@ -0,0 +1,402 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGKey    = "k8s-key."
+	testTSIGSecret = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+)
+
+func TestRFC2136ReplaceRecords(t *testing.T) {
+	testCases := map[string]struct {
+		rcode   int
+		names   []string
+		records []dnsRecord
+		updates []string
+		status  string
+	}{
+		"add": {
+			names: []string{"www.example.com"},
+			records: []dnsRecord{
+				{name: "www.example.com", rrType: dns.TypeA, value: "109.107.39.92", ttl: 60},
+				{name: "www.example.com", rrType: dns.TypeAAAA, value: "2a02:1348:ffff:ffff::6d6b:275c", ttl: 60},
+				{name: "_k8s-brightbox-ccm.www.example.com", rrType: dns.TypeTXT, value: "heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web", ttl: 60},
+			},
+			updates: []string{
+				"delete www.example.com. A",
+				"delete www.example.com. AAAA",
+				"delete www.example.com. CNAME",
+				"delete _k8s-brightbox-ccm.www.example.com. TXT",
+				"add www.example.com. 60 A 109.107.39.92",
+				"add www.example.com. 60 AAAA 2a02:1348:ffff:ffff::6d6b:275c",
+				"add _k8s-brightbox-ccm.www.example.com. 60 TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web",
+			},
+		},
+		"cname": {
+			names: []string{"www.example.com"},
+			records: []dnsRecord{
+				{name: "www.example.com", rrType: dns.TypeCNAME, value: "cip-vsalc.gb1s.brightbox.com", ttl: 60},
+			},
+			updates: []string{
+				"delete www.example.com. A",
+				"delete www.example.com. AAAA",
+				"delete www.example.com. CNAME",
+				"delete _k8s-brightbox-ccm.www.example.com. TXT",
+				"add www.example.com. 60 CNAME cip-vsalc.gb1s.brightbox.com.",
+			},
+		},
+		"remove": {
+			names: []string{"www.example.com", "example.com"},
+			updates: []string{
+				"delete www.example.com. A",
+				"delete www.example.com. AAAA",
+				"delete www.example.com. CNAME",
+				"delete _k8s-brightbox-ccm.www.example.com. TXT",
+				"delete example.com. A",
+				"delete example.com. AAAA",
+				"delete example.com. CNAME",
+				"delete _k8s-brightbox-ccm.example.com. TXT",
+			},
+		},
+		"refused": {
+			rcode:  dns.RcodeRefused,
+			names:  []string{"www.example.com"},
+			status: `DNS update of zone "example.com." refused by`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server, received := testDNSServer(t, tc.rcode, nil)
+			provider, err := newRFC2136Provider(&rfc2136Config{Server: server}, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = provider.replaceRecords(context.TODO(), tc.names, tc.records)
+			if tc.status != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("expected %q, got %v", tc.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			zone, updates := describeUpdate(t, <-received)
+			if diff := deep.Equal(zone, "example.com."); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(updates, tc.updates); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRFC2136LookupRecords(t *testing.T) {
+	var records []dns.RR
+	for _, record := range []string{
+		"www.example.com. 60 IN A 109.107.39.92",
+		"www.example.com. 60 IN AAAA 2a02:1348:ffff:ffff::6d6b:275c",
+		"www.example.com. 60 IN MX 10 mail.example.com.",
+		`_k8s-brightbox-ccm.www.example.com. 60 IN TXT "heritage=k8s-brightbox-ccm,cluster=kubernetes," "service=uid-web"`,
+		"Api.Example.com. 300 IN CNAME Cip-Vsalc.gb1s.brightbox.com.",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rr)
+	}
+	server, _ := testDNSServer(t, dns.RcodeSuccess, nil, records...)
+	provider, err := newRFC2136Provider(&rfc2136Config{Server: server}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string][]string{
+		"www.example.com": {
+			"www.example.com 60 A 109.107.39.92",
+			"www.example.com 60 AAAA 2a02:1348:ffff:ffff::6d6b:275c",
+			"_k8s-brightbox-ccm.www.example.com 60 TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web",
+		},
+		"api.example.com": {
+			"api.example.com 300 CNAME cip-vsalc.gb1s.brightbox.com",
+		},
+		"missing.example.com": nil,
+	}
+	for name, expected := range testCases {
+		t.Run(name, func(t *testing.T) {
+			found, err := provider.lookupRecords(context.TODO(), name)
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			var result []string
+			for _, record := range found {
+				result = append(result, record.String())
+			}
+			if diff := deep.Equal(result, expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRFC2136RegistryRecords(t *testing.T) {
+	var records []dns.RR
+	for _, record := range []string{
+		"example.com. 300 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 300",
+		"www.example.com. 60 IN A 109.107.39.92",
+		`_k8s-brightbox-ccm.www.example.com. 60 IN TXT "heritage=k8s-brightbox-ccm,cluster=kubernetes," "service=uid-web"`,
+		`_k8s-brightbox-ccm.Api.Example.com. 60 IN TXT "heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-api"`,
+		`www.example.com. 60 IN TXT "v=spf1 -all"`,
+		"example.com. 300 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 300",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rr)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(msg)
+			if msg.Question[0].Qtype == dns.TypeAXFR {
+				reply.Answer = records
+			} else {
+				reply.Rcode = dns.RcodeRefused
+			}
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	provider, err := newRFC2136Provider(&rfc2136Config{Server: listener.Addr().String()}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := provider.registryRecords(context.TODO())
+	if err != nil {
+		t.Fatalf("Error when not expected: %q", err.Error())
+	}
+	var result []string
+	for _, record := range found {
+		result = append(result, record.String())
+	}
+	expected := []string{
+		"_k8s-brightbox-ccm.www.example.com 60 TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-web",
+		"_k8s-brightbox-ccm.api.example.com 60 TXT heritage=k8s-brightbox-ccm,cluster=kubernetes,service=uid-api",
+	}
+	if diff := deep.Equal(result, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRFC2136NoServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := conn.LocalAddr().String()
+	conn.Close()
+	provider, err := newRFC2136Provider(&rfc2136Config{Server: server}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	err = provider.replaceRecords(ctx, []string{"www.example.com"}, nil)
+	expected := fmt.Sprintf("DNS update of zone \"example.com.\" via %s failed:", server)
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestRFC2136TSIG(t *testing.T) {
+	testCases := map[string]struct {
+		serverSecret string
+		unsigned     bool
+		status       string
+	}{
+		"signed": {
+			serverSecret: testTSIGSecret,
+		},
+		"reply signed with another key": {
+			serverSecret: "b3RoZXIta2V5",
+			status:       "DNS update of zone \"example.com.\" via",
+		},
+		"reply not signed": {
+			serverSecret: testTSIGSecret,
+			unsigned:     true,
+			status:       "DNS update of zone \"example.com.\" via",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server, received := testDNSServer(t, dns.RcodeSuccess, &testTSIGServer{secret: tc.serverSecret, unsigned: tc.unsigned})
+			provider, err := newRFC2136Provider(&rfc2136Config{
+				Server:      server,
+				TSIGKeyName: "K8s-Key",
+				TSIGSecret:  testTSIGSecret,
+			}, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = provider.replaceRecords(context.TODO(), []string{"www.example.com"}, nil)
+			if tc.status != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.status) {
+					t.Errorf("expected %q, got %v", tc.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			msg := <-received
+			tsig := msg.IsTsig()
+			if tsig == nil {
+				t.Fatal("expected the update to be signed")
+			}
+			if diff := deep.Equal([]any{tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge}, []any{testTSIGKey, dns.HmacSHA256, uint16(tsigFudge)}); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// testTSIGServer has the test server check the TSIG of each message
+// and sign its replies
+type testTSIGServer struct {
+	secret string
+	// unsigned replies are sent without a TSIG
+	unsigned bool
+}
+
+// testDNSServer answers every message sent to a local UDP port with
+// the rcode, and passes on the messages that pass any TSIG check.
+// Queries are answered from the records.
+func testDNSServer(t *testing.T, rcode int, tsig *testTSIGServer, records ...dns.RR) (string, <-chan *dns.Msg) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *dns.Msg, 10)
+	// Messages no test is waiting for are dropped
+	pass := func(msg *dns.Msg) {
+		select {
+		case received <- msg:
+		default:
+		}
+	}
+	server := &dns.Server{
+		PacketConn: conn,
+		// The default turns away UPDATE messages
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetRcode(msg, rcode)
+			if msg.Opcode == dns.OpcodeQuery && rcode == dns.RcodeSuccess {
+				reply.Rcode = dns.RcodeNameError
+				for _, rr := range records {
+					if !strings.EqualFold(rr.Header().Name, msg.Question[0].Name) {
+						continue
+					}
+					reply.Rcode = dns.RcodeSuccess
+					if rr.Header().Rrtype == msg.Question[0].Qtype {
+						reply.Answer = append(reply.Answer, rr)
+					}
+				}
+			}
+			if tsig != nil {
+				if err := w.TsigStatus(); err != nil {
+					reply.SetRcode(msg, dns.RcodeNotAuth)
+				} else {
+					pass(msg)
+				}
+				if !tsig.unsigned {
+					reply.SetTsig(testTSIGKey, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+				}
+			} else {
+				pass(msg)
+			}
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	if tsig != nil {
+		server.TsigSecret = map[string]string{testTSIGKey: tsig.secret}
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return conn.LocalAddr().String(), received
+}
+
+// describeUpdate returns the zone and a line for each change in an
+// UPDATE message
+func describeUpdate(t *testing.T, msg *dns.Msg) (string, []string) {
+	t.Helper()
+	if msg.Opcode != dns.OpcodeUpdate {
+		t.Errorf("expected an UPDATE, got opcode %d", msg.Opcode)
+	}
+	if len(msg.Question) != 1 || msg.Question[0].Qtype != dns.TypeSOA {
+		t.Fatalf("expected one zone, got %v", msg.Question)
+	}
+	var result []string
+	for _, rr := range msg.Ns {
+		h := rr.Header()
+		if h.Class == dns.ClassANY {
+			result = append(result, fmt.Sprintf("delete %s %s", h.Name, dns.TypeToString[h.Rrtype]))
+			continue
+		}
+		var value string
+		switch record := rr.(type) {
+		case *dns.A:
+			value = "A " + record.A.String()
+		case *dns.AAAA:
+			value = "AAAA " + record.AAAA.String()
+		case *dns.CNAME:
+			value = "CNAME " + record.Target
+		case *dns.TXT:
+			value = "TXT " + strings.Join(record.Txt, "")
+		default:
+			t.Fatalf("unexpected record type %s", dns.TypeToString[h.Rrtype])
+		}
+		result = append(result, fmt.Sprintf("add %s %d %s", h.Name, h.Ttl, value))
+	}
+	return msg.Question[0].Name, result
+}
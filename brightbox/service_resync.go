@@ -0,0 +1,243 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// Reasons a service's load balancer is re-synced
+const (
+	resyncCertificate   = "certificate"
+	resyncEndpointNodes = "endpoint-nodes"
+	resyncReadyNodes    = "ready-nodes"
+)
+
+// serviceResyncController re-syncs load balancers when something the
+// service controller doesn't watch changes. The service controller
+// only re-syncs a load balancer when the service or the list of nodes
+// changes, so services without a Brightbox class are queued here and
+// passed to EnsureLoadBalancer, and those with one are queued on the
+// class controller. Services are never changed to prompt a re-sync.
+type serviceResyncController struct {
+	cloud    *cloud
+	services corelisters.ServiceLister
+	nodes    corelisters.NodeLister
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	mu sync.Mutex
+	// seen holds the last value recorded for each reason, by service
+	seen map[string]map[string]string
+}
+
+// startServiceResyncController runs the resync controller until stop is
+// closed, once the service and node caches have synced
+func (c *cloud) startServiceResyncController(stop <-chan struct{}) {
+	klog.V(4).Info("startServiceResyncController called")
+	serviceInformer := c.informerFactory.Core().V1().Services()
+	nodeInformer := c.informerFactory.Core().V1().Nodes()
+	rc := &serviceResyncController{
+		cloud:    c,
+		services: serviceInformer.Lister(),
+		nodes:    nodeInformer.Lister(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "brightbox-service-resync"},
+		),
+		seen: make(map[string]map[string]string),
+	}
+	c.resync = rc
+	_, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: rc.forgetService,
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch services for re-syncs: %v", err)
+	}
+	synced := []cache.InformerSynced{serviceInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced}
+	go func() {
+		defer rc.queue.ShutDown()
+		if !cache.WaitForCacheSync(stop, synced...) {
+			return
+		}
+		ctx := wait.ContextForChannel(stop)
+		go wait.Until(func() {
+			for rc.processNextItem(ctx) {
+			}
+		}, time.Second, stop)
+		<-stop
+	}()
+}
+
+// queueServiceResync re-syncs the service's load balancer when value
+// differs from the last one recorded for the reason. Services with a
+// Brightbox class are handed to the class controller, which keeps its
+// own queue, so nothing is recorded for them here.
+func (c *cloud) queueServiceResync(service *v1.Service, reason string, value string) {
+	if c.resync == nil {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(service)
+	if err != nil {
+		klog.Errorf("Failed to queue service %s/%s: %v", service.Namespace, service.Name, err)
+		return
+	}
+	if c.hasBrightboxClass(service) && c.classQueue != nil {
+		klog.V(4).Infof("queueServiceResync (%s, %s=%s) on the class controller", key, reason, value)
+		c.resync.forget(key)
+		c.classQueue.Add(key)
+		return
+	}
+	if !c.resync.record(key, reason, value) {
+		return
+	}
+	klog.V(4).Infof("queueServiceResync (%s, %s=%s)", key, reason, value)
+	c.resync.queue.Add(key)
+}
+
+// record notes the value for the reason, reporting whether it changed
+func (rc *serviceResyncController) record(key string, reason string, value string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	values := rc.seen[key]
+	if values == nil {
+		values = make(map[string]string)
+		rc.seen[key] = values
+	}
+	if old, ok := values[reason]; ok && old == value {
+		return false
+	}
+	values[reason] = value
+	return true
+}
+
+// forget drops everything recorded for the service
+func (rc *serviceResyncController) forget(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.seen, key)
+}
+
+// forgetService drops what was recorded for a deleted service
+func (rc *serviceResyncController) forgetService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(service)
+	if err != nil {
+		return
+	}
+	rc.forget(key)
+}
+
+func (rc *serviceResyncController) processNextItem(ctx context.Context) bool {
+	key, quit := rc.queue.Get()
+	if quit {
+		return false
+	}
+	defer rc.queue.Done(key)
+	if err := rc.sync(ctx, key); err != nil {
+		klog.Errorf("Failed to re-sync load balancer for service %s: %v", key, err)
+		rc.queue.AddRateLimited(key)
+		return true
+	}
+	rc.queue.Forget(key)
+	return true
+}
+
+// sync re-syncs the load balancer of a single service. Services the
+// service controller hasn't built a load balancer for yet, or has
+// started to remove, are left to it.
+func (rc *serviceResyncController) sync(ctx context.Context, key string) error {
+	klog.V(4).Infof("serviceResyncController sync (%q)", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := rc.services.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		rc.forget(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if service.DeletionTimestamp != nil ||
+		service.Spec.Type != v1.ServiceTypeLoadBalancer ||
+		!servicehelper.HasLBFinalizer(service) ||
+		rc.cloud.serviceMode(service) == modeNone {
+		return nil
+	}
+	nodes, err := loadBalancerNodes(rc.nodes)
+	if err != nil {
+		return err
+	}
+	status, err := rc.cloud.EnsureLoadBalancer(ctx, rc.cloud.ClusterName(), service, nodes)
+	if err != nil {
+		return err
+	}
+	return rc.cloud.updateLoadBalancerStatus(service, status)
+}
+
+// lockService stops the service controller and the controller's own
+// queues working on the same service at once
+func (c *cloud) lockService(service *v1.Service) func() {
+	if c.serviceLocks == nil {
+		return func() {}
+	}
+	key := string(service.UID)
+	c.serviceLocks.LockKey(key)
+	return func() {
+		_ = c.serviceLocks.UnlockKey(key)
+	}
+}
+
+// setServiceAnnotation patches an annotation on the service. Nothing is
+// written if the annotation already has the value.
+func (c *cloud) setServiceAnnotation(ctx context.Context, service *v1.Service, annotation string, value string) error {
+	if service.Annotations[annotation] == value {
+		return nil
+	}
+	klog.V(4).Infof("setServiceAnnotation (%s/%s, %s=%s)", service.Namespace, service.Name, annotation, value)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotation: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeClient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
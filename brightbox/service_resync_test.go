@@ -0,0 +1,151 @@
+// Copyright 2026 Brightbox Systems Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brightbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brightbox/k8ssdk/v2"
+	"github.com/go-test/deep"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+)
+
+func TestQueueServiceResync(t *testing.T) {
+	classQueue := workqueue.NewTyped[string]()
+	client := &cloud{resync: testServiceResyncController(t), classQueue: classQueue}
+	plain := testCloudIPService()
+	plain.Name = "plain"
+	plain.Spec.LoadBalancerClass = nil
+	client.queueServiceResync(plain, resyncCertificate, "1")
+	client.queueServiceResync(testCloudIPService(), resyncCertificate, "1")
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string{"default/plain"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(queuedKeys(classQueue), []string{"default/smtp"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Only a change of value queues the service again
+	client.queueServiceResync(plain, resyncCertificate, "1")
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string(nil)); diff != nil {
+		t.Error(diff)
+	}
+	client.queueServiceResync(plain, resyncCertificate, "2")
+	if diff := deep.Equal(queuedKeys(client.resync.queue), []string{"default/plain"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Nothing is kept for services handed to the class controller
+	if _, ok := client.resync.seen["default/smtp"]; ok {
+		t.Errorf("expected nothing recorded for the class service, got %v", client.resync.seen)
+	}
+
+	// Deleted services are forgotten
+	client.resync.forgetService(cache.DeletedFinalStateUnknown{Key: "default/plain", Obj: plain})
+	if len(client.resync.seen) != 0 {
+		t.Errorf("expected the service to be forgotten, got %v", client.resync.seen)
+	}
+}
+
+func TestServiceResyncSync(t *testing.T) {
+	testCases := map[string]struct {
+		finalizers []string
+		mapped     bool
+	}{
+		"built by the service controller": {
+			finalizers: []string{servicehelper.LoadBalancerCleanupFinalizer},
+			mapped:     true,
+		},
+		"not built yet": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := testCloudIPService()
+			service.Spec.LoadBalancerClass = nil
+			service.Annotations[serviceAnnotationLoadBalancerMode] = modeNameCloudIP
+			service.Finalizers = tc.finalizers
+			kubeClient := fake.NewSimpleClientset(service)
+			fake := newFakeCloudIPCloud()
+			client := &cloud{
+				Cloud:      k8ssdk.MakeTestClient(fake, nil),
+				kubeClient: kubeClient,
+			}
+			rc := testServiceResyncController(t)
+			rc.cloud = client
+			rc.services = testServiceLister(t, service)
+			rc.nodes = testNodeLister(t, testNode("node-a", "srv-aaaaa"))
+			if err := rc.sync(context.TODO(), "default/smtp"); err != nil {
+				t.Fatalf("Error when not expected: %q", err.Error())
+			}
+			result, err := kubeClient.CoreV1().Services("default").Get(context.TODO(), "smtp", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.mapped {
+				if len(fake.calls) != 0 || len(result.Status.LoadBalancer.Ingress) != 0 {
+					t.Errorf("expected the service to be left alone, got %v", fake.calls)
+				}
+				return
+			}
+			expectedStatus := v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: "109.107.50.1", IPMode: &vipIPMode}},
+			}
+			if diff := deep.Equal(result.Status.LoadBalancer, expectedStatus); diff != nil {
+				t.Error(diff)
+			}
+			if diff := deep.Equal(fake.cloudIPs[len(fake.cloudIPs)-1].Server.ID, "srv-aaaaa"); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	// Gone services are forgotten
+	rc := testServiceResyncController(t)
+	rc.services = testServiceLister(t)
+	rc.record("default/smtp", resyncCertificate, "1")
+	if err := rc.sync(context.TODO(), "default/smtp"); err != nil {
+		t.Errorf("Error when not expected: %q", err.Error())
+	}
+	if len(rc.seen) != 0 {
+		t.Errorf("expected the service to be forgotten, got %v", rc.seen)
+	}
+}
+
+func testServiceResyncController(t *testing.T) *serviceResyncController {
+	t.Helper()
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]())
+	t.Cleanup(queue.ShutDown)
+	return &serviceResyncController{
+		queue: queue,
+		seen:  make(map[string]map[string]string),
+	}
+}
+
+// queuedKeys takes everything waiting on the queue
+func queuedKeys(queue workqueue.TypedInterface[string]) []string {
+	var result []string
+	for queue.Len() > 0 {
+		key, _ := queue.Get()
+		queue.Done(key)
+		result = append(result, key)
+	}
+	return result
+}
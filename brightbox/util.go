@@ -17,6 +17,7 @@ package brightbox
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
@@ -102,28 +103,49 @@ func mapServerIDToNodeProviderID(name string) *v1.Node {
 	return result
 }
 
-func nodeAddressesFromServer(srv *brightbox.Server) ([]v1.NodeAddress, error) {
+// nodeAddressesFromServer lists the server's interface IPv4 addresses
+// as InternalIPs, its interface IPv6 addresses as the configured type
+// and its mapped Cloud IPs as ExternalIPs, in the order and families
+// set by the config
+func nodeAddressesFromServer(srv *brightbox.Server, cfg *nodeAddressesConfig) ([]v1.NodeAddress, error) {
 	addresses := []v1.NodeAddress{
 		{Type: v1.NodeHostName, Address: srv.Hostname},
 		{Type: v1.NodeExternalDNS, Address: srv.Fqdn},
 	}
-	for _, iface := range srv.Interfaces {
-		ipv4Node, err := parseIPString(iface.IPv4Address, "IPv4", srv.ID, "Server", v1.NodeExternalIP)
-		if err != nil {
-			return nil, err
+	for _, family := range cfg.families() {
+		for _, iface := range srv.Interfaces {
+			ipString := iface.IPv4Address
+			addressType := v1.NodeInternalIP
+			if family == ipv6Family {
+				ipString = iface.IPv6Address
+				addressType = cfg.ipv6AddressType()
+			}
+			node, err := parseIPString(ipString, familyNames[family], srv.ID, "Server", addressType)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, *node)
 		}
-		ipv6Node, err := parseIPString(iface.IPv6Address, "IPv6", srv.ID, "Server", v1.NodeExternalIP)
-		if err != nil {
-			return nil, err
-		}
-		addresses = append(addresses, *ipv4Node, *ipv6Node)
+	}
+	if !cfg.cloudIPs() {
+		return addresses, nil
 	}
 	for _, cip := range srv.CloudIPs {
-		ipv4Node, err := parseIPString(cip.PublicIP, "IPv4", cip.ID, "Cloud IP", v1.NodeExternalIP)
-		if err != nil {
-			return nil, err
+		for _, family := range cfg.families() {
+			ipString := cip.PublicIP
+			if family == ipv6Family {
+				ipString = cip.PublicIPv6
+			}
+			if ipString == "" {
+				continue
+			}
+			node, err := parseIPString(ipString, familyNames[family], cip.ID, "Cloud IP", v1.NodeExternalIP)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, *node)
 		}
-		addresses = append(addresses, *ipv4Node, v1.NodeAddress{Type: v1.NodeExternalDNS, Address: cip.Fqdn})
+		addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalDNS, Address: cip.Fqdn})
 	}
 	return addresses, nil
 }
@@ -199,6 +221,40 @@ func getListenerProxyProtocol(apiservice *v1.Service) proxyprotocol.Enum {
 	return defaultProxyProtocol
 }
 
+// getPortListenerProtocol returns the protocol for the port from the
+// per-port annotation, falling back to the service wide protocol
+func getPortListenerProtocol(apiservice *v1.Service, port *v1.ServicePort, protocols *portMap) listenerprotocol.Enum {
+	if protocol, ok := protocols.lookup(port); ok {
+		if protocolEnum, err := listenerprotocol.ParseEnum(protocol); err == nil {
+			return protocolEnum
+		}
+	}
+	return getListenerProtocol(apiservice)
+}
+
+// getPortListenerProxyProtocol returns the proxy protocol for the port
+// from the per-port annotation, falling back to the service wide value
+func getPortListenerProxyProtocol(apiservice *v1.Service, port *v1.ServicePort, protocols *portMap) proxyprotocol.Enum {
+	if protocol, ok := protocols.lookup(port); ok {
+		if protocolEnum, err := proxyprotocol.ParseEnum(protocol); err == nil {
+			return protocolEnum
+		}
+	}
+	return getListenerProxyProtocol(apiservice)
+}
+
+// getPortListenerIdleTimeout returns the idle timeout for the port from
+// the per-port annotation, falling back to the service wide value
+func getPortListenerIdleTimeout(apiservice *v1.Service, port *v1.ServicePort, timeouts *portMap) uint {
+	if timeout, ok := timeouts.lookup(port); ok {
+		if val, err := strconv.ParseUint(timeout, 10, maxBits); err == nil {
+			return uint(val)
+		}
+	}
+	result, _ := parseUintAnnotation(apiservice.Annotations, serviceAnnotationLoadBalancerListenerIdleTimeout)
+	return result
+}
+
 func isSSLPort(port *v1.ServicePort, sslPorts *portSets) bool {
 	return port.Port == standardSSLPort ||
 		sslPorts != nil && (sslPorts.numbers.Has(int64(port.Port)) || sslPorts.names.Has(port.Name))
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	brightbox "github.com/brightbox/gobrightbox/v2"
+	"github.com/go-test/deep"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -130,37 +131,70 @@ func TestNodeAddressesFromServer(t *testing.T) {
 		Hostname: "host123",
 		Fqdn:     "host123.example.com",
 		Interfaces: []brightbox.Interface{
-			{IPv4Address: "192.168.0.1", IPv6Address: "2001:db8::ff00:42:8329"},
+			{IPv4Address: "10.241.0.1", IPv6Address: "2001:db8::ff00:42:8329"},
 		},
 		CloudIPs: []brightbox.CloudIP{
-			{PublicIP: "198.51.100.1", Fqdn: "cloudip.example.com"},
+			{PublicIP: "198.51.100.1", PublicIPv6: "2001:db8::c633:6401", Fqdn: "cloudip.example.com"},
 		},
 	}
-
-	addresses, err := nodeAddressesFromServer(server)
-	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
-	}
-
-	expectedAddresses := []struct {
-		Type    v1.NodeAddressType
-		Address string
+	off := false
+	testCases := map[string]struct {
+		config   *nodeAddressesConfig
+		expected []v1.NodeAddress
 	}{
-		{v1.NodeHostName, "host123"},
-		{v1.NodeExternalDNS, "host123.example.com"},
-		{v1.NodeExternalIP, "192.168.0.1"},
-		{v1.NodeExternalIP, "2001:db8::ff00:42:8329"},
-		{v1.NodeExternalIP, "198.51.100.1"},
-		{v1.NodeExternalDNS, "cloudip.example.com"},
-	}
-
-	if len(addresses) != len(expectedAddresses) {
-		t.Fatalf("Expected %d addresses, got %d", len(expectedAddresses), len(addresses))
+		"default": {
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "host123"},
+				{Type: v1.NodeExternalDNS, Address: "host123.example.com"},
+				{Type: v1.NodeInternalIP, Address: "10.241.0.1"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::ff00:42:8329"},
+				{Type: v1.NodeExternalIP, Address: "198.51.100.1"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::c633:6401"},
+				{Type: v1.NodeExternalDNS, Address: "cloudip.example.com"},
+			},
+		},
+		"ipv6 first": {
+			config: &nodeAddressesConfig{Families: []string{ipv6Family, ipv4Family}},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "host123"},
+				{Type: v1.NodeExternalDNS, Address: "host123.example.com"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::ff00:42:8329"},
+				{Type: v1.NodeInternalIP, Address: "10.241.0.1"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::c633:6401"},
+				{Type: v1.NodeExternalIP, Address: "198.51.100.1"},
+				{Type: v1.NodeExternalDNS, Address: "cloudip.example.com"},
+			},
+		},
+		"internal ipv6": {
+			config: &nodeAddressesConfig{IPv6AddressType: v1.NodeInternalIP},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "host123"},
+				{Type: v1.NodeExternalDNS, Address: "host123.example.com"},
+				{Type: v1.NodeInternalIP, Address: "10.241.0.1"},
+				{Type: v1.NodeInternalIP, Address: "2001:db8::ff00:42:8329"},
+				{Type: v1.NodeExternalIP, Address: "198.51.100.1"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::c633:6401"},
+				{Type: v1.NodeExternalDNS, Address: "cloudip.example.com"},
+			},
+		},
+		"ipv4 only without cloud ips": {
+			config: &nodeAddressesConfig{Families: []string{ipv4Family}, CloudIPs: &off},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "host123"},
+				{Type: v1.NodeExternalDNS, Address: "host123.example.com"},
+				{Type: v1.NodeInternalIP, Address: "10.241.0.1"},
+			},
+		},
 	}
-
-	for i, expected := range expectedAddresses {
-		if addresses[i].Type != expected.Type || addresses[i].Address != expected.Address {
-			t.Errorf("Expected address %d to be %v, got %v", i, expected, addresses[i])
-		}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			addresses, err := nodeAddressesFromServer(server, tc.config)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if diff := deep.Equal(addresses, tc.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
 	}
 }
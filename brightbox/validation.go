@@ -16,15 +16,19 @@ package brightbox
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/brightbox/gobrightbox/v2/enums/balancingpolicy"
 	"github.com/brightbox/gobrightbox/v2/enums/healthchecktype"
 	"github.com/brightbox/gobrightbox/v2/enums/listenerprotocol"
 	"github.com/brightbox/gobrightbox/v2/enums/proxyprotocol"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog/v2"
 )
 
@@ -48,27 +52,91 @@ func parseUintAnnotation(annotationList map[string]string, annotation string) (u
 }
 
 func validateServiceSpec(apiservice *v1.Service) error {
-	if apiservice.Spec.SessionAffinity != v1.ServiceAffinityNone {
+	switch apiservice.Spec.SessionAffinity {
+	case v1.ServiceAffinityNone:
+	case v1.ServiceAffinityClientIP:
+		// ClientIP affinity is provided by the source-address policy
+		if policy, ok := apiservice.Annotations[serviceAnnotationLoadBalancerPolicy]; ok && policy != balancingpolicy.SourceAddress.String() {
+			return fmt.Errorf("%v session affinity requires the %q load balancer policy, but %q is set to %q", apiservice.Spec.SessionAffinity, balancingpolicy.SourceAddress, serviceAnnotationLoadBalancerPolicy, policy)
+		}
+	default:
 		return fmt.Errorf("unsupported load balancer affinity: %v", apiservice.Spec.SessionAffinity)
 	}
 	if len(apiservice.Spec.Ports) == 0 {
 		return fmt.Errorf("requested load balancer with no ports")
 	}
-	protocol := getListenerProtocol(apiservice)
-	sslPortFound := false
+	// Clients never reach the nodes, so a load balancer can't enforce
+	// source ranges. Only Cloud IP mode can.
+	if len(apiservice.Spec.LoadBalancerSourceRanges) > 0 {
+		return fmt.Errorf("Brightbox load balancers can't restrict clients. Remove spec.loadBalancerSourceRanges, or set %q to %q to use a Cloud IP that can", serviceAnnotationLoadBalancerMode, modeNameCloudIP)
+	}
 	for _, port := range apiservice.Spec.Ports {
 		if port.Protocol != v1.ProtocolTCP {
 			return fmt.Errorf("UDP nodeports are not supported")
 		}
-		sslPortFound = sslPortFound || port.Port == standardSSLPort
 	}
-	if !sslPortFound && protocol == listenerprotocol.Http {
+	for _, annotation := range []string{
+		serviceAnnotationLoadBalancerListenerPortProtocols,
+		serviceAnnotationLoadBalancerListenerPortProxyProtocols,
+		serviceAnnotationLoadBalancerListenerPortIdleTimeouts,
+	} {
+		ports, err := getPortMap(apiservice.Annotations[annotation])
+		if err != nil {
+			return fmt.Errorf("%q is invalid: %w", annotation, err)
+		}
+		if port, ok := ports.unknownPort(apiservice.Spec.Ports); ok {
+			return fmt.Errorf("%q refers to port %q, which the service does not define", annotation, port)
+		}
+	}
+	// SSL needs a 443 listener whenever any port has an HTTP listener,
+	// going by each port's own protocol. A 443 port with a TCP listener
+	// can't terminate SSL.
+	protocols, _ := getPortMap(apiservice.Annotations[serviceAnnotationLoadBalancerListenerPortProtocols])
+	httpPortFound := false
+	sslPortFound := false
+	for i := range apiservice.Spec.Ports {
+		port := &apiservice.Spec.Ports[i]
+		protocol := getPortListenerProtocol(apiservice, port, protocols)
+		httpPortFound = httpPortFound || protocol == listenerprotocol.Http
+		sslPortFound = sslPortFound || (port.Port == standardSSLPort && protocol != listenerprotocol.Tcp)
+	}
+	if httpPortFound && !sslPortFound {
 		_, ports := apiservice.Annotations[serviceAnnotationLoadBalancerSSLPorts]
 		_, domains := apiservice.Annotations[serviceAnnotationLoadBalancerSslDomains]
-		if ports || domains {
+		_, secret := apiservice.Annotations[serviceAnnotationLoadBalancerSSLCertificateSecret]
+		if ports || domains || secret {
 			return fmt.Errorf("SSL support requires a Port definition for %d", standardSSLPort)
 		}
 	}
+	return validateServiceAddressing(apiservice)
+}
+
+// validateCloudIPServiceSpec checks a service in Cloud IP mode. Port
+// translators forward UDP as well as TCP, and there are no listeners
+// to check.
+func validateCloudIPServiceSpec(apiservice *v1.Service) error {
+	if len(apiservice.Spec.Ports) == 0 {
+		return fmt.Errorf("requested Cloud IP with no ports")
+	}
+	for _, port := range apiservice.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
+			return fmt.Errorf("%v nodeports are not supported", port.Protocol)
+		}
+	}
+	if len(cloudIPAllocations(apiservice)) > 1 {
+		return fmt.Errorf("%q can only list one Cloud IP in Cloud IP mode", serviceAnnotationLoadBalancerCloudipAllocations)
+	}
+	return validateServiceAddressing(apiservice)
+}
+
+// validateServiceAddressing checks the source ranges, Cloud IP
+// selection and annotations common to every mode
+func validateServiceAddressing(apiservice *v1.Service) error {
+	for _, sourceRange := range apiservice.Spec.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(sourceRange)); err != nil {
+			return fmt.Errorf("Invalid load balancer source range %q: %w", sourceRange, err)
+		}
+	}
 	// CloudIP allocation annotation and spec.loadBalancerIP conflict
 	if apiservice.Spec.LoadBalancerIP != "" {
 		if _, ok := apiservice.Annotations[serviceAnnotationLoadBalancerCloudipAllocations]; ok {
@@ -97,14 +165,51 @@ func validateAnnotations(annotationList map[string]string) error {
 				if _, ok := annotationList[serviceAnnotationLoadBalancerSslDomains]; ok {
 					return fmt.Errorf("SSL Domains are not supported with the %s protocol", valueEnum)
 				}
+				if _, ok := annotationList[serviceAnnotationLoadBalancerSSLCertificateSecret]; ok {
+					return fmt.Errorf("SSL Certificates are not supported with the %s protocol", valueEnum)
+				}
 			}
 		case serviceAnnotationLoadBalancerListenerProxyProtocol:
 			if _, err := proxyprotocol.ParseEnum(value); err != nil {
 				return fmt.Errorf("Invalid Load Balancer Listener Proxy Protocol %q: %w", value, err)
 			}
+		case serviceAnnotationLoadBalancerListenerPortProtocols:
+			if err := validatePortMap(annotation, value, func(v string) error {
+				_, err := listenerprotocol.ParseEnum(v)
+				return err
+			}); err != nil {
+				return err
+			}
+		case serviceAnnotationLoadBalancerListenerPortProxyProtocols:
+			if err := validatePortMap(annotation, value, func(v string) error {
+				_, err := proxyprotocol.ParseEnum(v)
+				return err
+			}); err != nil {
+				return err
+			}
+		case serviceAnnotationLoadBalancerListenerPortIdleTimeouts:
+			if err := validatePortMap(annotation, value, func(v string) error {
+				_, err := strconv.ParseUint(v, 10, maxBits)
+				return err
+			}); err != nil {
+				return err
+			}
 		case serviceAnnotationLoadBalancerSSLPorts:
-			if _, ok := annotationList[serviceAnnotationLoadBalancerSslDomains]; !ok {
-				return fmt.Errorf("SSL needs a list of domains to certify. Add the %q annotation", serviceAnnotationLoadBalancerSslDomains)
+			_, domains := annotationList[serviceAnnotationLoadBalancerSslDomains]
+			_, secret := annotationList[serviceAnnotationLoadBalancerSSLCertificateSecret]
+			if !domains && !secret {
+				return fmt.Errorf("SSL needs a list of domains to certify or a certificate secret. Add the %q or %q annotation", serviceAnnotationLoadBalancerSslDomains, serviceAnnotationLoadBalancerSSLCertificateSecret)
+			}
+		case serviceAnnotationLoadBalancerReverseDNS:
+			if errs := validation.IsDNS1123Subdomain(canonicalName(value)); len(errs) > 0 {
+				return fmt.Errorf("%q needs to be a valid domain name: %s", annotation, strings.Join(errs, ", "))
+			}
+		case serviceAnnotationLoadBalancerSSLCertificateSecret:
+			if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+				return fmt.Errorf("%q needs to be a valid Secret name: %s", annotation, strings.Join(errs, ", "))
+			}
+			if _, ok := annotationList[serviceAnnotationLoadBalancerSslDomains]; ok {
+				return fmt.Errorf("%q cannot be used with %q", annotation, serviceAnnotationLoadBalancerSslDomains)
 			}
 		case serviceAnnotationLoadBalancerHCProtocol:
 			if _, err := healthchecktype.ParseEnum(value); err != nil {
@@ -125,11 +230,47 @@ func validateAnnotations(annotationList map[string]string) error {
 			if err != nil || u.Path != value {
 				return fmt.Errorf("%q needs to be a valid Url request path", annotation)
 			}
+		case serviceAnnotationLoadBalancerStatusMode:
+			if !slices.Contains(validStatusModes, value) {
+				return fmt.Errorf("%q needs to be one of %v", annotation, validStatusModes)
+			}
+		case serviceAnnotationLoadBalancerMode:
+			if !slices.Contains(validModeNames, value) {
+				return fmt.Errorf("%q needs to be one of %v", annotation, validModeNames)
+			}
+		case serviceAnnotationLoadBalancerCloudIPTarget:
+			if !slices.Contains(validCloudIPTargets, value) {
+				return fmt.Errorf("%q needs to be one of %v", annotation, validCloudIPTargets)
+			}
+		case serviceAnnotationLoadBalancerCloudipRetain, serviceAnnotationLoadBalancerSkipDomainResolution:
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("%q needs to be true or false", annotation)
+			}
+		case serviceAnnotationLoadBalancerName:
+			if value == "" || len(value) > maxLoadBalancerNameLength {
+				return fmt.Errorf("%q needs to be between 1 and %d characters long", annotation, maxLoadBalancerNameLength)
+			}
 		case serviceAnnotationLoadBalancerCloudipAllocations:
-			if !cloudIPPattern.MatchString(value) {
-				return fmt.Errorf("%q needs to match the pattern %q", annotation, cloudIPPattern)
+			for _, entry := range strings.Split(value, ",") {
+				if !cloudIPPattern.MatchString(strings.TrimSpace(entry)) {
+					return fmt.Errorf("%q needs to match the pattern %q", annotation, cloudIPPattern)
+				}
 			}
 		}
 	}
 	return nil
 }
+
+// validatePortMap checks each value of a per-port annotation
+func validatePortMap(annotation string, value string, check func(string) error) error {
+	ports, err := getPortMap(value)
+	if err != nil {
+		return fmt.Errorf("%q is invalid: %w", annotation, err)
+	}
+	for _, portValue := range ports.values() {
+		if err := check(portValue); err != nil {
+			return fmt.Errorf("%q is invalid: %w", annotation, err)
+		}
+	}
+	return nil
+}
@@ -206,7 +206,7 @@ func (f *fakeZoneCloud) Server(_ context.Context, identifier string) (*brightbox
 
 func makeFakeMetadataClient(zoneName string) *cloud {
 	return &cloud{
-		k8ssdk.MakeTestClient(
+		Cloud: k8ssdk.MakeTestClient(
 			nil,
 			fakeZoneMetadataClient(zoneName),
 		),
@@ -215,7 +215,7 @@ func makeFakeMetadataClient(zoneName string) *cloud {
 
 func makeFakeZoneCloudClient() *cloud {
 	return &cloud{
-		k8ssdk.MakeTestClient(
+		Cloud: k8ssdk.MakeTestClient(
 			fakeZoneCloudClient(context.TODO()),
 			nil,
 		),
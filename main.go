@@ -57,6 +57,13 @@ func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 		klog.Fatalf("Cloud provider is nil")
 	}
 
+	if named, ok := cloud.(interface{ ClusterName() string }); ok {
+		clusterName := config.ComponentConfig.KubeCloudShared.ClusterName
+		if named.ClusterName() != clusterName {
+			klog.Fatalf("the cloud config clusterName %q does not match --cluster-name %q. Set them to the same value", named.ClusterName(), clusterName)
+		}
+	}
+
 	if !cloud.HasClusterID() {
 		if config.ComponentConfig.KubeCloudShared.AllowUntaggedCloud {
 			klog.Warning("detected a cluster without a ClusterID.  A ClusterID will be required in the future.  Please tag your cluster to avoid any future issues")